@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// localizedNames are the names of functions in the French, German, Italian
+// and Spanish versions of other spreadsheets, so that sheets written for
+// them evaluate as they are. Names with a dot, like MOIS.DECALER, are left
+// out.
+var localizedNames = map[string][]string{
+	"SUM":         {"SOMME", "SUMME", "SOMMA", "SUMA"},
+	"AVERAGE":     {"MOYENNE", "MITTELWERT", "MEDIA", "PROMEDIO"},
+	"MEDIAN":      {"MEDIANE", "MEDIANA"},
+	"PRODUCT":     {"PRODUIT", "PRODUKT", "PRODOTTO", "PRODUCTO"},
+	"IF":          {"SI", "WENN", "SE"},
+	"AND":         {"ET", "UND", "E", "Y"},
+	"OR":          {"OU", "ODER", "O"},
+	"NOT":         {"NON", "NICHT", "NO"},
+	"MATCH":       {"EQUIV", "VERGLEICH", "CONFRONTA", "COINCIDIR"},
+	"INDEX":       {"INDICE"},
+	"CONCATENATE": {"CONCATENER", "VERKETTEN", "CONCATENA", "CONCATENAR"},
+	"LEFT":        {"GAUCHE", "LINKS", "SINISTRA", "IZQUIERDA"},
+	"RIGHT":       {"DROITE", "RECHTS", "DESTRA", "DERECHA"},
+	"UPPER":       {"MAJUSCULE", "GROSS", "MAIUSC", "MAYUSC"},
+	"LOWER":       {"MINUSCULE", "KLEIN", "MINUSC"},
+	"LEN":         {"NBCAR", "LÄNGE", "LUNGHEZZA", "LARGO"},
+	"ISERROR":     {"ESTERREUR", "ISTFEHLER", "ESERROR"},
+	"ISBLANK":     {"ESTVIDE", "ISTLEER", "ESBLANCO"},
+	"ISNUMBER":    {"ESTNUM", "ISTZAHL", "ESNUMERO"},
+	"EDATE":       {"EDATUM"},
+}
+
+func init() {
+	for name, aliases := range localizedNames {
+		for _, alias := range aliases {
+			RegisterAlias(alias, name)
+		}
+	}
+
+	flag.Func("aliases", "file of ALIAS=NAME lines, making functions callable by other names", loadAliases)
+}
+
+// loadAliases registers the aliases inside a file, one `ALIAS=NAME` pair per
+// line. Empty lines and lines starting with `#` are skipped.
+func loadAliases(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		alias, name, ok := strings.Cut(line, "=")
+		alias, name = strings.TrimSpace(alias), strings.TrimSpace(name)
+		if !ok || !paramNameRegexp.MatchString(alias) {
+			return fmt.Errorf("%s:%d: expected ALIAS=NAME, got %q", path, n, line)
+		}
+		if _, ok := lookupFunc(name); !ok {
+			return fmt.Errorf("%s:%d: unknown function %s", path, n, name)
+		}
+		RegisterAlias(alias, name)
+	}
+	return s.Err()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAliases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aliases.txt")
+	content := "# Portuguese\nSOMA = SUM\n\nMEDIA_PT=average\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadAliases(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		funcs.Lock()
+		delete(funcs.aliases, "SOMA")
+		delete(funcs.aliases, "MEDIA_PT")
+		funcs.Unlock()
+	}()
+
+	table := parseTable("1|2|=soma(A0:B0)|=MEDIA_PT(A0:B0)")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	if got := table[0][2].Content + " " + table[0][3].Content; got != "3.00 1.50" {
+		t.Errorf("got %s", got)
+	}
+
+	if err := os.WriteFile(path, []byte("SOMA=SUMA2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadAliases(path); err == nil || err.Error() != path+":1: unknown function SUMA2" {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// batchResult is the outcome of evaluating one of the sheets of a batch.
+type batchResult struct {
+	file   string
+	output bytes.Buffer
+	err    error
+}
+
+// evalBatch evaluates every sheet matching pattern, up to jobs at a time,
+// writing each one to a file with the same name inside outDir, or to w one
+// after the other without it. Successes and failures are reported to report
+// once every sheet is done.
+func evalBatch(w, report io.Writer, pattern, outDir string, jobs int) error {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no sheets match %q", pattern)
+	}
+	if outDir != "" {
+		seen := make(map[string]string)
+		for _, file := range files {
+			if other, ok := seen[filepath.Base(file)]; ok {
+				return fmt.Errorf("%s and %s would both be written to %s", other, file, filepath.Join(outDir, filepath.Base(file)))
+			}
+			seen[filepath.Base(file)] = file
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
+		}
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]batchResult, len(files))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for k, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *batchResult, file string) {
+			defer func() { <-sem; wg.Done() }()
+			r.file = file
+			r.err = evalBatchSheet(&r.output, file, outDir)
+		}(&results[k], file)
+	}
+	wg.Wait()
+
+	failed := 0
+	for k := range results {
+		r := &results[k]
+		if outDir == "" {
+			if _, err := r.output.WriteTo(w); err != nil {
+				return err
+			}
+		}
+		if r.err == nil {
+			fmt.Fprintf(report, "ok   %s\n", r.file)
+			continue
+		}
+		failed++
+		errs, ok := r.err.(evalErrors)
+		if !ok {
+			errs = evalErrors{r.err}
+		}
+		fmt.Fprintf(report, "FAIL %s\n", r.file)
+		for _, err := range errs {
+			fmt.Fprintf(report, "     %v\n", err)
+		}
+	}
+	fmt.Fprintf(report, "%d sheets, %d failed\n", len(files), failed)
+	if failed > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// evalBatchSheet evaluates a single sheet of a batch into output, saving it
+// inside outDir too when given. Like `minicel eval`, sheets whose cells
+// fail are still written, with #ERROR in them.
+func evalBatchSheet(output *bytes.Buffer, file, outDir string) error {
+	c, err := readSheet(file)
+	if err != nil {
+		return err
+	}
+	evalErr := runSheet(output, c)
+	if outDir != "" && output.Len() > 0 {
+		if err := ioutil.WriteFile(filepath.Join(outDir, filepath.Base(file)), output.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return evalErr
+}
@@ -0,0 +1,42 @@
+//go:build !js
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvalBatch(t *testing.T) {
+	dir := t.TempDir()
+	sheets := map[string]string{
+		"a.mcl": "A|B\n1|=A1*2",
+		"b.mcl": "A\n=1/0\n=NOPE()",
+		"c.mcl": "A\n3",
+	}
+	for name, content := range sheets {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "build")
+	var report strings.Builder
+	if err := evalBatch(nil, &report, filepath.Join(dir, "*.mcl"), out, 2); err != errFailed {
+		t.Errorf("got %v, want errFailed", err)
+	}
+	want := "ok   DIR/a.mcl\nFAIL DIR/b.mcl\n     A2: unknown function NOPE\nok   DIR/c.mcl\n3 sheets, 1 failed\n"
+	if got := strings.ReplaceAll(report.String(), dir, "DIR"); got != want {
+		t.Errorf("got report %q, want %q", got, want)
+	}
+
+	// Sheets whose cells fail are written too
+	for name, want := range map[string]string{"a.mcl": "A   |B\n1.00|2.00\n", "b.mcl": "A\n#DIV/0!\n#ERROR\n"} {
+		got, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
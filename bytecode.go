@@ -0,0 +1,530 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxPrograms is how many compiled formulas are kept at once, after which
+// they're compiled again as needed.
+const maxPrograms = 1 << 14
+
+// programs are the compiled formulas, keyed by appendFormulaKey, so that the
+// clones of a formula, whose references are all shifted by the same amount,
+// share the same program.
+var programs = struct {
+	sync.RWMutex
+	m map[string]*program
+}{m: make(map[string]*program)}
+
+type opcode uint8
+
+const (
+	opConst  opcode = iota // push consts[a]
+	opIdent                // push the value of idents[a], like a parameter or TRUE
+	opCell                 // push the value of the cell refs[a]
+	opRange                // push the values of the cells in ranges[a]
+	opBinary               // pop y and x, push x tok y, refs[a] and refs[b] naming them
+	opUnary                // pop x, push tok x, refs[a] naming it
+	opFunc                 // look up the function names[a]
+	opTry                  // until a, turn errors into values for the function looked up last
+	opEndTry               // stop turning errors into values
+	opCall                 // pop a arguments and call the function looked up last, named names[b]
+	opPick                 // continue from the argument picks[a] picks among the ones on the stack
+	opPut                  // pop x and put it in place of the argument a values below the top
+	opJump                 // continue from a
+	opFail                 // fail with errs[a]
+)
+
+type instr struct {
+	op   opcode
+	tok  token.Token
+	a, b int
+}
+
+// progRef is a reference inside a program, relative to the cell holding the
+// formula unless anchored.
+type progRef struct {
+	ref   cellRef
+	lower bool
+}
+
+// resolve returns the cell the reference points to from row i and column j.
+func (r progRef) resolve(i, j int) cellRef {
+	ref := r.ref
+	if !ref.AbsRow {
+		ref.Row += i
+	}
+	if !ref.AbsCol {
+		ref.Col += j
+	}
+	return ref
+}
+
+// name returns how the reference is written inside the formula at row i and
+// column j.
+func (r progRef) name(i, j int) string {
+	name := r.resolve(i, j).String()
+	if r.lower {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+type progRange struct {
+	from, to progRef
+	// Whole columns and rows aren't relative, they're written as name
+	whole bool
+	name  string
+}
+
+// A program is a formula compiled to instructions for a stack machine, so
+// that evaluating it again, like for each of its clones, doesn't walk its
+// syntax tree. It evaluates formulas like evalExpr, errors included.
+type program struct {
+	code   []instr
+	consts []Value
+	idents []*ast.Ident
+	refs   []progRef
+	ranges []progRange
+	names  []string
+	picks  []progPick
+	errs   []error
+	depth  int
+}
+
+// progPick picks the argument of a function like IF to evaluate, all of its
+// arguments being on the stack, the ones not picked yet left empty.
+type progPick struct {
+	picker argPicker
+	// starts are where the code of each argument not picked yet starts, and
+	// end where the call does
+	starts []int
+	end    int
+}
+
+// compiledFormula returns the program of the formula of the cell at row i
+// and column j, compiling it the first time. Formulas that can't be
+// compiled, like malformed ones, have none, and are left to evalExpr.
+func compiledFormula(formula string, i, j int) *program {
+	var buf [128]byte
+	key := appendFormulaKey(buf[:0], formula, i, j)
+	programs.RLock()
+	p, ok := programs.m[string(key)]
+	programs.RUnlock()
+	if ok {
+		return p
+	}
+
+	if expr, err := parseFormula(formula); err == nil {
+		p = compile(expr, i, j)
+	}
+	programs.Lock()
+	if len(programs.m) >= maxPrograms {
+		programs.m = make(map[string]*program)
+	}
+	programs.m[string(key)] = p
+	programs.Unlock()
+	return p
+}
+
+// appendFormulaKey appends the formula to buf with its references written
+// relative to row i and column j, unless anchored, so that clones of the
+// same formula have the same key.
+func appendFormulaKey(buf []byte, formula string, i, j int) []byte {
+	key, _ := appendRefTokens(buf, formula, func(b []byte, ref cellRef, token string) ([]byte, error) {
+		b = append(b, 0)
+		if ref.AbsCol {
+			b = append(b, '$')
+			b = strconv.AppendInt(b, int64(ref.Col), 10)
+		} else {
+			b = strconv.AppendInt(b, int64(ref.Col-j), 10)
+		}
+		b = append(b, ',')
+		if ref.AbsRow {
+			b = append(b, '$')
+			b = strconv.AppendInt(b, int64(ref.Row), 10)
+		} else {
+			b = strconv.AppendInt(b, int64(ref.Row-i), 10)
+		}
+		if isLowerRef(token) {
+			b = append(b, 'l')
+		}
+		return b, nil
+	})
+	return key
+}
+
+// isLowerRef tells whether the column of a reference is written lowercase.
+func isLowerRef(token string) bool {
+	return unicode.IsLower(rune(strings.TrimPrefix(token, "$")[0]))
+}
+
+// compile compiles the formula of the cell at row i and column j, returning
+// nil when it can't.
+func compile(expr ast.Expr, i, j int) *program {
+	c := &compiler{program: &program{}, i: i, j: j}
+	if !c.compile(expr) {
+		return nil
+	}
+	return c.program
+}
+
+type compiler struct {
+	*program
+	i, j  int
+	stack int
+}
+
+func (c *compiler) emit(in instr, push int) {
+	c.code = append(c.code, in)
+	if c.stack += push; c.stack > c.depth {
+		c.depth = c.stack
+	}
+}
+
+// relative returns the reference written as token, relative to the cell
+// holding the formula.
+func (c *compiler) relative(ref cellRef, token string) progRef {
+	if !ref.AbsRow {
+		ref.Row -= c.i
+	}
+	if !ref.AbsCol {
+		ref.Col -= c.j
+	}
+	return progRef{ref: ref, lower: isLowerRef(token)}
+}
+
+// ref adds a reference to the program, returning its index.
+func (c *compiler) ref(ref cellRef, token string) int {
+	c.refs = append(c.refs, c.relative(ref, token))
+	return len(c.refs) - 1
+}
+
+// operandRef returns the index of the reference expr is, or -1 if it isn't
+// one.
+func (c *compiler) operandRef(expr ast.Expr) int {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if ref, ok := scanRef(ident.Name); ok {
+			return c.ref(ref, ident.Name)
+		}
+	}
+	return -1
+}
+
+// constants returns the values of the last n instructions, from start on,
+// when they're all constants, like the operands of `2*24` inside `=2*24*A1`.
+func (c *compiler) constants(start, n int) ([]Value, bool) {
+	if len(c.code)-start != n {
+		return nil, false
+	}
+	values := make([]Value, n)
+	for k, in := range c.code[start:] {
+		if in.op != opConst {
+			return nil, false
+		}
+		values[k] = c.consts[in.a]
+	}
+	return values, true
+}
+
+// fold replaces the constants from start on with their result, computed
+// while compiling rather than every time the formula is evaluated. Only
+// operations on constants that succeed are folded, the others fail when
+// evaluated, like they would otherwise.
+func (c *compiler) fold(start int, value Value) {
+	c.stack -= len(c.code) - start
+	c.consts = c.consts[:c.code[start].a]
+	c.code = c.code[:start]
+	c.consts = append(c.consts, value)
+	c.emit(instr{op: opConst, a: len(c.consts) - 1}, 1)
+}
+
+// noNames names no operand, since constants aren't references.
+func noNames(int) string { return "" }
+
+func (c *compiler) fail(err error) {
+	c.errs = append(c.errs, err)
+	c.emit(instr{op: opFail, a: len(c.errs) - 1}, 1)
+}
+
+func (c *compiler) compile(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if ref, ok := scanRef(e.Name); ok {
+			c.emit(instr{op: opCell, a: c.ref(ref, e.Name)}, 1)
+			return true
+		}
+		if strings.Contains(e.Name, rangeSep) {
+			// Their errors tell how they're written, which clones change
+			return false
+		}
+		if strings.Contains(e.Name, ":") {
+			r := progRange{name: e.Name}
+			if from, to, ok := parseWholeRange(e.Name); ok {
+				r.whole = true
+				r.from, r.to = progRef{ref: from}, progRef{ref: to}
+			} else if from, to, err := parseRange(e.Name); err == nil {
+				a, b, _ := strings.Cut(e.Name, ":")
+				r.from, r.to = c.relative(from, a), c.relative(to, b)
+			} else {
+				return false
+			}
+			c.ranges = append(c.ranges, r)
+			c.emit(instr{op: opRange, a: len(c.ranges) - 1}, 1)
+			return true
+		}
+		c.idents = append(c.idents, e)
+		c.emit(instr{op: opIdent, a: len(c.idents) - 1}, 1)
+	case *ast.ParenExpr:
+		return c.compile(e.X)
+	case *ast.BinaryExpr:
+		x, y := c.operandRef(e.X), c.operandRef(e.Y)
+		start := len(c.code)
+		if !c.compile(e.X) || !c.compile(e.Y) {
+			return false
+		}
+		if args, ok := c.constants(start, 2); ok {
+			if value, err := evalBinary(e.Op, args[0], args[1], noNames); err == nil {
+				c.fold(start, value)
+				return true
+			}
+		}
+		c.emit(instr{op: opBinary, tok: e.Op, a: x, b: y}, -1)
+	case *ast.UnaryExpr:
+		if e.Op != token.SUB && e.Op != token.ADD {
+			c.fail(fmt.Errorf("couldn't parse expr"))
+			return true
+		}
+		x := c.operandRef(e.X)
+		start := len(c.code)
+		if !c.compile(e.X) {
+			return false
+		}
+		if args, ok := c.constants(start, 1); ok {
+			if value, err := evalUnary(e.Op, args[0], noNames); err == nil {
+				c.fold(start, value)
+				return true
+			}
+		}
+		c.emit(instr{op: opUnary, tok: e.Op, a: x}, 0)
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			text, err := strconv.Unquote(e.Value)
+			if err != nil {
+				return false
+			}
+			c.consts = append(c.consts, TextValue(text))
+			c.emit(instr{op: opConst, a: len(c.consts) - 1}, 1)
+			return true
+		}
+		n, err := parseNumber(e.Value)
+		if err != nil {
+			c.fail(err)
+			return true
+		}
+		c.consts = append(c.consts, NumberValue(n))
+		c.emit(instr{op: opConst, a: len(c.consts) - 1}, 1)
+	case *ast.CallExpr:
+		// Function names looking like references are shifted by formulaKey
+		ident, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		if _, isRef := scanRef(ident.Name); isRef {
+			return false
+		}
+		c.names = append(c.names, ident.Name)
+		name := len(c.names) - 1
+		c.emit(instr{op: opFunc, a: name}, 0)
+		catches := catchesErrors[funcName(ident.Name)]
+		if picker, ok := picksArgs[funcName(ident.Name)]; ok && !catches {
+			if !c.compilePicked(picker, e.Args) {
+				return false
+			}
+			c.emit(instr{op: opCall, a: len(e.Args), b: name}, 1-len(e.Args))
+			return true
+		}
+		for _, arg := range e.Args {
+			if !catches {
+				if !c.compile(arg) {
+					return false
+				}
+				continue
+			}
+			try := len(c.code)
+			c.emit(instr{op: opTry}, 0)
+			if !c.compile(arg) {
+				return false
+			}
+			c.emit(instr{op: opEndTry}, 0)
+			c.code[try].a = len(c.code)
+		}
+		c.emit(instr{op: opCall, a: len(e.Args), b: name}, 1-len(e.Args))
+	default:
+		c.fail(fmt.Errorf("couldn't parse expr"))
+	}
+	return true
+}
+
+// compilePicked compiles the arguments of a function picking one of them,
+// which are evaluated only when picked, like evalExpr does. The keys come
+// first, then the others, each followed by a jump to the call.
+func (c *compiler) compilePicked(picker argPicker, args []ast.Expr) bool {
+	n := len(args)
+	pick := progPick{picker: picker, starts: make([]int, n)}
+	for k, arg := range args {
+		if picker.key(k, n) {
+			if !c.compile(arg) {
+				return false
+			}
+			continue
+		}
+		c.consts = append(c.consts, Value{})
+		c.emit(instr{op: opConst, a: len(c.consts) - 1}, 1)
+	}
+	c.picks = append(c.picks, pick)
+	index := len(c.picks) - 1
+	c.emit(instr{op: opPick, a: index}, 0)
+
+	var jumps []int
+	for k, arg := range args {
+		if picker.key(k, n) {
+			continue
+		}
+		c.picks[index].starts[k] = len(c.code)
+		if !c.compile(arg) {
+			return false
+		}
+		c.emit(instr{op: opPut, a: n - 1 - k}, -1)
+		jumps = append(jumps, len(c.code))
+		c.emit(instr{op: opJump}, 0)
+	}
+	c.picks[index].end = len(c.code)
+	for _, jump := range jumps {
+		c.code[jump].a = len(c.code)
+	}
+	return true
+}
+
+// eval evaluates the program for the cell at row i and column j.
+func (p *program) eval(table Table, i, j int) (Value, error) {
+	type handler struct{ end, stack, fns int }
+	stack := make([]Value, 0, p.depth)
+	var fns []Func
+	var handlers []handler
+
+	for pc := 0; pc < len(p.code); pc++ {
+		in := p.code[pc]
+		var value Value
+		var err error
+		switch in.op {
+		case opConst:
+			value = p.consts[in.a]
+		case opIdent:
+			value, err = evalExpr(table, p.idents[in.a])
+		case opCell:
+			r := p.refs[in.a]
+			var cell Cell
+			var name string
+			if r.lower {
+				name = r.name(i, j)
+			}
+			if cell, err = cellAt(table, r.resolve(i, j), name); err == nil {
+				if value, err = valueOf(cell); err == errNotEvaluated {
+					err = fmt.Errorf("%s %w", r.name(i, j), err)
+				}
+			}
+		case opRange:
+			r := p.ranges[in.a]
+			if r.whole {
+				value, err = rangeAt(table, r.from.ref, r.to.ref, r.name)
+				break
+			}
+			var name string
+			if r.from.lower || r.to.lower {
+				name = r.from.name(i, j) + ":" + r.to.name(i, j)
+			}
+			value, err = rangeAt(table, r.from.resolve(i, j), r.to.resolve(i, j), name)
+		case opBinary:
+			x, y := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			value, err = evalBinary(in.tok, x, y, func(side int) string {
+				if side == 0 {
+					return p.refName(in.a, i, j)
+				}
+				return p.refName(in.b, i, j)
+			})
+		case opUnary:
+			x := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			value, err = evalUnary(in.tok, x, func(int) string { return p.refName(in.a, i, j) })
+		case opFunc:
+			fn, ok := lookupFunc(p.names[in.a])
+			if !ok {
+				err = fmt.Errorf("unknown function %s", p.names[in.a])
+				break
+			}
+			fns = append(fns, fn)
+			continue
+		case opTry:
+			handlers = append(handlers, handler{end: in.a, stack: len(stack), fns: len(fns)})
+			continue
+		case opEndTry:
+			handlers = handlers[:len(handlers)-1]
+			continue
+		case opCall:
+			args := make([]Value, in.a)
+			copy(args, stack[len(stack)-in.a:])
+			stack = stack[:len(stack)-in.a]
+			fn := fns[len(fns)-1]
+			fns = fns[:len(fns)-1]
+			value, err = evalCall(p.names[in.b], fn, args)
+		case opPick:
+			pick := p.picks[in.a]
+			n := len(pick.starts)
+			pc = pick.end - 1
+			if k := pick.picker.pick(stack[len(stack)-n:]); k >= 0 && !pick.picker.key(k, n) {
+				pc = pick.starts[k] - 1
+			}
+			continue
+		case opPut:
+			stack[len(stack)-2-in.a] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			continue
+		case opJump:
+			pc = in.a - 1
+			continue
+		case opFail:
+			err = p.errs[in.a]
+		}
+
+		if err != nil {
+			// Like in evalExpr, the arguments of functions catching errors
+			// get them as values
+			if len(handlers) == 0 {
+				return Value{}, err
+			}
+			h := handlers[len(handlers)-1]
+			handlers = handlers[:len(handlers)-1]
+			stack, fns, pc = stack[:h.stack], fns[:h.fns], h.end-1
+			value = ErrorValue(err)
+		}
+		stack = append(stack, value)
+	}
+	return stack[0], nil
+}
+
+// refName returns how the reference refs[ref] is written inside the formula
+// at row i and column j, or nothing if ref is -1.
+func (p *program) refName(ref, i, j int) string {
+	if ref < 0 {
+		return ""
+	}
+	return p.refs[ref].name(i, j)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompiledFormulas(t *testing.T) {
+	if err := paramsVar.Set("rate=0.25"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(paramsVar, "rate")
+
+	table := parseTable("Item|Qty|Price|When\nPens|2|1.5|2024-01-05\nInk||3|8h\nPaper|x|#DIV/0!|\n|||\n|||")
+	formulas := []string{
+		"B1*C1", "b1*c1", "$B$1*C1", "B$1+$C2", "-B1", "+B1", "!B1", "B1 < C1",
+		"B2*2", "a1*2", "B1/0", "(B1+C1)*rate", "TRUE", "\"kg\"", "1e400", "#REF!",
+		"SUM(B1:C2)", "SUM(b1:C2)*2", "SUM(B:B)", "SUM(1:2)", "SUM(A1:Z9)", "SUM(B1:B2:C3)", "SUM(A1:B)",
+		"ISERROR(C3)", "ISERROR(X1X)", "ISERROR(SUM(X1X))", "ISERROR(NOPE(1))", "NOPE(B1)", "SUM(C3, 1)",
+		"IF(ISERROR(B1/0), 1, 2)", "D1+D2", "D1-D1", "Z1", "A99", "B1.C", "pens", "ROUND(C1*B1, 0)",
+		"2*24*B1", "-(3-1)*B1", "(1/0)+B1", "\"a\"*2+B1", "\"8h\"+\"2h\"", "B1*2*24", "ISERROR(1/0)",
+		"B1^2", "2^B1", `"a"&B1`, "B1>=C1", "B1<>C1", "D1=D2", "D1<D2", `B1&""=2`, "(-1)^0.5", "IFERROR(1/0; 2)",
+		"IF(B1=2, 1, 1/0)", "IF(B1=0, 1/0, C1)", "IF(ISNUMBER(B3), B3*2, 0)", "IF(B1, 1/0)", "IF(B1=2, X1X)",
+		"IFERROR(IF(B1=2, 1/0, 1), 3)", "IF(B1=2)", "IF(1/0, 1, 2)", "SUM(IF(B1=0, 1, 2), IF(B1=2, 3), 4)",
+		"CHOOSE(2, 1/0, 5)", "CHOOSE(B1, 1/0, C1, 3)", "CHOOSE(9, 1)", "CHOOSE()", "CHOOSE(1)", "SWITCH(B1, 1, 1/0, 2, C1, 0)",
+		"SWITCH(A1, \"pens\", 1, 1/0)", "SWITCH(B1, 1/0, 1)", "SWITCH(B1, 7, 1/0)", "SWITCH(B1:C1, 1, 2)",
+	}
+	for _, formula := range formulas {
+		for _, pos := range [][2]int{{4, 1}, {5, 2}} {
+			i, j := pos[0], pos[1]
+			expr, err := parseFormula(formula)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, wantErr := evalExpr(table, expr)
+			p := compiledFormula(formula, i, j)
+			if p == nil {
+				continue
+			}
+			got, err := p.eval(table, i, j)
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) || !reflect.DeepEqual(got, want) {
+				t.Errorf("=%s at %s = %v, %v, want %v, %v", formula, cellName(i, j), got, err, want, wantErr)
+			}
+		}
+	}
+
+	// Clones share the program of the formula they were cloned from
+	if compiledFormula("B1*C1", 4, 3) != compiledFormula("B2*C2", 5, 3) {
+		t.Error("=B1*C1 and its clone below were compiled twice")
+	}
+	if compiledFormula("$B$1*C1", 4, 3) == compiledFormula("$B$2*C2", 5, 3) {
+		t.Error("=$B$1*C1 and =$B$2*C2 share the same program")
+	}
+}
+
+func BenchmarkEvalClonedFormula(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("A|B|C\n1|2|=A1*B1+SUM(A1:B1)/2\n")
+	for k := 0; k < 10000; k++ {
+		sb.WriteString("1|2|:^\n")
+	}
+	table, err := loadSheet(sb.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := evalTable(table.copy()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		formula string
+		code    int
+	}{
+		{"2*24*A1", 3},
+		{"-(3-1)*A1", 3},
+		{"1/0+A1", 3},
+		{"-2", 1},
+		// Not folded: it fails when evaluated, and A1*2*24 is (A1*2)*24
+		{"\"a\"*2+A1", 5},
+		{"A1*2*24", 5},
+	}
+	for _, tt := range tests {
+		p := compiledFormula(tt.formula, 1, 1)
+		if p == nil || len(p.code) != tt.code {
+			t.Errorf("=%s compiled to %v, want %d instructions", tt.formula, p, tt.code)
+		}
+	}
+}
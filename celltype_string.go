@@ -13,11 +13,12 @@ func _() {
 	_ = x[Number-2]
 	_ = x[Expression-3]
 	_ = x[Clone-4]
+	_ = x[Command-5]
 }
 
-const _CellType_name = "EmptyTextNumberExpressionClone"
+const _CellType_name = "EmptyTextNumberExpressionCloneCommand"
 
-var _CellType_index = [...]uint8{0, 5, 9, 15, 25, 30}
+var _CellType_index = [...]uint8{0, 5, 9, 15, 25, 30, 37}
 
 func (i CellType) String() string {
 	if i < 0 || i >= CellType(len(_CellType_index)-1) {
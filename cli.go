@@ -0,0 +1,530 @@
+//go:build !js
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is one of the subcommands of minicel, like `minicel eval`. Each
+// one parses its own flags from args.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"eval", "evaluate a sheet and print it", evalCommand},
+		{"render", "evaluate a sheet and print it as text, JSON, HTML, CSV or YAML", renderCommand},
+		{"convert", "convert a table between formats, evaluating it on the way", convertCommand},
+		{"export", "evaluate a sheet and write it into a SQLite database", exportCommand},
+		{"check", "evaluate sheets, only reporting their errors", checkCommand},
+		{"fmt", "rewrite sheets in a canonical way, without evaluating them", fmtCommand},
+		{"insert", "insert rows or columns into a sheet, moving the references", editCommand("insert", true)},
+		{"delete", "delete rows or columns from a sheet, moving the references", editCommand("delete", false)},
+		{"copy", "copy a range of a sheet, moving the relative references", blockCommand("copy", false)},
+		{"move", "move a range of a sheet, along with the references to it", blockCommand("move", true)},
+		{"deps", "list the cells depending on a cell", depsCommand},
+		{"stats", "count the rows, columns and kinds of cells of a sheet", statsCommand},
+		{"repl", "evaluate formulas typed one per line against a sheet", replCommand},
+		{"sql", "query an evaluated sheet with SQL", sqlCommand},
+		{"serve", "serve sheets over HTTP", serve},
+		{"daemon", "keep sheets loaded, answering requests over a Unix socket", daemonCommand},
+		{"gsheets", "pull or push sheets from and to Google Sheets", gsheetsCommand},
+		{"lsp", "speak the Language Server Protocol over stdin and stdout", lspCommand},
+		{"test", "run the golden tests inside a directory", testCommand},
+		{"help", "show the help of a command", helpCommand},
+	}
+}
+
+// errFailed makes minicel exit with status 1 without reporting anything,
+// because the command already did.
+var errFailed = errors.New("failed")
+
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// runCLI runs the command named by the first argument. Anything else is
+// taken as a sheet to evaluate, like `minicel eval` does, so that
+// `minicel sheet.mcl` keeps working.
+func runCLI(args []string) error {
+	if len(args) < 1 {
+		flag.Usage()
+		return errFailed
+	}
+	if cmd, ok := lookupCommand(args[0]); ok {
+		return cmd.run(args[1:])
+	}
+	return evalCommand(args)
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "usage: minicel [flags] command [arguments]")
+	fmt.Fprintln(w, "       minicel [flags] sheet")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `minicel help command` for the flags of a command. Flags:")
+	flag.PrintDefaults()
+}
+
+// sheetFlagSet returns the flags of a command evaluating sheets, which
+// accepts every global flag too, like `minicel eval -pp sheet.mcl`.
+func sheetFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: minicel %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// checkFlags validates the global flags taking one of a few values.
+func checkFlags() error {
+	if *alignmentVar != "left" && *alignmentVar != "center" && *alignmentVar != "right" {
+		return fmt.Errorf("invalid alignment %q, expected left, center or right", *alignmentVar)
+	}
+	if *nanVar != "error" && *nanVar != "literal" {
+		return fmt.Errorf("invalid NaN policy %q, expected error or literal", *nanVar)
+	}
+	if *roundVar != "half-up" && *roundVar != "half-even" && *roundVar != "truncate" {
+		return fmt.Errorf("invalid rounding mode %q, expected half-up, half-even or truncate", *roundVar)
+	}
+	if _, ok := outLocales[*outLocaleVar]; *outLocaleVar != "" && !ok {
+		return fmt.Errorf("unknown locale %q, expected one of %s", *outLocaleVar, outLocaleNames())
+	}
+	if *sigfigsVar < 0 {
+		return fmt.Errorf("invalid number of significant figures %d", *sigfigsVar)
+	}
+	return nil
+}
+
+// parseSheetFlags parses the flags of a command evaluating sheets, which
+// expects between min and max arguments (-1 for no maximum).
+func parseSheetFlags(fs *flag.FlagSet, args []string, min, max int) error {
+	fs.Parse(args)
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return errFailed
+	}
+	return checkFlags()
+}
+
+func evalCommand(args []string) error {
+	fs := sheetFlagSet("eval", "sheet | -glob pattern")
+	glob := fs.String("glob", "", "evaluate every sheet matching the pattern instead, like 'reports/*.mcl'")
+	outDir := fs.String("out-dir", "", "with -glob, write each sheet to a file with the same name inside this directory")
+	jobs := fs.Int("j", 1, "with -glob, how many sheets to evaluate at a time")
+	cell := fs.String("cell", "", "only print the value of this cell, like B12, evaluating only the cells it depends on")
+	formula := fs.String("e", "", "only print the value of this formula, like '=SUM(B:B)', evaluating only the cells it depends on")
+	if err := parseSheetFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+	query := *cell != "" || *formula != ""
+	if (*glob != "") == (fs.NArg() == 1) || (*glob != "" && query) || (*cell != "" && *formula != "") {
+		fs.Usage()
+		return errFailed
+	}
+	if *glob != "" {
+		return evalBatch(os.Stdout, os.Stderr, *glob, *outDir, *jobs)
+	}
+
+	c, err := readSheet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if query {
+		return querySheet(os.Stdout, c, *cell, *formula)
+	}
+	return runSheet(os.Stdout, c)
+}
+
+// querySheet writes the value of a single cell of the sheet, or of a
+// formula like replEval does, evaluating only the cells it depends on.
+func querySheet(w io.Writer, c, name, formula string) error {
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+
+	var queue [][2]int
+	row, col := -1, -1
+	if name != "" {
+		if row, col, err = parseCellName(name); err != nil {
+			return err
+		}
+		if row >= len(table) || col >= len(table[row]) {
+			return fmt.Errorf("cell %s out of bounds", name)
+		}
+		queue = append(queue, [2]int{row, col})
+	} else {
+		refs, err := formulaRefs(table, formula)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			queue = append(queue, [2]int{ref.Row, ref.Col})
+		}
+	}
+
+	evalErr := evalCells(table, precedents(table, queue))
+	if name != "" {
+		fmt.Fprintln(w, localizeTable(Table{{table[row][col]}})[0][0].Content)
+		return evalErr
+	}
+	if err := replEval(w, table, strings.TrimPrefix(formula, "=")); err != nil {
+		return err
+	}
+	return evalErr
+}
+
+func renderCommand(args []string) error {
+	fs := sheetFlagSet("render", "sheet")
+	as := fs.String("as", "text", "output format: text, json, html, csv or yaml")
+	if err := parseSheetFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	var render func(w io.Writer, table Table) error
+	switch *as {
+	case "text":
+		render = func(w io.Writer, table Table) error {
+			dumpTable(w, table)
+			return nil
+		}
+	case "json":
+		render = renderJSON
+	case "html":
+		render = func(w io.Writer, table Table) error {
+			renderHTML(w, table)
+			return nil
+		}
+	case "csv":
+		dialect, err := flagDialect()
+		if err != nil {
+			return err
+		}
+		render = dialect.writeCSV
+	case "yaml":
+		render = renderYAML
+	default:
+		return fmt.Errorf("unknown format %q, expected text, json, html, csv or yaml", *as)
+	}
+
+	c, err := readSheet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	evalErr := evalTable(table)
+	if err := render(os.Stdout, localizeTable(table)); err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// convertCommand implements `minicel convert`, reading a table from a file
+// or the standard input, evaluating it and writing it in another format.
+func convertCommand(args []string) error {
+	fs := sheetFlagSet("convert", "[file | url]")
+	from := fs.String("from", "mcl", "format of the input: "+formatNames(true))
+	to := fs.String("to", "mcl", "format of the output: "+formatNames(false))
+	if err := parseSheetFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	in, ok := tableFormats[*from]
+	if !ok || in.parse == nil {
+		return fmt.Errorf("can't read %q, expected one of %s", *from, formatNames(true))
+	}
+	out, ok := tableFormats[*to]
+	if !ok || out.write == nil {
+		return fmt.Errorf("can't write %q, expected one of %s", *to, formatNames(false))
+	}
+
+	var c string
+	if fs.NArg() == 1 && (strings.HasPrefix(fs.Arg(0), "http://") || strings.HasPrefix(fs.Arg(0), "https://")) {
+		var err error
+		if c, err = fetchURL(fs.Arg(0)); err != nil {
+			return err
+		}
+	} else if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		var err error
+		if c, err = readSheet(fs.Arg(0)); err != nil {
+			return err
+		}
+	} else {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if c, err = decodeSource(b, *encodingVar); err != nil {
+			return fmt.Errorf("stdin: %w", err)
+		}
+	}
+	return convert(os.Stdout, c, in, out)
+}
+
+// exportCommand implements `minicel export`, writing the evaluated sheet
+// into a new SQLite database for querying it with other tools.
+func exportCommand(args []string) error {
+	fs := sheetFlagSet("export", "-sqlite file sheet")
+	out := fs.String("sqlite", "", "the SQLite database to create, which must not exist yet")
+	name := fs.String("table", "sheet", "the name of the table holding the sheet")
+	if err := parseSheetFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	if *out == "" {
+		fs.Usage()
+		return errFailed
+	}
+
+	c, err := readSheet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	evalErr := evalTable(table)
+
+	f, err := os.OpenFile(*out, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if err := writeSQLite(f, table, *name); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// checkCommand evaluates every sheet without printing them, reporting each
+// error prefixed by the file it comes from.
+func checkCommand(args []string) error {
+	fs := sheetFlagSet("check", "sheet...")
+	if err := parseSheetFlags(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	failed := false
+	for _, file := range fs.Args() {
+		if err := checkSheet(file); err != nil {
+			failed = true
+			errs, ok := err.(evalErrors)
+			if !ok {
+				errs = evalErrors{err}
+			}
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			}
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+func checkSheet(file string) error {
+	c, err := readSheet(file)
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	return evalTable(table)
+}
+
+func statsCommand(args []string) error {
+	fs := sheetFlagSet("stats", "sheet")
+	if err := parseSheetFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	c, err := readSheet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	source := table.copy()
+	writeStats(os.Stdout, source, evalTable(table))
+	return nil
+}
+
+// writeStats writes how big the table is, how many cells of each type it
+// has before being evaluated and how many of them failed.
+func writeStats(w io.Writer, table Table, evalErr error) {
+	cols, cells := 0, 0
+	types := make(map[CellType]int)
+	for _, row := range table {
+		if len(row) > cols {
+			cols = len(row)
+		}
+		cells += len(row)
+		for _, cell := range row {
+			types[cell.Type]++
+		}
+	}
+
+	fmt.Fprintf(w, "rows: %d\n", len(table))
+	fmt.Fprintf(w, "columns: %d\n", cols)
+	fmt.Fprintf(w, "cells: %d\n", cells)
+
+	var names []string
+	counts := make(map[string]int)
+	for t, n := range types {
+		names = append(names, strings.ToLower(t.String()))
+		counts[strings.ToLower(t.String())] = n
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %d\n", name, counts[name])
+	}
+
+	errs, _ := evalErr.(evalErrors)
+	fmt.Fprintf(w, "errors: %d\n", len(errs))
+}
+
+func replCommand(args []string) error {
+	fs := sheetFlagSet("repl", "[sheet]")
+	if err := parseSheetFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	table := Table{}
+	if fs.NArg() == 1 {
+		c, err := readSheet(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if table, err = loadSheet(c); err != nil {
+			return err
+		}
+		// Formulas referencing the cells that fail get #ERROR
+		if errs, ok := evalTable(table).(evalErrors); ok {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	return runREPL(os.Stdin, os.Stdout, table)
+}
+
+// runREPL evaluates each line read from r as a formula against the table,
+// with or without the leading =, writing the result or the error to w.
+func runREPL(r io.Reader, w io.Writer, table Table) error {
+	scanner := bufio.NewScanner(r)
+	fmt.Fprint(w, "> ")
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			if err := replEval(w, table, strings.TrimPrefix(line, "=")); err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+			}
+		}
+		fmt.Fprint(w, "> ")
+	}
+	fmt.Fprintln(w)
+	return scanner.Err()
+}
+
+func replEval(w io.Writer, table Table, formula string) error {
+	expr, err := parseFormula(formula)
+	if err != nil {
+		return err
+	}
+	value, err := parseExpr(table, expr)
+	if err != nil {
+		return err
+	}
+	if value.Kind() != ArrayKind {
+		fmt.Fprintln(w, value.Text())
+		return nil
+	}
+
+	// Ranges are written like tables
+	var rows Table
+	for _, row := range value.Rows() {
+		var cells []Cell
+		for _, v := range row {
+			cell, err := v.cell()
+			if err != nil {
+				return err
+			}
+			cells = append(cells, cell)
+		}
+		rows = append(rows, cells)
+	}
+	dumpTable(w, rows)
+	return nil
+}
+
+func lspCommand(args []string) error {
+	return runLSP(os.Stdin, os.Stdout)
+}
+
+func testCommand(args []string) error {
+	dir := "testdata"
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	ok, err := runGoldenTests(os.Stdout, dir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errFailed
+	}
+	return nil
+}
+
+func helpCommand(args []string) error {
+	if len(args) == 0 {
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return nil
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q, run `minicel help` for the list", args[0])
+	}
+	return cmd.run([]string{"-h"})
+}
@@ -0,0 +1,73 @@
+//go:build !js
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	table := parseTable("A|B\n1|2\n3|4")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runREPL(strings.NewReader("=SUM(A1:B2)\n\nA1 * 10\nA1:B2\n=Z9\n"), &out, table); err != nil {
+		t.Fatal(err)
+	}
+	want := "> 10\n> > 10\n> 1.00|2.00\n3.00|4.00\n> error: #REF! Z9 is out of bounds, the table spans A0:B2\n> \n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQuerySheet(t *testing.T) {
+	source := "Item|Qty|Total\nPens|2|=B1*3\nInk|x|=B2*3\nAll|=SUM(B1:B1)|=C1*2"
+	tests := []struct {
+		cell, formula string
+		want          string
+	}{
+		{"C3", "", "12.00\n"},
+		{"", "=C1+B3", "8\n"},
+		{"", "=B1:C1", "2.00|6.00\n"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		// C2 fails, but none of them depend on it
+		if err := querySheet(&out, source, tt.cell, tt.formula); err != nil || out.String() != tt.want {
+			t.Errorf("querySheet(%q, %q) = %q, %v, want %q", tt.cell, tt.formula, out.String(), err, tt.want)
+		}
+	}
+
+	if err := querySheet(&strings.Builder{}, source, "C2", ""); err == nil {
+		t.Error("expected querying C2 to fail")
+	}
+}
+
+func TestStats(t *testing.T) {
+	table := parseTable("A|B|\n1|=A1*2\n=A1+B0|")
+	source := table.copy()
+
+	var out strings.Builder
+	writeStats(&out, source, evalTable(table))
+	want := `rows: 3
+columns: 3
+cells: 7
+empty: 2
+expression: 2
+number: 1
+text: 2
+errors: 1
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRunCLI(t *testing.T) {
+	if err := runCLI([]string{"help", "nope"}); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("got %v for an unknown command", err)
+	}
+}
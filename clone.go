@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Dir int
+
+const (
+	Up Dir = iota + 1
+	Right
+	Down
+	Left
+	UpLeft
+	UpRight
+)
+
+var charToDir = map[byte]Dir{
+	'^':  Up,
+	'>':  Right,
+	'v':  Down,
+	'<':  Left,
+	'\\': UpLeft,
+	'/':  UpRight,
+}
+
+// dirOffsets holds the row and column offsets of the cell cloned in every
+// direction, relative to the clone itself.
+var dirOffsets = map[Dir][2]int{
+	Up:      {-1, 0},
+	Right:   {0, 1},
+	Down:    {1, 0},
+	Left:    {0, -1},
+	UpLeft:  {-1, -1},
+	UpRight: {-1, 1},
+}
+
+var repeatRegexp = regexp.MustCompile(`^:([v>])\*(\d+)$`)
+var fillRegexp = regexp.MustCompile(`^:fill\(\s*([A-Za-z]\d+)\s*:\s*([A-Za-z]\d+)\s*,\s*(\S\+?)\s*\)$`)
+
+// expandDirectives replaces repeated clones and fill directives with the
+// single clones they stand for.
+func expandDirectives(table Table) error {
+	for i, row := range table {
+		for j, cell := range row {
+			if cell.Type != Clone {
+				continue
+			}
+			if m := repeatRegexp.FindStringSubmatch(cell.Content); m != nil {
+				if err := expandRepeat(table, i, j, m); err != nil {
+					return err
+				}
+			} else if m := fillRegexp.FindStringSubmatch(cell.Content); m != nil {
+				if err := expandFill(table, i, j, m); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expandRepeat replaces a repeated clone like `:v*10` with as many single
+// clones, filling the empty cells in the direction of the arrow with copies
+// of the cell preceding the directive.
+func expandRepeat(table Table, i, j int, m []string) error {
+	count, err := strconv.Atoi(m[2])
+	if err != nil || count < 1 {
+		return fmt.Errorf("%s: invalid repeat count %q", cellName(i, j), m[2])
+	}
+
+	clone := Cell{Content: ":^", Type: Clone}
+	di, dj := 1, 0
+	if m[1] == ">" {
+		clone.Content = ":<"
+		di, dj = 0, 1
+	}
+
+	for k := 0; k < count; k++ {
+		ti, tj := i+k*di, j+k*dj
+		if ti >= len(table) || tj >= len(table[ti]) {
+			return fmt.Errorf("%s: cannot repeat clone %d times, the table ends at %s", cellName(i, j), count, cellName(ti-di, tj-dj))
+		}
+		if k > 0 && table[ti][tj].Type != Empty {
+			return fmt.Errorf("%s: repeated clone would overwrite %s", cellName(i, j), cellName(ti, tj))
+		}
+		table[ti][tj] = clone
+	}
+	return nil
+}
+
+// expandFill replaces a directive like `:fill(B2:B50, ^)` with a clone in the
+// given direction for every cell of the range. The directive itself is left
+// empty unless it lies inside the range.
+func expandFill(table Table, i, j int, m []string) error {
+	fromRow, fromCol, err := parseCellName(m[1])
+	if err != nil {
+		return fmt.Errorf("%s: %w", cellName(i, j), err)
+	}
+	toRow, toCol, err := parseCellName(m[2])
+	if err != nil {
+		return fmt.Errorf("%s: %w", cellName(i, j), err)
+	}
+	if fromRow > toRow || fromCol > toCol {
+		return fmt.Errorf("%s: invalid fill range %s:%s", cellName(i, j), m[1], m[2])
+	}
+	if _, ok := charToDir[m[3][0]]; !ok || len(m[3]) > 1 && m[3][1:] != "+" {
+		return fmt.Errorf("%s: invalid clone direction %q", cellName(i, j), m[3])
+	}
+
+	table[i][j] = Cell{}
+	clone := Cell{Content: ":" + m[3], Type: Clone}
+	for ti := fromRow; ti <= toRow; ti++ {
+		for tj := fromCol; tj <= toCol; tj++ {
+			if ti >= len(table) || tj >= len(table[ti]) {
+				return fmt.Errorf("%s: fill range %s:%s goes beyond the table", cellName(i, j), m[1], m[2])
+			}
+			if table[ti][tj].Type != Empty {
+				return fmt.Errorf("%s: fill would overwrite %s", cellName(i, j), cellName(ti, tj))
+			}
+			table[ti][tj] = clone
+		}
+	}
+	return nil
+}
+
+func resolveClones(table Table) error {
+	if err := expandDirectives(table); err != nil {
+		return err
+	}
+
+	var r cloneResolver
+	for i, row := range table {
+		for j := range row {
+			if err := r.resolve(table, i, j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseClone returns the offset of the cell cloned by the clone at row i and
+// column j, and whether the clone continues a series.
+func parseClone(content string, i, j int) (offset [2]int, series bool, err error) {
+	if strings.HasPrefix(content, ":@") {
+		row, col, err := parseCellName(content[2:])
+		if err != nil {
+			return offset, false, err
+		}
+		if row == i && col == j {
+			return offset, false, fmt.Errorf("a cell cannot clone itself")
+		}
+		return [2]int{row - i, col - j}, false, nil
+	}
+
+	if len(content) < 2 {
+		return offset, false, fmt.Errorf("missing clone direction")
+	}
+	dir, ok := charToDir[content[1]]
+	if !ok {
+		return offset, false, fmt.Errorf("invalid clone direction %q", content[1:])
+	}
+	switch content[2:] {
+	case "":
+	case "+":
+		series = true
+	default:
+		return offset, false, fmt.Errorf("invalid clone %q", content)
+	}
+	return dirOffsets[dir], series, nil
+}
+
+// checkCloneBounds makes sure that the cell at row ti and column tj, cloned
+// by a clone with the given content, lies inside the table.
+func checkCloneBounds(table Table, content string, ti, tj int) error {
+	switch {
+	case strings.HasPrefix(content, ":@") && (ti >= len(table) || tj >= len(table[ti])):
+		return fmt.Errorf("cannot clone from %s — it lies outside the table", content[2:])
+	case ti < 0:
+		return fmt.Errorf("cannot clone from above — no row above")
+	case ti >= len(table):
+		return fmt.Errorf("cannot clone from below — no row below")
+	case tj < 0:
+		return fmt.Errorf("cannot clone from the left — no column on the left")
+	case tj >= len(table[ti]):
+		return fmt.Errorf("cannot clone from the right — no column on the right")
+	}
+	return nil
+}
+
+// cloneResolver resolves clones depth first, so that a clone of a clone
+// copies the resolved cell, keeping track of the chain being resolved to
+// detect cycles.
+type cloneResolver struct {
+	chain    []string
+	visiting map[string]bool
+
+	// The cells whose formula was shifted from the one they clone, which is
+	// then written canonically
+	shifted map[[2]int]bool
+}
+
+func (r *cloneResolver) resolve(table Table, i, j int) error {
+	cell := table[i][j]
+	if cell.Type != Clone {
+		return nil
+	}
+
+	name := cellName(i, j)
+	if r.visiting[name] {
+		return fmt.Errorf("%s: clone cycle %s -> %s", name, strings.Join(r.chain, " -> "), name)
+	}
+	if r.visiting == nil {
+		r.visiting = make(map[string]bool)
+		r.shifted = make(map[[2]int]bool)
+	}
+	r.visiting[name] = true
+	r.chain = append(r.chain, name)
+	defer func() {
+		delete(r.visiting, name)
+		r.chain = r.chain[:len(r.chain)-1]
+	}()
+
+	offset, series, err := parseClone(cell.Content, i, j)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	ti, tj := i+offset[0], j+offset[1]
+	if err := checkCloneBounds(table, cell.Content, ti, tj); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := r.resolve(table, ti, tj); err != nil {
+		return err
+	}
+	targetCell := table[ti][tj]
+
+	// Clones keep the format of their target, unless they have their own
+	if cell.Format == "" {
+		cell.Format = targetCell.Format
+	} else if targetCell.Type == Number && !series {
+		if value, err := parseNumber(targetCell.Content); err == nil {
+			targetCell.Content = formatNumberWith(value, cell.Format)
+		}
+	}
+	targetCell.Format = cell.Format
+
+	if targetCell.Type == Expression {
+		shift := shiftReferences
+		if r.shifted[[2]int{ti, tj}] {
+			shift = shiftTokens
+		}
+		content, err := shift(targetCell.Content, -offset[0], -offset[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		targetCell.Content = content
+		r.shifted[[2]int{i, j}] = true
+	} else if series {
+		// The cell beyond the target, in the same direction, sets the step of the series
+		var prev *Cell
+		pi, pj := ti+offset[0], tj+offset[1]
+		if pi >= 0 && pi < len(table) && pj >= 0 && pj < len(table[pi]) {
+			if err := r.resolve(table, pi, pj); err != nil {
+				return err
+			}
+			prev = &table[pi][pj]
+		}
+
+		next, err := nextInSeries(targetCell, prev)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		targetCell = next
+	}
+	table[i][j] = targetCell.withMeta(cell)
+	return nil
+}
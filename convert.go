@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A tableFormat reads and writes tables in some format, for `minicel
+// convert`. Formats that can't be read have no parse, and the ones that
+// can't be written no write. Typed formats store
+// the values of the cells rather than their text, so -out-locale doesn't
+// apply to them.
+type tableFormat struct {
+	parse func(c string) (Table, error)
+	write func(w io.Writer, table Table) error
+	typed bool
+}
+
+var tableFormats = map[string]tableFormat{
+	"mcl": {parse: loadSheet, write: func(w io.Writer, table Table) error {
+		dumpTable(w, table)
+		return nil
+	}},
+	"csv":  csvFormat(false),
+	"tsv":  csvFormat(true),
+	"json": {parse: parseJSON, write: renderJSON},
+	"md":   {parse: parseMarkdown, write: renderMarkdown},
+	"html": {parse: parseHTML, write: func(w io.Writer, table Table) error {
+		renderHTML(w, table)
+		return nil
+	}},
+	"parquet": {write: renderParquet, typed: true},
+	"yaml":    {parse: parseYAML, write: renderYAML},
+	"xlsx":    {parse: parseXLSX},
+}
+
+// csvFormat reads and writes CSV files in the dialect given by the flags,
+// with tabs as delimiter for TSV files.
+func csvFormat(tabs bool) tableFormat {
+	dialect := func() (csvDialect, error) {
+		d, err := flagDialect()
+		if tabs {
+			d.delimiter = '\t'
+		}
+		return d, err
+	}
+	return tableFormat{
+		parse: func(c string) (Table, error) {
+			d, err := dialect()
+			if err != nil {
+				return nil, err
+			}
+			return d.load(c)
+		},
+		write: func(w io.Writer, table Table) error {
+			d, err := dialect()
+			if err != nil {
+				return err
+			}
+			return d.writeCSV(w, table)
+		},
+	}
+}
+
+// formatNames lists the formats that can be read, or written.
+func formatNames(readable bool) string {
+	var names []string
+	for name, format := range tableFormats {
+		if (readable && format.parse != nil) || (!readable && format.write != nil) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseJSON parses a JSON array of rows of cells, as written by renderJSON.
+// Only the content of each cell counts, along with its note, link and
+// format, its type is inferred again.
+func parseJSON(c string) (Table, error) {
+	var cells Table
+	if err := json.Unmarshal([]byte(c), &cells); err != nil {
+		return nil, err
+	}
+	table := make(Table, len(cells))
+	for i, row := range cells {
+		table[i] = make([]Cell, len(row))
+		for j, cell := range row {
+			table[i][j] = newCell(strings.TrimSpace(cell.Content), cell.Format).withMeta(cell)
+		}
+	}
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+var markdownRuleRegexp = regexp.MustCompile(`^:?-+:?$`)
+
+// parseMarkdown parses a Markdown table, skipping the rule under the header.
+func parseMarkdown(c string) (Table, error) {
+	var table Table
+	for _, line := range strings.Split(normalizeNewlines(c), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+
+		var row []Cell
+		rule := true
+		for _, field := range splitMarkdownRow(line) {
+			field = strings.TrimSpace(field)
+			rule = rule && markdownRuleRegexp.MatchString(field)
+			row = append(row, parseCell(field))
+		}
+		if rule && len(table) == 1 {
+			continue
+		}
+		table = append(table, row)
+	}
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+// splitMarkdownRow splits a row of a Markdown table on the pipes that
+// aren't escaped, unescaping the others.
+func splitMarkdownRow(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for k := 0; k < len(line); k++ {
+		switch {
+		case line[k] == '\\' && k+1 < len(line) && line[k+1] == '|':
+			field.WriteByte('|')
+			k++
+		case line[k] == '|':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[k])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// renderMarkdown writes the table as a Markdown table, using the first row
+// as header. Shorter rows are padded with empty cells, and merged cells are
+// followed by a pipe for each column they span, like MultiMarkdown does.
+func renderMarkdown(w io.Writer, table Table) error {
+	cols := 0
+	for _, row := range table {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	escape := strings.NewReplacer("|", `\|`, "\n", "<br>")
+	for i, row := range table {
+		var line strings.Builder
+		line.WriteString("|")
+		for j := 0; j < cols; {
+			span := 1
+			if j < len(row) {
+				span = row[j].span()
+				fmt.Fprintf(&line, " %s ", escape.Replace(row[j].Content))
+			} else {
+				line.WriteString("  ")
+			}
+			line.WriteString(strings.Repeat("|", span))
+			j += span
+		}
+		if _, err := fmt.Fprintln(w, line.String()); err != nil {
+			return err
+		}
+		if i == 0 {
+			if _, err := fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", cols)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// convert parses c, evaluates it and writes it to w. Like `minicel
+// render`, the cells that fail are written as #ERROR, and their errors
+// returned after.
+func convert(w io.Writer, c string, in, out tableFormat) error {
+	table, err := in.parse(c)
+	if err != nil {
+		return err
+	}
+	evalErr := evalTable(table)
+	if !out.typed {
+		table = localizeTable(table)
+	}
+	if err := out.write(w, table); err != nil {
+		return err
+	}
+	return evalErr
+}
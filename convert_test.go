@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		from, to, content, want string
+	}{
+		{"csv", "md", "Item,Price\nPens,2\n\"a|b\",=B1*3\n", "| Item | Price |\n| --- | --- |\n| Pens | 2.00 |\n| a\\|b | 6.00 |\n"},
+		{"md", "csv", "| Item | Price |\n|:--|--:|\n| Pens | 2 |\n| a\\|b | =B1*3 |\n", "Item,Price\nPens,2.00\na|b,6.00\n"},
+		{"mcl", "tsv", "A|B\n1|=A1+1", "A\tB\n1.00\t2.00\n"},
+		{"mcl", "md", "Item|Sales|<<\nPens|1|2", "| Item | Sales ||\n| --- | --- | --- |\n| Pens | 1.00 | 2.00 |\n"},
+		{"mcl", "html", "Item|Sales|<<|<<\n1|2|3|4", "<table>\n  <tr><th class=\"text\">Item</th><th class=\"text\" colspan=\"3\">Sales</th></tr>\n  <tr><td class=\"number\">1.00</td><td class=\"number\">2.00</td><td class=\"number\">3.00</td><td class=\"number\">4.00</td></tr>\n</table>\n"},
+		{"json", "mcl", `[[{"content":"A"}],[{"content":"=2*3","type":"Text"}]]`, "A\n6.00\n"},
+		{"yaml", "mcl", "- item: Pens\n  qty: 2\n- item: 'Ink'\n  qty: =B1*3 # tripled\n  paid: true\n", "item|qty |paid\nPens|2.00|\nInk |6.00|TRUE\n"},
+		{"mcl", "yaml", "Item|Qty|Paid|Note\nPens|2|TRUE|a: b\n|=B1*3|FALSE|42", "- Item: Pens\n  Qty: 2.00\n  Paid: true\n  Note: \"a: b\"\n- Item: null\n  Qty: 6.00\n  Paid: false\n  Note: 42.00\n"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := convert(&out, tt.content, tableFormats[tt.from], tableFormats[tt.to]); err != nil {
+			t.Errorf("%s to %s: %v", tt.from, tt.to, err)
+			continue
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s to %s: got %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var csvFlag = flag.Bool("csv", false, "read sheets as CSV files, following -delimiter, -quote, -lazy-quotes and -no-header")
+var delimiterVar = flag.String("delimiter", ",", "delimiter of the fields of CSV files")
+var quoteVar = flag.String("quote", `"`, "character quoting the fields of CSV files")
+var lazyQuotesFlag = flag.Bool("lazy-quotes", false, "allow quotes inside unquoted fields and unescaped quotes inside quoted fields of CSV files")
+var noHeaderFlag = flag.Bool("no-header", false, "CSV files have no header row: one naming the columns A, B, C and so on is added when reading them, and the first row is left out when writing them")
+
+// csvDialect is how a CSV file is written. Unlike encoding/csv, the quote
+// can be any character, since real-world files are rarely RFC 4180.
+type csvDialect struct {
+	delimiter, quote rune
+	lazyQuotes       bool
+	header           bool
+}
+
+// flagDialect returns the dialect given by the flags.
+func flagDialect() (csvDialect, error) {
+	d := csvDialect{lazyQuotes: *lazyQuotesFlag, header: !*noHeaderFlag}
+	var err error
+	if d.delimiter, err = singleRune("delimiter", *delimiterVar); err != nil {
+		return csvDialect{}, err
+	}
+	if d.quote, err = singleRune("quote", *quoteVar); err != nil {
+		return csvDialect{}, err
+	}
+	if d.delimiter == d.quote || d.delimiter == '\n' || d.quote == '\n' {
+		return csvDialect{}, fmt.Errorf("the delimiter and the quote must be different, and can't be new lines")
+	}
+	return d, nil
+}
+
+func singleRune(name, s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("the %s must be a single character, got %q", name, s)
+	}
+	return r, nil
+}
+
+// parseCSV parses the content of a CSV file into a table, inferring the
+// type of each field like parseTable does for cells.
+func (d csvDialect) parseCSV(content string) (Table, error) {
+	records, err := d.readAll(normalizeNewlines(strings.TrimPrefix(content, "\ufeff")))
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	if !d.header {
+		width := 0
+		for _, record := range records {
+			if len(record) > width {
+				width = len(record)
+			}
+		}
+		header := make([]Cell, width)
+		for j := range header {
+			header[j] = Cell{Content: cellName(0, j)[:1], Type: Text}
+		}
+		table = append(table, header)
+	}
+	for _, record := range records {
+		row := make([]Cell, len(record))
+		for j, field := range record {
+			row[j] = parseCell(field)
+		}
+		table = append(table, row)
+	}
+	return table, nil
+}
+
+// load parses the content of a CSV file and gets it ready to be evaluated,
+// like loadSheet does for sheets.
+func (d csvDialect) load(content string) (Table, error) {
+	table, err := d.parseCSV(content)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+// readAll splits content into records and fields.
+func (d csvDialect) readAll(content string) ([][]string, error) {
+	var records [][]string
+	var record []string
+	var field strings.Builder
+	line := 1
+
+	runes := []rune(content)
+	for k := 0; k < len(runes); k++ {
+		// A field starting with the quote goes on until the closing one
+		if field.Len() == 0 && runes[k] == d.quote {
+			start := line
+			closed := false
+			for k++; k < len(runes); k++ {
+				r := runes[k]
+				if r == '\n' {
+					line++
+				}
+				if r != d.quote {
+					field.WriteRune(r)
+					continue
+				}
+				if k+1 < len(runes) && runes[k+1] == d.quote {
+					field.WriteRune(r)
+					k++
+					continue
+				}
+				if k+1 == len(runes) || runes[k+1] == d.delimiter || runes[k+1] == '\n' {
+					closed = true
+					break
+				}
+				if !d.lazyQuotes {
+					return nil, fmt.Errorf("line %d: unexpected %c inside a quoted field, try -lazy-quotes", line, d.quote)
+				}
+				field.WriteRune(r)
+			}
+			if !closed && !d.lazyQuotes {
+				return nil, fmt.Errorf("line %d: quoted field is never closed", start)
+			}
+			continue
+		}
+
+		switch r := runes[k]; r {
+		case d.delimiter:
+			record = append(record, field.String())
+			field.Reset()
+		case '\n':
+			records = append(records, append(record, field.String()))
+			record = nil
+			field.Reset()
+			line++
+		case d.quote:
+			if !d.lazyQuotes {
+				return nil, fmt.Errorf("line %d: unexpected %c inside an unquoted field, try -lazy-quotes", line, d.quote)
+			}
+			field.WriteRune(r)
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if record != nil || field.Len() > 0 || len(runes) > 0 && runes[len(runes)-1] != '\n' {
+		records = append(records, append(record, field.String()))
+	}
+	return records, nil
+}
+
+// writeCSV writes the content of each cell of the table as a CSV file,
+// leaving out the header when the dialect has none.
+func (d csvDialect) writeCSV(w io.Writer, table Table) error {
+	if !d.header && len(table) > 0 {
+		table = table[1:]
+	}
+	for _, row := range table {
+		fields := make([]string, len(row))
+		for j, cell := range row {
+			fields[j] = d.field(cell.Content)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, string(d.delimiter))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field quotes s when needed, doubling the quotes inside it.
+func (d csvDialect) field(s string) string {
+	if !strings.ContainsAny(s, string([]rune{d.delimiter, d.quote, '\n', '\r'})) && !strings.HasPrefix(s, " ") {
+		return s
+	}
+	quote := string(d.quote)
+	return quote + strings.ReplaceAll(s, quote, quote+quote) + quote
+}
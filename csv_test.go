@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	comma := csvDialect{delimiter: ',', quote: '"', header: true}
+	tests := []struct {
+		dialect csvDialect
+		content string
+		want    [][]string
+	}{
+		{comma, "a,b\n1,2\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{comma, "a,b\n1,2", [][]string{{"a", "b"}, {"1", "2"}}},
+		{comma, `"x, y","say ""hi""",""` + "\n", [][]string{{"x, y", `say "hi"`, ""}}},
+		{comma, "\"two\nlines\",3", [][]string{{"two\nlines", "3"}}},
+		{comma, "a,,c", [][]string{{"a", "", "c"}}},
+		{csvDialect{delimiter: ';', quote: '\''}, "'a;b';'it''s'", [][]string{{"a;b", "it's"}}},
+		{csvDialect{delimiter: '\t', quote: '"', lazyQuotes: true}, "5\" disk\t\"a \"b\" c\"", [][]string{{`5" disk`, `a "b" c`}}},
+	}
+	for _, tt := range tests {
+		got, err := tt.dialect.readAll(tt.content)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readAll(%q) = %q, %v, want %q", tt.content, got, err, tt.want)
+		}
+	}
+
+	for _, content := range []string{`5" disk,2`, `"a "b" c",2`, `"never closed`} {
+		if _, err := comma.readAll(content); err == nil {
+			t.Errorf("readAll(%q) should fail without lazy quotes", content)
+		}
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	dialect := csvDialect{delimiter: ';', quote: '"'}
+	table, err := dialect.parseCSV("\"Apples; red\";1.5;=B1*2\n\"Say \"\"hi\"\"\";2;=B2*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a header, one is added when reading and left out when writing
+	if got := tableContents(table[:1]); got != "A|B|C" {
+		t.Errorf("got header %q", got)
+	}
+	var out strings.Builder
+	if err := dialect.writeCSV(&out, table); err != nil {
+		t.Fatal(err)
+	}
+	want := "\"Apples; red\";1.50;3.00\n\"Say \"\"hi\"\"\";2.00;4.00\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+//go:build !js
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemon keeps sheets loaded between requests, so that tools calling
+// minicel repeatedly pay for parsing and evaluating a sheet only once.
+// Sheets are loaded from their files on first use, and again whenever their
+// files change, dropping the cells set in the meantime but keeping the
+// values of the formulas whose cells didn't change.
+type daemon struct {
+	mu     sync.Mutex
+	sheets map[string]*daemonSheet
+}
+
+type daemonSheet struct {
+	*sheet
+	modTime time.Time
+}
+
+// daemonRequest is a line of JSON sent to the daemon. Op is one of eval,
+// get or set, Sheet the path of a sheet, either absolute or relative to the
+// directory the daemon was started in.
+type daemonRequest struct {
+	Op      string `json:"op"`
+	Sheet   string `json:"sheet"`
+	Cell    string `json:"cell,omitempty"`
+	Content string `json:"content,omitempty"`
+	Formula string `json:"formula,omitempty"`
+}
+
+// daemonResponse is the line of JSON answering a request. Eval responds
+// with the evaluated table, or with the value of the formula when given,
+// while get and set respond with the cell.
+type daemonResponse struct {
+	Table Table         `json:"table,omitempty"`
+	Value string        `json:"value,omitempty"`
+	Cell  *cellResponse `json:"cell,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+func newDaemon() *daemon {
+	return &daemon{sheets: make(map[string]*daemonSheet)}
+}
+
+// load returns the sheet at path, loading it again if its file changed.
+func (d *daemon) load(path string) (*sheet, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cache := newRecalcCache()
+	if s, ok := d.sheets[path]; ok {
+		if s.modTime.Equal(info.ModTime()) {
+			return s.sheet, nil
+		}
+		// The sheet loaded before can still be in use, it gives up its cache
+		s.mu.Lock()
+		cache, s.cache = s.cache, nil
+		s.mu.Unlock()
+	}
+	c, err := readSheet(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &daemonSheet{newCachedSheet(c, cache, true), info.ModTime()}
+	d.sheets[path] = s
+	return s.sheet, nil
+}
+
+// handle answers a single request.
+func (d *daemon) handle(req daemonRequest) daemonResponse {
+	if req.Sheet == "" {
+		return daemonResponse{Error: "missing sheet"}
+	}
+	s, err := d.load(req.Sheet)
+	if err != nil {
+		return daemonResponse{Error: err.Error()}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resp daemonResponse
+	switch req.Op {
+	case "eval":
+		if req.Formula == "" {
+			resp.Table = s.values
+			break
+		}
+		if s.values == nil {
+			break
+		}
+		var out strings.Builder
+		if err := replEval(&out, s.values, strings.TrimPrefix(req.Formula, "=")); err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		resp.Value = strings.TrimSuffix(out.String(), "\n")
+	case "get", "set":
+		row, col, err := parseCellName(req.Cell)
+		if err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		if row >= len(s.source) || col >= len(s.source[row]) {
+			return daemonResponse{Error: fmt.Sprintf("cell %s out of bounds", req.Cell)}
+		}
+		resp.Cell = &cellResponse{Cell: req.Cell}
+		if req.Op == "set" {
+			resp.Cell.Recalculated, err = s.update(req.Cell, req.Content)
+			if _, failed := err.(evalErrors); err != nil && !failed {
+				resp.Cell.Error = err.Error()
+			}
+		}
+		s.describeCell(resp.Cell, row, col)
+	default:
+		return daemonResponse{Error: fmt.Sprintf("unknown op %q, expected eval, get or set", req.Op)}
+	}
+	if s.err != nil {
+		resp.Error = s.err.Error()
+	}
+	return resp
+}
+
+// serveConn answers the requests read from conn, one per line, until it's
+// closed.
+func (d *daemon) serveConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 10<<20)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req daemonRequest
+		resp := daemonResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp = d.handle(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// defaultSocket returns the path of the socket when -socket isn't given.
+// Sockets are reachable by anyone who can reach their directory until they
+// are chmodded, so they're created in $XDG_RUNTIME_DIR, which belongs to the
+// user, or else in a directory under the temporary one that only the user
+// can access.
+func defaultSocket() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "minicel.sock"), nil
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("minicel-%d", os.Getuid()))
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	// The directory might have been created by someone else
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		return "", fmt.Errorf("%s: expected a directory only the user can access", dir)
+	}
+	return filepath.Join(dir, "minicel.sock"), nil
+}
+
+// daemonCommand implements `minicel daemon`, answering requests over a
+// Unix socket until interrupted.
+func daemonCommand(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", "", "path of the Unix socket to listen on, inside a directory only the user can access (minicel.sock in $XDG_RUNTIME_DIR or in a private directory under the temporary one by default)")
+	fs.Parse(args)
+	if *socket == "" {
+		var err error
+		if *socket, err = defaultSocket(); err != nil {
+			return err
+		}
+	}
+
+	// A socket left behind by a daemon that didn't stop cleanly is removed
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: another daemon is listening", *socket)
+	}
+	os.Remove(*socket)
+
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		l.Close()
+	}()
+
+	d := newDaemon()
+	log.Printf("Listening on %s", *socket)
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go d.serveConn(conn)
+	}
+}
@@ -0,0 +1,99 @@
+//go:build !js
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sheet.mcl")
+	if err := ioutil.WriteFile(path, []byte("A|B\n1|=A1*2\n3|=A2*2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	go newDaemon().serveConn(server)
+	defer client.Close()
+	responses := bufio.NewScanner(client)
+	request := func(req daemonRequest) daemonResponse {
+		t.Helper()
+		if err := json.NewEncoder(client).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		var resp daemonResponse
+		if !responses.Scan() {
+			t.Fatal(responses.Err())
+		}
+		if err := json.Unmarshal(responses.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request(daemonRequest{Op: "eval", Sheet: path, Formula: "=SUM(B1:B2)"}); resp.Value != "8" || resp.Error != "" {
+		t.Errorf("eval: got %+v", resp)
+	}
+	resp := request(daemonRequest{Op: "set", Sheet: path, Cell: "A1", Content: "10"})
+	if resp.Cell == nil || resp.Cell.Recalculated != 1 {
+		t.Fatalf("set: got %+v", resp)
+	}
+	if resp := request(daemonRequest{Op: "get", Sheet: path, Cell: "B1"}); resp.Cell == nil || resp.Cell.Source != "=A1*2" || resp.Cell.Content != "20.00" {
+		t.Errorf("get: got %+v", resp)
+	}
+	if resp := request(daemonRequest{Op: "get", Sheet: path, Cell: "C9"}); resp.Error != "cell C9 out of bounds" {
+		t.Errorf("get out of bounds: got %+v", resp)
+	}
+
+	// Changing the file loads the sheet again
+	if err := ioutil.WriteFile(path, []byte("A\n5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if resp := request(daemonRequest{Op: "eval", Sheet: path}); len(resp.Table) != 2 || resp.Table[1][0].Content != "5.00" {
+		t.Errorf("eval after change: got %+v", resp)
+	}
+}
+
+func TestDefaultSocket(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", tmp)
+
+	// Directories others can access aren't used
+	dir := filepath.Join(tmp, fmt.Sprintf("minicel-%d", os.Getuid()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if socket, err := defaultSocket(); err == nil {
+		t.Errorf("got %s inside a shared directory", socket)
+	}
+
+	os.Remove(dir)
+	socket, err := defaultSocket()
+	if err != nil || socket != filepath.Join(dir, "minicel.sock") {
+		t.Fatalf("got %s, %v", socket, err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("got %v, %v for the directory of the socket", info.Mode(), err)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if socket, err := defaultSocket(); err != nil || socket != "/run/user/1000/minicel.sock" {
+		t.Errorf("got %s, %v", socket, err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterFunc("DATEDIF", datedif)
+	RegisterFunc("EDATE", edate)
+	RegisterFunc("EOMONTH", eomonth)
+	RegisterFunc("WEEKDAY", weekday)
+}
+
+// dateArg parses a date written in one of dateLayouts, returning the layout
+// too so that resulting dates can be written the same way.
+func dateArg(v Value) (time.Time, string, error) {
+	if v.Kind() == DateKind {
+		return v.date, v.text, nil
+	}
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, v.Text()); err == nil {
+			return date, layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("expected a date like %s, got %q", strings.Join(dateLayouts, " or "), v.Text())
+}
+
+// monthsArg returns an argument holding a whole number of months.
+func monthsArg(v Value) (int, error) {
+	n, err := v.Number()
+	if err != nil {
+		return 0, err
+	}
+	if n != math.Trunc(n) {
+		return 0, fmt.Errorf("months must be a whole number, got %g", n)
+	}
+	return int(n), nil
+}
+
+// addMonths moves date by the given months, going back to the last day of
+// the month when the day doesn't exist (e.g. from January 31 to February 28).
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	if last := endOfMonth(first); date.Day() > last.Day() {
+		return last
+	}
+	return first.AddDate(0, 0, date.Day()-1)
+}
+
+func endOfMonth(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+}
+
+func days(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// datedif implements `DATEDIF(start, end, unit)`, the difference between
+// two dates in whole days (D), months (M) or years (Y), or the days (MD) and
+// months (YM) left over by whole months and years, or the days left over by
+// whole years (YD).
+func datedif(args ...Value) (Value, error) {
+	if len(args) != 3 {
+		return Value{}, fmt.Errorf("expected DATEDIF(start, end, unit)")
+	}
+	start, _, err := dateArg(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	end, _, err := dateArg(args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	if end.Before(start) {
+		return Value{}, fmt.Errorf("the end comes before the start")
+	}
+
+	months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+	if end.Day() < start.Day() {
+		months--
+	}
+
+	var diff int
+	switch unit := strings.ToUpper(args[2].Text()); unit {
+	case "D":
+		diff = days(start, end)
+	case "M":
+		diff = months
+	case "Y":
+		diff = months / 12
+	case "YM":
+		diff = months % 12
+	case "MD":
+		diff = days(start.AddDate(0, months, 0), end)
+	case "YD":
+		diff = days(start.AddDate(months/12, 0, 0), end)
+	default:
+		return Value{}, fmt.Errorf("unknown unit %q, expected D, M, Y, MD, YM or YD", unit)
+	}
+	return NumberValue(float64(diff)), nil
+}
+
+// edate implements `EDATE(date, months)`, the same day some months away.
+func edate(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected EDATE(date, months)")
+	}
+	date, layout, err := dateArg(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	months, err := monthsArg(args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	return DateValue(addMonths(date, months), layout), nil
+}
+
+// eomonth implements `EOMONTH(date, months)`, the last day of the month
+// some months away.
+func eomonth(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected EOMONTH(date, months)")
+	}
+	date, layout, err := dateArg(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	months, err := monthsArg(args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	return DateValue(endOfMonth(addMonths(date, months)), layout), nil
+}
+
+// weekday implements `WEEKDAY(date, [type])`, the day of the week counting
+// from Sunday as 1 (type 1, the default), from Monday as 1 (type 2) or from
+// Monday as 0 (type 3).
+func weekday(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("expected WEEKDAY(date, [type])")
+	}
+	date, _, err := dateArg(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	kind := 1.0
+	if len(args) == 2 {
+		if kind, err = args[1].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+
+	day := int(date.Weekday())
+	switch kind {
+	case 1:
+		return NumberValue(float64(day + 1)), nil
+	case 2:
+		return NumberValue(float64((day+6)%7 + 1)), nil
+	case 3:
+		return NumberValue(float64((day + 6) % 7)), nil
+	}
+	return Value{}, fmt.Errorf("unknown type %g, expected 1, 2 or 3", kind)
+}
+
+var clockDurationRegexp = regexp.MustCompile(`^(\d+):([0-5]\d)(?::([0-5]\d))?$`)
+var unitDurationRegexp = regexp.MustCompile(`^(\d+(\.\d+)?[hms])+$`)
+
+// parseDuration parses durations written like `1h30m` or `01:30` (hours
+// and minutes, optionally followed by seconds).
+func parseDuration(s string) (time.Duration, bool) {
+	if m := clockDurationRegexp.FindStringSubmatch(s); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.Atoi("0" + m[3])
+		return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second, true
+	}
+	if unitDurationRegexp.MatchString(s) {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// formatDuration writes d like `1h30m`, rounded to the second and leaving
+// out the units that are zero.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d == 0 {
+		return "0s"
+	}
+
+	var sb strings.Builder
+	if d < 0 {
+		sb.WriteByte('-')
+		d = -d
+	}
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if n := d / unit.size; n > 0 {
+			fmt.Fprintf(&sb, "%d%s", n, unit.name)
+			d -= n * unit.size
+		}
+	}
+	return sb.String()
+}
+
+// dateTimeLayout returns the layout of a date with the time of the day too.
+func dateTimeLayout(layout string) string {
+	if strings.Contains(layout, "15:04") {
+		return layout
+	}
+	return layout + " 15:04"
+}
+
+// timeArith applies an arithmetic operator to dates and durations: dates
+// can be moved by durations, and subtracted to get the duration between
+// them, while durations can be added together, subtracted, scaled by numbers
+// and divided by each other.
+func timeArith(op token.Token, lhs, rhs Value) (Value, error) {
+	invalid := fmt.Errorf("can't apply %s to %s and %s", op, kindName(lhs), kindName(rhs))
+
+	switch {
+	case lhs.Kind() == DateKind && rhs.Kind() == DurationKind && (op == token.ADD || op == token.SUB):
+		d := time.Duration(rhs.num * float64(time.Second))
+		if op == token.SUB {
+			d = -d
+		}
+		date := lhs.date.Add(d)
+		layout := lhs.text
+		if date.Hour() != 0 || date.Minute() != 0 {
+			layout = dateTimeLayout(layout)
+		}
+		return DateValue(date, layout), nil
+	case lhs.Kind() == DurationKind && rhs.Kind() == DateKind && op == token.ADD:
+		return timeArith(op, rhs, lhs)
+	case lhs.Kind() == DateKind && rhs.Kind() == DateKind && op == token.SUB:
+		return DurationValue(lhs.date.Sub(rhs.date)), nil
+	case lhs.Kind() == DurationKind && rhs.Kind() == DurationKind:
+		switch op {
+		case token.ADD:
+			return Value{kind: DurationKind, num: lhs.num + rhs.num}, nil
+		case token.SUB:
+			return Value{kind: DurationKind, num: lhs.num - rhs.num}, nil
+		case token.QUO:
+			if rhs.num == 0 {
+				return ErrorValue(errDivZero), nil
+			}
+			return NumberValue(lhs.num / rhs.num), nil
+		}
+	case lhs.Kind() == DurationKind && (op == token.MUL || op == token.QUO):
+		n, err := rhs.Number()
+		if err != nil {
+			return Value{}, invalid
+		}
+		if op == token.MUL {
+			return Value{kind: DurationKind, num: lhs.num * n}, nil
+		}
+		if n == 0 {
+			return ErrorValue(errDivZero), nil
+		}
+		return Value{kind: DurationKind, num: lhs.num / n}, nil
+	case rhs.Kind() == DurationKind && op == token.MUL:
+		return timeArith(op, rhs, lhs)
+	}
+	return Value{}, invalid
+}
+
+func kindName(v Value) string {
+	switch v.Kind() {
+	case DateKind:
+		return "a date"
+	case DurationKind:
+		return "a duration"
+	case TextKind:
+		return "a text"
+	}
+	return "a number"
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// dbDrivers maps URL schemes to the name of the database/sql driver handling
+// them. Any other scheme is used as the driver name itself.
+var dbDrivers = map[string]string{
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+}
+
+const dbQueryTimeout = 30 * time.Second
+
+// preloadTable merges cells, substitutes placeholders, checks the cells
+// against -schema, runs shell commands and every `=DBQUERY("url", "query")`
+// cell, filling the table with the results starting from the cell itself: a
+// row with the name of the columns followed by a row for each result. The
+// table grows as needed, but results can't overwrite other non-empty cells.
+// Only local sheets, read from the files of whoever runs minicel, can read
+// environment variables, run commands, query databases and call FETCH.
+func preloadTable(table Table, local bool) (Table, error) {
+	if err := mergeCells(table); err != nil {
+		return nil, err
+	}
+	if err := substitutePlaceholders(table, local); err != nil {
+		return nil, err
+	}
+	if s, err := flagSchema(); err != nil {
+		return nil, err
+	} else if err := s.check(table); err != nil {
+		return nil, err
+	}
+	if err := runCommands(table, local); err != nil {
+		return nil, err
+	}
+	if !local {
+		for i, row := range table {
+			for j, cell := range row {
+				if cell.Type == Expression && callsFetch(cell.Content) {
+					return nil, fmt.Errorf("%s: requests can't be made by this sheet", cellName(i, j))
+				}
+			}
+		}
+	}
+
+	for i := 0; i < len(table); i++ {
+		for j := 0; j < len(table[i]); j++ {
+			cell := table[i][j]
+			if cell.Type != Expression || !strings.HasPrefix(strings.ToUpper(cell.Content), "=DBQUERY(") {
+				continue
+			}
+
+			if !local {
+				return nil, fmt.Errorf("%s: databases can't be queried by this sheet", cellName(i, j))
+			}
+			rows, err := runDBQuery(cell.Content)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+
+			table[i][j] = Cell{}
+			for di, row := range rows {
+				for dj, value := range row {
+					ti, tj := i+di, j+dj
+					for ti >= len(table) {
+						table = append(table, nil)
+					}
+					for tj >= len(table[ti]) {
+						table[ti] = append(table[ti], Cell{})
+					}
+					if table[ti][tj].Type != Empty {
+						return nil, fmt.Errorf("%s: query results would overwrite %s", cellName(i, j), cellName(ti, tj))
+					}
+
+					// Never let data coming from the database be evaluated
+					c := parseCell(value)
+					if c.Type == Expression || c.Type == Clone || c.Type == Command {
+						c.Type = Text
+					}
+					table[ti][tj] = c
+				}
+			}
+			if err := checkTableLimits(table); err != nil {
+				return nil, fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+		}
+	}
+	return table, nil
+}
+
+// runDBQuery runs the query of a DBQUERY cell, returning the name of the
+// columns followed by the results.
+func runDBQuery(content string) ([][]string, error) {
+	if *deterministicFlag {
+		return nil, fmt.Errorf("DBQUERY is disabled by -deterministic")
+	}
+	if !*allowDBFlag {
+		return nil, fmt.Errorf("DBQUERY requires -allow-db")
+	}
+
+	expr, err := parseFormula(content[1:])
+	if err != nil {
+		return nil, err
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return nil, fmt.Errorf("expected DBQUERY(\"url\", \"query\")")
+	}
+	var args [2]string
+	for k, arg := range call.Args {
+		lit, ok := arg.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return nil, fmt.Errorf("expected DBQUERY(\"url\", \"query\")")
+		}
+		if args[k], err = strconv.Unquote(lit.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return nil, err
+	}
+	driver, ok := dbDrivers[u.Scheme]
+	if !ok {
+		driver = u.Scheme
+	}
+
+	db, err := sql.Open(driver, args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, args[1])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	results := [][]string{columns}
+
+	values := make([]interface{}, len(columns))
+	for k := range values {
+		values[k] = new(interface{})
+	}
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(columns))
+		for k, v := range values {
+			switch v := (*v.(*interface{})).(type) {
+			case nil:
+			case []byte:
+				row[k] = string(v)
+			case time.Time:
+				row[k] = v.Format("2006-01-02")
+			default:
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+)
+
+// fakeDriver answers every query with the same two columns and rows.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{rows: [][]driver.Value{{"Rome", int64(10)}, {"=A1", 2.5}}}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"city", "sales"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+func TestPreloadTable(t *testing.T) {
+	*allowDBFlag = true
+	defer func() { *allowDBFlag = false }()
+
+	table, err := preloadTable(parseTable(`Total|=B2+B3`+"\n"+`=DBQUERY("fakedb://test", "select city, sales from sales")|`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"Total", "12.50"},
+		{"city", "sales"},
+		{"Rome", "10.00"},
+		{"=A1", "2.50"},
+	}
+	if len(table) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(table), len(want))
+	}
+	for i, row := range want {
+		for j, content := range row {
+			if table[i][j].Content != content {
+				t.Errorf("%s: got %q, want %q", cellName(i, j), table[i][j].Content, content)
+			}
+		}
+	}
+	if table[3][0].Type != Text {
+		t.Errorf("query results must never be evaluated, got %s", table[3][0].Type)
+	}
+}
+
+func TestPreloadTableRequiresAllowDB(t *testing.T) {
+	if _, err := preloadTable(parseTable(`=DBQUERY("fakedb://test", "select 1")`), true); err == nil {
+		t.Error("DBQUERY should fail without -allow-db")
+	}
+}
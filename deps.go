@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dependentsMap maps every cell to the formulas referencing it directly.
+func dependentsMap(table Table) map[[2]int][][2]int {
+	dependents := make(map[[2]int][][2]int)
+	for i, row := range table {
+		for j, cell := range row {
+			if cell.Type != Expression {
+				continue
+			}
+			refs, err := formulaRefs(table, cell.Content)
+			if err != nil {
+				continue
+			}
+			for _, ref := range refs {
+				pos := [2]int{ref.Row, ref.Col}
+				dependents[pos] = append(dependents[pos], [2]int{i, j})
+			}
+		}
+	}
+	return dependents
+}
+
+// dependentCells returns the given cells followed by every cell depending
+// on them, directly or through other cells, each one only once.
+func dependentCells(dependents map[[2]int][][2]int, queue [][2]int) [][2]int {
+	seen := make(map[[2]int]bool)
+	var cells [][2]int
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		if seen[pos] {
+			continue
+		}
+		seen[pos] = true
+		cells = append(cells, pos)
+		queue = append(queue, dependents[pos]...)
+	}
+	return cells
+}
+
+// depsCommand implements `minicel deps -of A1 sheet`, listing every cell
+// whose value depends on A1, row by row.
+func depsCommand(args []string) error {
+	fs := flag.NewFlagSet("deps", flag.ExitOnError)
+	of := fs.String("of", "", "the cell whose dependents are listed")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *of == "" {
+		return fmt.Errorf("usage: minicel deps -of cell sheet")
+	}
+	row, col, err := parseCellName(*of)
+	if err != nil {
+		return err
+	}
+
+	c, err := readSheet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+
+	for _, pos := range dependents(table, row, col) {
+		fmt.Println(cellName(pos[0], pos[1]))
+	}
+	return nil
+}
+
+// dependents returns every cell whose value depends on the one at row and
+// col, sorted row by row.
+func dependents(table Table, row, col int) [][2]int {
+	cells := dependentCells(dependentsMap(table), [][2]int{{row, col}})[1:]
+	sort.Slice(cells, func(a, b int) bool {
+		if cells[a][0] != cells[b][0] {
+			return cells[a][0] < cells[b][0]
+		}
+		return cells[a][1] < cells[b][1]
+	})
+	return cells
+}
+
+// precedents returns the given cells along with every cell they depend on,
+// directly or through other cells, sorted row by row. Since formulas can
+// spill ranges into empty cells, the ones below and to the right of them,
+// empty cells depend on every formula that could spill into them.
+func precedents(table Table, queue [][2]int) [][2]int {
+	var spilling [][2]int
+	for i, row := range table {
+		for j, cell := range row {
+			// Only ranges and functions can give a range to spill
+			if cell.Type == Expression && strings.ContainsAny(cell.Content, ":(") {
+				spilling = append(spilling, [2]int{i, j})
+			}
+		}
+	}
+
+	seen := make(map[[2]int]bool)
+	var cells [][2]int
+	for len(queue) > 0 {
+		pos := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		i, j := pos[0], pos[1]
+		if seen[pos] || i < 0 || j < 0 || i >= len(table) {
+			continue
+		}
+		seen[pos] = true
+		cells = append(cells, pos)
+
+		var cell Cell
+		if j < len(table[i]) {
+			cell = table[i][j]
+		}
+		switch {
+		case cell.Type == Expression:
+			refs, err := formulaRefs(table, cell.Content)
+			if err != nil {
+				continue
+			}
+			for _, ref := range refs {
+				queue = append(queue, [2]int{ref.Row, ref.Col})
+			}
+		case cell.Type == Empty && cell.Content == "":
+			for _, from := range spilling {
+				if from[0] <= i && from[1] <= j {
+					queue = append(queue, from)
+				}
+			}
+		}
+	}
+	sort.Slice(cells, func(a, b int) bool {
+		if cells[a][0] != cells[b][0] {
+			return cells[a][0] < cells[b][0]
+		}
+		return cells[a][1] < cells[b][1]
+	})
+	return cells
+}
+
+// evalCells evaluates the given cells of the table, in order, like evalTable
+// does with all of them, leaving the others as they are. With -iterative
+// every cell is evaluated, as formulas can depend on later ones.
+func evalCells(table Table, cells [][2]int) error {
+	if *iterativeFlag {
+		return evalTable(table)
+	}
+	var errs evalErrors
+	for _, pos := range cells {
+		if pos[1] >= len(table[pos[0]]) {
+			continue
+		}
+		if err := evalAt(table, pos[0], pos[1], nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
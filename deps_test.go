@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependents(t *testing.T) {
+	table := parseTable("1|=A0*2|=B0+1\n=SUM(A0:C0)|=A1|x\n=C0|=B1+C1|=$A$0")
+	var got []string
+	for _, pos := range dependents(table, 0, 0) {
+		got = append(got, cellName(pos[0], pos[1]))
+	}
+	want := []string{"B0", "C0", "A1", "B1", "A2", "B2", "C2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := dependents(table, 1, 2); len(got) != 1 || got[0] != [2]int{2, 1} {
+		t.Errorf("got %v for C1", got)
+	}
+}
+
+func TestPrecedents(t *testing.T) {
+	table := parseTable("1|=A0*2|=B0+1|=NOPE()\n=A0:B0||=A2\n5|=B1+C1|=$A$0")
+	names := func(cells [][2]int) []string {
+		var names []string
+		for _, pos := range cells {
+			names = append(names, cellName(pos[0], pos[1]))
+		}
+		return names
+	}
+
+	// B1 is empty, filled by the range spilled by A1
+	got := names(precedents(table, [][2]int{{2, 1}}))
+	want := []string{"A0", "B0", "A1", "B1", "C1", "A2", "B2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := evalCells(table, precedents(table, [][2]int{{2, 1}})); err != nil {
+		t.Fatal(err)
+	}
+	if got := table[2][1].Content; got != "7.00" {
+		t.Errorf("B2 = %s, want 7.00", got)
+	}
+	// D0 would fail, but B2 doesn't depend on it
+	if table[0][2].Type != Expression || table[0][3].Type != Expression || table[2][2].Type != Expression {
+		t.Errorf("cells B2 doesn't depend on were evaluated: %v", table)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// structEdit inserts (n > 0) or deletes (n < 0) rows or columns starting
+// from index at, moving the references of formulas along with the cells.
+type structEdit struct {
+	rows bool
+	at   int
+	n    int
+}
+
+// insertRows inserts n empty rows before the row at.
+func insertRows(table Table, at, n int) (Table, error) {
+	return structEdit{rows: true, at: at, n: n}.apply(table)
+}
+
+// deleteRows deletes n rows starting from the row at.
+func deleteRows(table Table, at, n int) (Table, error) {
+	return structEdit{rows: true, at: at, n: -n}.apply(table)
+}
+
+// insertCols inserts n empty columns before the column at.
+func insertCols(table Table, at, n int) (Table, error) {
+	return structEdit{at: at, n: n}.apply(table)
+}
+
+// deleteCols deletes n columns starting from the column at.
+func deleteCols(table Table, at, n int) (Table, error) {
+	return structEdit{at: at, n: -n}.apply(table)
+}
+
+// apply returns a copy of the source table with the edit applied. The
+// references to deleted cells become #REF!, while ranges losing only some
+// of their cells shrink.
+func (e structEdit) apply(table Table) (Table, error) {
+	size := len(table)
+	if !e.rows {
+		size = tableWidth(table)
+	}
+	switch {
+	case e.n == 0:
+		return table.copy(), nil
+	case e.at < 0 || e.at > size || e.n < 0 && e.at == size:
+		return nil, refError(table, e.name())
+	case !e.rows && e.n > 0 && size+e.n > 26:
+		return nil, fmt.Errorf("can't insert %d columns, columns only go from A to Z", e.n)
+	}
+
+	edited := table.copy()
+	for i, row := range edited {
+		for j, cell := range row {
+			content, err := e.editContent(cell.Content)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+			edited[i][j].Content = content
+		}
+	}
+
+	if e.rows {
+		if e.n < 0 {
+			end := e.at - e.n
+			if end > len(edited) {
+				end = len(edited)
+			}
+			return append(edited[:e.at], edited[end:]...), nil
+		}
+		width := 0
+		if e.at < len(edited) {
+			width = len(edited[e.at])
+		} else if e.at > 0 {
+			width = len(edited[e.at-1])
+		}
+		inserted := make(Table, e.n)
+		for k := range inserted {
+			inserted[k] = make([]Cell, width)
+		}
+		return append(edited[:e.at], append(inserted, edited[e.at:]...)...), nil
+	}
+
+	for i, row := range edited {
+		if e.at > len(row) {
+			continue
+		}
+		if e.n < 0 {
+			end := e.at - e.n
+			if end > len(row) {
+				end = len(row)
+			}
+			edited[i] = append(row[:e.at], row[end:]...)
+			continue
+		}
+		edited[i] = append(row[:e.at], append(make([]Cell, e.n), row[e.at:]...)...)
+	}
+	return edited, nil
+}
+
+// name returns the row or column where the edit starts, like `row 5`.
+func (e structEdit) name() string {
+	if e.rows {
+		return fmt.Sprintf("row %d", e.at)
+	}
+	return fmt.Sprintf("column %c", 'A'+e.at)
+}
+
+// editContent rewrites the references of the formula inside the source of
+// a cell, leaving the rest of it, like the spaces around and its note, as
+// it is.
+func (e structEdit) editContent(content string) (string, error) {
+	return rewriteRefs(content, e.editRange)
+}
+
+// rewriteRefs replaces every reference and range of the formula inside the
+// source of a cell with the name returned by fn, given its corners (the
+// same one twice for references, and -1 as row or column for whole columns
+// or rows). The rest of the source is left as it is,
+// and so are formulas with no references to rewrite.
+func rewriteRefs(content string, fn func(from, to cellRef, isRange bool) (string, error)) (string, error) {
+	part, _ := splitMeta(content)
+	if !strings.HasPrefix(part, "=") {
+		return content, nil
+	}
+	expr, err := parseFormula(part[1:])
+	if err != nil {
+		// Malformed formulas fail anyway once evaluated
+		return content, nil
+	}
+
+	changed := false
+	var fnErr error
+	walkIdents(expr, func(ident *ast.Ident) bool {
+		var name string
+		if ref, ok := scanRef(ident.Name); ok {
+			name, fnErr = fn(ref, ref, false)
+		} else if from, to, err := parseRange(ident.Name); err == nil {
+			name, fnErr = fn(from, to, true)
+		} else if from, to, ok := parseWholeRange(ident.Name); ok {
+			name, fnErr = fn(from, to, true)
+		} else {
+			return true
+		}
+		if name != ident.Name {
+			ident.Name = name
+			changed = true
+		}
+		return fnErr == nil
+	})
+	if fnErr != nil || !changed {
+		return content, fnErr
+	}
+	return strings.Replace(content, part, "="+formatFormula(expr), 1), nil
+}
+
+// rangeName writes a range back, or a reference when it isn't one. Ranges
+// of whole columns or rows have -1 as row or column.
+func rangeName(from, to cellRef, isRange bool) string {
+	switch {
+	case !isRange:
+		return from.String()
+	case from.Row < 0:
+		return fmt.Sprintf("%c:%c", 'A'+from.Col, 'A'+to.Col)
+	case from.Col < 0:
+		return fmt.Sprintf("%d:%d", from.Row, to.Row)
+	}
+	return from.String() + ":" + to.String()
+}
+
+// editRange moves the corners of a range, or of a single reference, along
+// with the cells, returning its new name or #REF! when all of its cells
+// were deleted. Unlike clones, edits move anchored references too.
+func (e structEdit) editRange(from, to cellRef, isRange bool) (string, error) {
+	coord := func(ref *cellRef) *int {
+		if e.rows {
+			return &ref.Row
+		}
+		return &ref.Col
+	}
+	lo, hi := coord(&from), coord(&to)
+	if *lo > *hi {
+		lo, hi = hi, lo
+	}
+
+	if e.n > 0 {
+		if *lo >= e.at {
+			*lo += e.n
+		}
+		if *hi >= e.at {
+			*hi += e.n
+		}
+	} else {
+		end := e.at - e.n
+		if *lo >= e.at && *hi < end {
+			return errRef.Error(), nil
+		}
+		switch {
+		case *lo >= end:
+			*lo += e.n
+		case *lo >= e.at:
+			*lo = e.at
+		}
+		switch {
+		case *hi >= end:
+			*hi += e.n
+		case *hi >= e.at:
+			*hi = e.at - 1
+		}
+	}
+
+	if from.Col >= 26 || to.Col >= 26 {
+		return "", fmt.Errorf("moved reference %s out of bounds, columns only go from A to Z", from)
+	}
+	return rangeName(from, to, isRange), nil
+}
+
+// blockEdit copies or moves the block of cells between two corners, so that
+// its top left corner ends up at dest.
+type blockEdit struct {
+	lo, hi cellRef
+	dest   cellRef
+	move   bool
+}
+
+// copyRange copies the cells of a range to dest, moving the relative
+// references of their formulas by as many rows and columns, like pasting
+// does in other spreadsheets. References moved out of the table become
+// #REF!.
+func copyRange(table Table, from, to, dest cellRef) (Table, error) {
+	return newBlockEdit(from, to, dest, false).apply(table)
+}
+
+// moveRange moves the cells of a range to dest, leaving their formulas as
+// they are. Instead, the references to the moved cells are moved with them
+// everywhere in the table, while the ones to the cells replaced at dest
+// become #REF!.
+func moveRange(table Table, from, to, dest cellRef) (Table, error) {
+	return newBlockEdit(from, to, dest, true).apply(table)
+}
+
+func newBlockEdit(from, to, dest cellRef, move bool) blockEdit {
+	e := blockEdit{lo: from, hi: to, dest: dest, move: move}
+	if e.lo.Row > e.hi.Row {
+		e.lo.Row, e.hi.Row = e.hi.Row, e.lo.Row
+	}
+	if e.lo.Col > e.hi.Col {
+		e.lo.Col, e.hi.Col = e.hi.Col, e.lo.Col
+	}
+	return e
+}
+
+// contains tells whether ref is inside the block, when its top left corner
+// is at corner.
+func (e blockEdit) contains(corner, ref cellRef) bool {
+	return ref.Row >= corner.Row && ref.Row <= corner.Row+e.hi.Row-e.lo.Row &&
+		ref.Col >= corner.Col && ref.Col <= corner.Col+e.hi.Col-e.lo.Col
+}
+
+// apply returns a copy of the source table with the block copied or moved.
+// The table grows when the block doesn't fit in it.
+func (e blockEdit) apply(table Table) (Table, error) {
+	rows, cols := e.hi.Row-e.lo.Row+1, e.hi.Col-e.lo.Col+1
+	if e.lo.Row >= len(table) || e.lo.Col >= tableWidth(table) {
+		return nil, refError(table, e.lo.String())
+	}
+	if e.dest.Col+cols > 26 {
+		return nil, fmt.Errorf("can't put %d columns at %s, columns only go from A to Z", cols, e.dest)
+	}
+
+	rewrite := e.copyRef
+	if e.move {
+		rewrite = e.moveRef
+	}
+	block := make(Table, rows)
+	for i := range block {
+		block[i] = make([]Cell, cols)
+		for j := range block[i] {
+			row, col := e.lo.Row+i, e.lo.Col+j
+			if row >= len(table) || col >= len(table[row]) {
+				continue
+			}
+			block[i][j] = table[row][col]
+			content, err := rewriteRefs(block[i][j].Content, rewrite)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cellName(row, col), err)
+			}
+			block[i][j].Content = content
+		}
+	}
+
+	edited := table.copy()
+	if e.move {
+		for i, row := range edited {
+			for j := range row {
+				if e.contains(e.lo, cellRef{Row: i, Col: j}) {
+					edited[i][j] = Cell{}
+					continue
+				}
+				content, err := rewriteRefs(row[j].Content, rewrite)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", cellName(i, j), err)
+				}
+				edited[i][j].Content = content
+			}
+		}
+	}
+
+	for i, cells := range block {
+		row := e.dest.Row + i
+		for len(edited) <= row {
+			edited = append(edited, nil)
+		}
+		for len(edited[row]) < e.dest.Col+cols {
+			edited[row] = append(edited[row], Cell{})
+		}
+		copy(edited[row][e.dest.Col:], cells)
+	}
+	return edited, nil
+}
+
+// copyRef moves the relative parts of a copied reference or range by the
+// offset between the block and dest.
+func (e blockEdit) copyRef(from, to cellRef, isRange bool) (string, error) {
+	for _, ref := range []*cellRef{&from, &to} {
+		if !ref.AbsRow && ref.Row >= 0 {
+			if ref.Row += e.dest.Row - e.lo.Row; ref.Row < 0 {
+				return errRef.Error(), nil
+			}
+		}
+		if !ref.AbsCol && ref.Col >= 0 {
+			if ref.Col += e.dest.Col - e.lo.Col; ref.Col < 0 || ref.Col >= 26 {
+				return errRef.Error(), nil
+			}
+		}
+	}
+	return rangeName(from, to, isRange), nil
+}
+
+// moveRef moves the references and ranges to the moved cells, anchored or
+// not, and turns the ones to the cells replaced into #REF!.
+func (e blockEdit) moveRef(from, to cellRef, isRange bool) (string, error) {
+	switch {
+	case e.contains(e.lo, from) && e.contains(e.lo, to):
+		for _, ref := range []*cellRef{&from, &to} {
+			ref.Row += e.dest.Row - e.lo.Row
+			ref.Col += e.dest.Col - e.lo.Col
+		}
+	case e.contains(e.dest, from) && e.contains(e.dest, to):
+		return errRef.Error(), nil
+	}
+	return rangeName(from, to, isRange), nil
+}
+
+// editSource applies edit to the source of a sheet, without parsing its
+// cells, so that the ones left untouched are written back as they were.
+// The ranges of the regions and of the rules above the table are rewritten
+// by moveRegion, unless it's nil.
+func editSource(content string, edit func(table Table) (Table, error), moveRegion func(from, to cellRef, isRange bool) (string, error)) (string, error) {
+	content = normalizeNewlines(content)
+	newline := strings.HasSuffix(content, "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	var sb strings.Builder
+	for strings.HasPrefix(strings.TrimSpace(content), "@") {
+		line, rest, _ := strings.Cut(content, "\n")
+		if m := ruleLineRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			if r, err := parseRule(m[2]); err == nil && moveRegion != nil {
+				target, constraints, _ := strings.Cut(m[2], " ")
+				name, err := moveRegion(r.from, r.to, strings.Contains(target, ":"))
+				if err != nil {
+					return "", fmt.Errorf("rule %s: %w", m[2], err)
+				}
+				// The references inside the conditions of styles move too
+				condition := "=" + r.condition
+				if r.condition != "" {
+					if condition, err = rewriteRefs(condition, moveRegion); err != nil {
+						return "", fmt.Errorf("rule %s: %w", m[2], err)
+					}
+				}
+				if name != target || condition[1:] != r.condition {
+					constraints = strings.Replace(constraints, r.condition, condition[1:], 1)
+					line = fmt.Sprintf("@%s %s %s", m[1], name, constraints)
+				}
+			}
+			sb.WriteString(line + "\n")
+			content = rest
+			continue
+		}
+		m := regionRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			return "", fmt.Errorf("invalid region %q, expected one like @sales = A2:D13", strings.TrimSpace(line))
+		}
+		if from, to, err := parseRange(m[2]); err == nil && moveRegion != nil {
+			name, err := moveRegion(from, to, true)
+			if err != nil {
+				return "", fmt.Errorf("region %s: %w", m[1], err)
+			}
+			if name != m[2] {
+				line = fmt.Sprintf("@%s = %s", m[1], name)
+			}
+		}
+		sb.WriteString(line + "\n")
+		content = rest
+	}
+
+	var table Table
+	for _, line := range strings.Split(content, "\n") {
+		var row []Cell
+		for _, part := range strings.Split(line, "|") {
+			row = append(row, Cell{Content: part})
+		}
+		table = append(table, row)
+	}
+	edited, err := edit(table)
+	if err != nil {
+		return "", err
+	}
+
+	for i, row := range edited {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		for j, cell := range row {
+			if j > 0 {
+				sb.WriteByte('|')
+			}
+			sb.WriteString(cell.Content)
+		}
+	}
+	if newline {
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// writeEdited prints the edited source of a sheet, or saves it to its file
+// when write is set.
+func writeEdited(file, edited string, write bool) error {
+	if !write {
+		fmt.Print(edited)
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(edited), info.Mode())
+}
+
+// editCommand implements `minicel insert` and `minicel delete`, printing
+// the edited sheet, or rewriting it with -w.
+func editCommand(name string, insert bool) func(args []string) error {
+	return func(args []string) error {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		row := fs.Int("row", -1, "the row to "+name+", like 5")
+		col := fs.String("col", "", "the column to "+name+", like C")
+		n := fs.Int("n", 1, "how many rows or columns to "+name)
+		write := fs.Bool("w", false, "write the result to the sheet file instead of printing it")
+		fs.Parse(args)
+		if fs.NArg() != 1 || (*row < 0) == (*col == "") || *n < 1 {
+			return fmt.Errorf("usage: minicel %s [-w] (-row 5 | -col C) [-n 1] sheet", name)
+		}
+
+		e := structEdit{rows: *row >= 0, at: *row, n: *n}
+		if !e.rows {
+			letter := strings.ToUpper(*col)
+			if len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' {
+				return fmt.Errorf("invalid column %q, expected a letter from A to Z", *col)
+			}
+			e.at = int(letter[0] - 'A')
+		}
+		if !insert {
+			e.n = -e.n
+		}
+
+		file := fs.Arg(0)
+		c, err := readSheet(file)
+		if err != nil {
+			return err
+		}
+		edited, err := editSource(c, e.apply, e.editRange)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		return writeEdited(file, edited, *write)
+	}
+}
+
+// blockCommand implements `minicel copy` and `minicel move`, printing the
+// edited sheet, or rewriting it with -w.
+func blockCommand(name string, move bool) func(args []string) error {
+	return func(args []string) error {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		write := fs.Bool("w", false, "write the result to the sheet file instead of printing it")
+		fs.Parse(args)
+		if fs.NArg() != 3 {
+			return fmt.Errorf("usage: minicel %s [-w] A1:C3 E5 sheet", name)
+		}
+
+		from, to, err := parseRange(fs.Arg(0))
+		if err != nil {
+			if from, err = parseRef(fs.Arg(0)); err != nil {
+				return err
+			}
+			to = from
+		}
+		dest, err := parseRef(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		e := newBlockEdit(from, to, dest, move)
+		var moveRegion func(from, to cellRef, isRange bool) (string, error)
+		if move {
+			moveRegion = e.moveRef
+		}
+
+		file := fs.Arg(2)
+		c, err := readSheet(file)
+		if err != nil {
+			return err
+		}
+		edited, err := editSource(c, e.apply, moveRegion)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		return writeEdited(file, edited, *write)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestStructEdit(t *testing.T) {
+	tests := []struct {
+		edit          structEdit
+		content, want string
+	}{
+		{structEdit{rows: true, at: 5, n: 1}, "=B5 + B4", "=B6 + B4"},
+		{structEdit{rows: true, at: 2, n: 2}, "=SUM(A1:A3)", "=SUM(A1:A5)"},
+		{structEdit{rows: true, at: 1, n: 1}, "=$A$3*2", "=$A$4 * 2"},
+		{structEdit{rows: true, at: 9, n: 1}, "=A1  +  A2 {note: kept}", "=A1  +  A2 {note: kept}"},
+		{structEdit{rows: true, at: 2, n: -1}, "=A2 + A3", "=#REF! + A2"},
+		{structEdit{rows: true, at: 2, n: -2}, "=SUM(A1:A5)", "=SUM(A1:A3)"},
+		{structEdit{rows: true, at: 1, n: -2}, "=SUM(A5:A2)", "=SUM(A3:A1)"},
+		{structEdit{rows: true, at: 1, n: -5}, "=SUM(A2:A3)", "=SUM(#REF!)"},
+		{structEdit{at: 1, n: 1}, "=A1 + B1 + C1:D2", "=A1 + C1 + D1:E2"},
+		{structEdit{at: 0, n: -1}, "=A1 + B1", "=#REF! + A1"},
+		{structEdit{at: 1, n: -1}, `=CONCAT("B1", B2) {note: x}`, `=CONCAT("B1", #REF!) {note: x}`},
+		{structEdit{at: 1, n: 1}, "=SUM(B:C) + SUM(2:3)", "=SUM(C:D) + SUM(2:3)"},
+		{structEdit{rows: true, at: 2, n: -1}, "=SUM(B:C) + SUM(2:3)", "=SUM(B:C) + SUM(2:2)"},
+		{structEdit{at: 1, n: -1}, "=SUM(B:B)", "=SUM(#REF!)"},
+	}
+	for _, tt := range tests {
+		got, err := tt.edit.editContent(tt.content)
+		if err != nil || got != tt.want {
+			t.Errorf("%+v.editContent(%q) = %q, %v, want %q", tt.edit, tt.content, got, err, tt.want)
+		}
+	}
+
+	if _, err := (structEdit{at: 1, n: 1}).editContent("=Z1"); err == nil {
+		t.Errorf("moving Z1 to the right should fail")
+	}
+}
+
+func TestEditSource(t *testing.T) {
+	source := "A  | B\n1  | =A1*2\n2  | =SUM(A1:A2)\n"
+	tests := []struct {
+		edit structEdit
+		want string
+	}{
+		{structEdit{rows: true, at: 2, n: 1}, "A  | B\n1  | =A1*2\n|\n2  | =SUM(A1:A3)\n"},
+		{structEdit{rows: true, at: 1, n: -1}, "A  | B\n2  | =SUM(A1:A1)\n"},
+		{structEdit{at: 0, n: 1}, "|A  | B\n|1  | =B1 * 2\n|2  | =SUM(B1:B2)\n"},
+		{structEdit{at: 1, n: -1}, "A  \n1  \n2  \n"},
+	}
+	for _, tt := range tests {
+		got, err := editSource(source, tt.edit.apply, tt.edit.editRange)
+		if err != nil || got != tt.want {
+			t.Errorf("%+v: got %q, %v, want %q", tt.edit, got, err, tt.want)
+		}
+	}
+
+	for _, e := range []structEdit{{rows: true, at: 4, n: 1}, {rows: true, at: 3, n: -1}, {at: 2, n: -1}, {at: 1, n: 25}} {
+		if _, err := editSource(source, e.apply, nil); err == nil {
+			t.Errorf("%+v should fail", e)
+		}
+	}
+}
+
+func TestBlockEdit(t *testing.T) {
+	source := "A|B|C\n1|=A1*2|=$A$1+B1\n2|=SUM(A1:A2)|=B1"
+	ref := func(name string) cellRef {
+		r, err := parseRef(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	tests := []struct {
+		from, to, dest string
+		move           bool
+		want           string
+	}{
+		{"A1", "B2", "B3", false, "A|B|C\n1|=A1*2|=$A$1+B1\n2|=SUM(A1:A2)|=B1\n|1|=B3 * 2\n|2|=SUM(B3:B4)"},
+		{"C2", "B1", "A0", false, "=#REF! * 2|=$A$1 + A0|C\n=SUM(#REF!)|=A0|=$A$1+B1\n2|=SUM(A1:A2)|=B1"},
+		{"A1", "A2", "D1", true, "A|B|C\n|=D1 * 2|=$D$1 + B1|1\n|=SUM(D1:D2)|=B1|2"},
+		{"B1", "B1", "C2", true, "A|B|C\n1||=$A$1 + C2\n2|=SUM(A1:A2)|=A1*2"},
+	}
+	for _, tt := range tests {
+		e := newBlockEdit(ref(tt.from), ref(tt.to), ref(tt.dest), tt.move)
+		got, err := editSource(source, e.apply, nil)
+		if err != nil || got != tt.want {
+			t.Errorf("%s:%s to %s (move %v): got %q, %v, want %q", tt.from, tt.to, tt.dest, tt.move, got, err, tt.want)
+		}
+	}
+
+	for _, e := range []blockEdit{newBlockEdit(ref("A5"), ref("A6"), ref("B1"), false), newBlockEdit(ref("A1"), ref("C1"), ref("Y1"), true)} {
+		if _, err := editSource(source, e.apply, nil); err == nil {
+			t.Errorf("%+v should fail", e)
+		}
+	}
+}
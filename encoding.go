@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var encodingVar = flag.String("encoding", "utf-8", "encoding of the sheets read from files: utf-8, latin-1, utf-16le or utf-16be (a byte order mark takes precedence)")
+
+var mmapFlag = flag.Bool("mmap", false, "map the sheets read from files into memory instead of reading them, so that large ones aren't held twice while loading")
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// readSheet reads the source of a sheet from a file, converting it to UTF-8.
+// Under -mmap the file is mapped instead, and only the decoded source is
+// copied to memory.
+func readSheet(path string) (string, error) {
+	var c []byte
+	var err error
+	if *mmapFlag {
+		var unmap func()
+		if c, unmap, err = mapFile(path); err == nil {
+			defer unmap()
+		}
+	} else {
+		c, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	s, err := decodeSource(c, *encodingVar)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// decodeSource converts c from the given encoding to UTF-8. A byte order
+// mark at the start, like the ones left by spreadsheets exporting CSVs,
+// is dropped and decides the encoding instead. Excel workbooks are binary,
+// and kept as they are.
+func decodeSource(c []byte, encoding string) (string, error) {
+	switch {
+	case bytes.HasPrefix(c, []byte(xlsxZipHeader)):
+		return string(c), nil
+	case bytes.HasPrefix(c, bomUTF8):
+		c, encoding = c[len(bomUTF8):], "utf-8"
+	case bytes.HasPrefix(c, bomUTF16LE):
+		c, encoding = c[len(bomUTF16LE):], "utf-16le"
+	case bytes.HasPrefix(c, bomUTF16BE):
+		c, encoding = c[len(bomUTF16BE):], "utf-16be"
+	}
+
+	switch strings.ToLower(encoding) {
+	case "utf-8", "utf8":
+		if !utf8.Valid(c) {
+			return "", fmt.Errorf("invalid UTF-8, try -encoding latin-1 or utf-16le")
+		}
+		return string(c), nil
+	case "latin-1", "latin1", "iso-8859-1":
+		runes := make([]rune, len(c))
+		for k, b := range c {
+			runes[k] = rune(b)
+		}
+		return string(runes), nil
+	case "utf-16", "utf-16le", "utf-16be":
+		if len(c)%2 != 0 {
+			return "", fmt.Errorf("invalid UTF-16, odd number of bytes")
+		}
+		units := make([]uint16, len(c)/2)
+		for k := range units {
+			lo, hi := c[2*k], c[2*k+1]
+			if strings.ToLower(encoding) == "utf-16be" {
+				lo, hi = hi, lo
+			}
+			units[k] = uint16(lo) | uint16(hi)<<8
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return "", fmt.Errorf("unknown encoding %q, expected utf-8, latin-1, utf-16le or utf-16be", encoding)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeSource(t *testing.T) {
+	tests := []struct {
+		source   []byte
+		encoding string
+		want     string
+	}{
+		{[]byte("Città|1"), "utf-8", "Città|1"},
+		{[]byte("\xef\xbb\xbfA|1"), "utf-8", "A|1"},
+		{[]byte("Citt\xe0|1"), "latin-1", "Città|1"},
+		{[]byte("\xef\xbb\xbfCitt\xc3\xa0"), "latin-1", "Città"},
+		{[]byte("\xff\xfeA\x00|\x00\xe0\x00"), "utf-8", "A|à"},
+		{[]byte("\xfe\xff\x00A\x00|\x00\xe0"), "utf-8", "A|à"},
+		{[]byte("A\x00|\x00"), "utf-16le", "A|"},
+		{[]byte("\x00A\x00|"), "utf-16be", "A|"},
+	}
+	for _, tt := range tests {
+		got, err := decodeSource(tt.source, tt.encoding)
+		if err != nil || got != tt.want {
+			t.Errorf("decodeSource(%q, %s) = %q, %v, want %q", tt.source, tt.encoding, got, err, tt.want)
+		}
+	}
+
+	if _, err := decodeSource([]byte("Citt\xe0"), "utf-8"); err == nil {
+		t.Error("expected invalid UTF-8 to fail")
+	}
+	if _, err := decodeSource([]byte("A"), "ebcdic"); err == nil {
+		t.Error("expected an unknown encoding to fail")
+	}
+}
+
+func TestReadSheetMapped(t *testing.T) {
+	defer func(old bool) { *mmapFlag = old }(*mmapFlag)
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"sheet.mcl": "\xef\xbb\xbfItem|Cost\nPens|=1+2\n",
+		"empty.mcl": "",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		*mmapFlag = false
+		want, err := readSheet(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*mmapFlag = true
+		if got, err := readSheet(path); err != nil || got != want {
+			t.Errorf("readSheet(%s) under -mmap = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	*mmapFlag = true
+	if _, err := readSheet(filepath.Join(dir, "missing.mcl")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
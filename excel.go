@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterAlias("CONCAT", "CONCATENATE")
+	for name, fn := range excelNames {
+		RegisterAlias(name, fn)
+	}
+}
+
+// dotSep stands for the dots inside the names of functions, like STDEV.S,
+// while parsing formulas. Like rangeSep, ꓸ is a letter.
+const dotSep = "ꓸ"
+
+// excelNames maps the names of Excel functions holding a dot to the
+// functions of minicel doing the same.
+var excelNames = map[string]string{
+	"STDEV.S":      "STDEV",
+	"STDEV.P":      "STDEVP",
+	"VAR.S":        "VAR",
+	"VAR.P":        "VARP",
+	"CEILING.MATH": "CEILING",
+	"FLOOR.MATH":   "FLOOR",
+}
+
+// excelSplits are the tokens of Go standing for two operators of Excel.
+var excelSplits = map[token.Token][2]token.Token{
+	token.DEC:   {token.SUB, token.SUB},
+	token.INC:   {token.ADD, token.ADD},
+	token.ARROW: {token.LSS, token.SUB},
+}
+
+// An excelToken is a token of an encoded formula, with its offsets.
+type excelToken struct {
+	tok      token.Token
+	lit      string
+	pos, end int
+}
+
+// translateExcel rewrites an encoded formula written with the syntax of
+// Excel as a Go expression, returning "" when it's already one. Excel
+// compares with = and <>, joins texts with &, raises to powers with ^, has
+// percentages like 50%, escapes quotes inside strings by doubling them,
+// separates arguments with ; in some locales and has functions like STDEV.S.
+// Operators keep the precedence they have in Excel, adding parentheses
+// where Go's would differ.
+func translateExcel(src string) (string, error) {
+	var s scanner.Scanner
+	file := token.NewFileSet().AddFile("", -1, len(src))
+	invalid := false
+	s.Init(file, []byte(src), func(token.Position, string) { invalid = true }, 0)
+
+	var toks []excelToken
+	excel := false
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		t := excelToken{tok: tok, lit: lit, pos: file.Offset(pos)}
+		t.end = t.pos + len(tok.String())
+		if lit != "" {
+			t.end = t.pos + len(lit)
+		}
+		adjacent := len(toks) > 0 && toks[len(toks)-1].end == t.pos
+		switch {
+		case tok == token.XOR, tok == token.AND, tok == token.REM, tok == token.ASSIGN, tok == token.SEMICOLON, tok == token.PERIOD, tok.IsKeyword():
+			excel = true
+		case tok == token.GTR && adjacent && toks[len(toks)-1].tok == token.LSS:
+			// <> is a single operator in Excel
+			excel = true
+		case tok == token.STRING && adjacent && toks[len(toks)-1].tok == token.STRING:
+			// and "" a quote inside strings
+			excel = true
+		case tok == token.DEC || tok == token.INC || tok == token.ARROW:
+			// --A1 negates twice, A1<-1 compares with a negative number
+			excel = true
+			split := excelSplits[tok]
+			toks = append(toks, excelToken{tok: split[0], pos: t.pos, end: t.pos + 1})
+			t = excelToken{tok: split[1], pos: t.pos + 1, end: t.end}
+		}
+		toks = append(toks, t)
+	}
+	// Backslashes are plain characters inside the strings of Excel, so
+	// formulas Go can't scan might be written for it too
+	if !excel && !invalid {
+		return "", nil
+	}
+
+	p := &excelParser{toks: toks}
+	expr, err := p.binary(1)
+	if err == nil && p.k < len(p.toks) {
+		err = fmt.Errorf("unexpected %s", p.toks[p.k].tok)
+	}
+	if err != nil && !excel {
+		// Let go/parser tell what's wrong with it
+		return "", nil
+	}
+	return expr.src, err
+}
+
+// excelParser parses the tokens of a formula written with the syntax of
+// Excel by precedence climbing.
+type excelParser struct {
+	toks []excelToken
+	k    int
+}
+
+func (p *excelParser) peek() token.Token {
+	if p.k < len(p.toks) {
+		return p.toks[p.k].tok
+	}
+	return token.EOF
+}
+
+// operator returns the binary operator at the current token, written as in
+// Go, along with its precedence in Excel, or 0 if there's none.
+func (p *excelParser) operator() (op token.Token, prec, size int) {
+	switch tok := p.peek(); tok {
+	case token.ASSIGN, token.EQL:
+		return token.EQL, 1, 1
+	case token.LSS:
+		if p.k+1 < len(p.toks) && p.toks[p.k+1].tok == token.GTR && p.toks[p.k+1].pos == p.toks[p.k].end {
+			return token.NEQ, 1, 2
+		}
+		return token.LSS, 1, 1
+	case token.NEQ, token.LEQ, token.GTR, token.GEQ:
+		return tok, 1, 1
+	case token.AND:
+		return tok, 2, 1
+	case token.ADD, token.SUB:
+		return tok, 3, 1
+	case token.MUL, token.QUO:
+		return tok, 4, 1
+	case token.XOR:
+		return tok, 5, 1
+	}
+	return token.ILLEGAL, 0, 0
+}
+
+// An excelExpr is a translated expression, along with the precedence its
+// outermost operator has in Go.
+type excelExpr struct {
+	src  string
+	prec int
+}
+
+// operand returns x as the operand of an operator of Go with precedence
+// prec, inside parentheses when Go would bind it differently.
+func (x excelExpr) operand(prec int) string {
+	if x.prec < prec {
+		return "(" + x.src + ")"
+	}
+	return x.src
+}
+
+func binaryExpr(x excelExpr, op token.Token, y excelExpr) excelExpr {
+	prec := op.Precedence()
+	return excelExpr{x.operand(prec) + " " + op.String() + " " + y.operand(prec+1), prec}
+}
+
+// binary parses the operations whose operators have at least precedence min.
+// Like in Excel, every operator is left associative, ^ too.
+func (p *excelParser) binary(min int) (excelExpr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return excelExpr{}, err
+	}
+	for {
+		op, prec, size := p.operator()
+		if prec < min {
+			return x, nil
+		}
+		p.k += size
+		y, err := p.binary(prec + 1)
+		if err != nil {
+			return excelExpr{}, err
+		}
+		x = binaryExpr(x, op, y)
+	}
+}
+
+// unary parses signs, which bind tighter than ^ in Excel, so -2^2 is 4, and
+// the percentages after operands.
+func (p *excelParser) unary() (excelExpr, error) {
+	if tok := p.peek(); tok == token.ADD || tok == token.SUB {
+		p.k++
+		x, err := p.unary()
+		if err != nil {
+			return excelExpr{}, err
+		}
+		operand := x.operand(token.UnaryPrec)
+		if strings.HasPrefix(operand, "-") || strings.HasPrefix(operand, "+") {
+			// Go would scan --x as a decrement
+			operand = "(" + operand + ")"
+		}
+		return excelExpr{tok.String() + operand, token.UnaryPrec}, nil
+	}
+	x, err := p.primary()
+	if err != nil {
+		return excelExpr{}, err
+	}
+	for p.peek() == token.REM {
+		p.k++
+		x = binaryExpr(x, token.QUO, excelExpr{"100", token.UnaryPrec})
+	}
+	return x, nil
+}
+
+func (p *excelParser) primary() (excelExpr, error) {
+	if p.k >= len(p.toks) {
+		return excelExpr{}, fmt.Errorf("unexpected end of formula")
+	}
+	t := p.toks[p.k]
+	p.k++
+	if t.tok.IsKeyword() {
+		// Functions like if() are keywords of Go
+		t.tok, t.lit = token.IDENT, strings.ToUpper(t.tok.String())
+	}
+	switch t.tok {
+	case token.INT, token.FLOAT:
+		return excelExpr{t.lit, token.UnaryPrec}, nil
+	case token.STRING:
+		text, err := excelString(t.lit)
+		if err != nil {
+			return excelExpr{}, err
+		}
+		// Adjacent strings are a single one with a quote inside
+		for p.peek() == token.STRING && p.toks[p.k].pos == p.toks[p.k-1].end {
+			more, err := excelString(p.toks[p.k].lit)
+			if err != nil {
+				return excelExpr{}, err
+			}
+			text += `"` + more
+			p.k++
+		}
+		return excelExpr{strconv.Quote(text), token.UnaryPrec}, nil
+	case token.LPAREN:
+		x, err := p.binary(1)
+		if err != nil {
+			return excelExpr{}, err
+		}
+		if p.peek() != token.RPAREN {
+			return excelExpr{}, fmt.Errorf("expected )")
+		}
+		p.k++
+		return excelExpr{"(" + x.src + ")", token.UnaryPrec}, nil
+	case token.IDENT:
+		names := []string{t.lit}
+		for p.peek() == token.PERIOD && p.k+1 < len(p.toks) && p.toks[p.k+1].tok == token.IDENT {
+			names = append(names, p.toks[p.k+1].lit)
+			p.k += 2
+		}
+		if p.peek() != token.LPAREN {
+			return excelExpr{strings.Join(names, "."), token.UnaryPrec}, nil
+		}
+		p.k++
+		name := excelFunc(names)
+		var args []string
+		for p.peek() != token.RPAREN {
+			if len(args) > 0 {
+				if tok := p.peek(); tok != token.COMMA && tok != token.SEMICOLON {
+					return excelExpr{}, fmt.Errorf("expected , or ) after argument %d of %s", len(args), decodeIdent(name))
+				}
+				p.k++
+			}
+			arg, err := p.binary(1)
+			if err != nil {
+				return excelExpr{}, err
+			}
+			args = append(args, arg.src)
+		}
+		p.k++
+		return excelExpr{name + "(" + strings.Join(args, ", ") + ")", token.UnaryPrec}, nil
+	}
+	return excelExpr{}, fmt.Errorf("unexpected %s", t.tok)
+}
+
+// excelFunc returns the identifier of the function whose name has the given
+// parts, separated by dots. Newer versions of Excel save some functions with
+// a prefix, which is dropped, and the dots of the others become dotSep.
+func excelFunc(names []string) string {
+	if len(names) > 1 && (strings.EqualFold(names[0], "_xlfn") || strings.EqualFold(names[0], "_xlws")) {
+		names = names[1:]
+	}
+	return strings.Join(names, dotSep)
+}
+
+// excelString returns the text of a string literal. Formulas written back by
+// minicel escape quotes with backslashes, like Go, so valid Go strings are
+// unquoted like in Go, while the backslashes of the others are plain
+// characters like in Excel.
+func excelString(lit string) (string, error) {
+	if len(lit) < 2 || lit[0] != lit[len(lit)-1] {
+		return "", fmt.Errorf("unterminated string %s", lit)
+	}
+	if text, err := strconv.Unquote(lit); err == nil || lit[0] != '"' {
+		return text, err
+	}
+	return lit[1 : len(lit)-1], nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestTranslateExcel(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"A1+B1*2", ""},
+		{`IF(A1, "B2", C3)`, ""},
+		{"2*3^2", "2 * (3 ^ 2)"},
+		{"2^3+1", "2 ^ 3 + 1"},
+		{"-2^2", "-2 ^ 2"},
+		{"--A1", "-(-A1)"},
+		{"A1<-1", "A1 < -1"},
+		{`"a"&A1+1`, `"a" & (A1 + 1)`},
+		{`A1&B1=C1`, `A1 & B1 == C1`},
+		{"A1<>B1", "A1 != B1"},
+		{"A1*10%", "A1 * (10 / 100)"},
+		{`"say ""hi"""`, `"say \"hi\""`},
+		{`"C:\data"`, `"C:\\data"`},
+		{`"say \"hi\"" & A1`, `"say \"hi\"" & A1`},
+		{`IF(A1>=2;"big";"small")`, `IF(A1 >= 2, "big", "small")`},
+		{"STDEV.S(A1ːA3)", "STDEVꓸS(A1ːA3)"},
+		{"_xlfn.STDEV.P(A1ːA3)", "STDEVꓸP(A1ːA3)"},
+		{"if(A1=1, 2, 3)", "IF(A1 == 1, 2, 3)"},
+	}
+	for _, tt := range tests {
+		got, err := translateExcel(tt.formula)
+		if err != nil || got != tt.want {
+			t.Errorf("translateExcel(%q) = %q, %v, want %q", tt.formula, got, err, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"A1=", "SUM(A1;", "(A1=1", "SUM(A1; B1 C1)", `"a"&`} {
+		if got, err := translateExcel(formula); err == nil {
+			t.Errorf("translateExcel(%q) = %q, want an error", formula, got)
+		}
+	}
+}
+
+func TestExcelFormulas(t *testing.T) {
+	table := parseTable(`Fruit|Price|Qty
+apple|1.5|3
+banana|0.25|12
+cherry|4|-2
+=VLOOKUP("banana", A1:C3, 2, FALSE)|=VLOOKUP("blueberry", A1:C3, 3)|=IFERROR(VLOOKUP("kiwi"; A1:C3; 2; 0); "none")
+=HLOOKUP("Qty", A0:C3, 3, FALSE)|=MIN(B1:C3)|=MAX(B1:C3)+ABS(C3)
+=COUNT(A1:C3, 1, "x")|=STDEV.S(B1:B3)=STDEV(B1:B3)|=CONCAT(A1, " ", C1)
+=IF(C3<0;"short";"ok")|=B1*C1^2&" each"|=C1=3
+0|=IF(A8=0,0,B1/A8)|=IF(ISNUMBER(A1),A1*2,"-")`)
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	// Like in Excel, IF doesn't evaluate the branch it doesn't pick
+	want := "0.25|12.00|none\n12.00|-2.00|14.00\n7.00|TRUE|apple 3\nshort|13.5 each|TRUE\n0.00|0.00|-"
+	if got := tableContents(table[4:]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Shifting formulas keeps the names Excel gives to functions
+	got, err := shiftReferences("=_xlfn.STDEV.S(A1:A3)^2", 1, 0)
+	if want := "=STDEV.S(A2:A4) ^ 2"; err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
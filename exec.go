@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const commandTimeout = 30 * time.Second
+
+// runCommands replaces every `!command` cell with the trimmed standard
+// output of the command, run through the shell. Unless allowed is set, the
+// sheet can't run commands at all, whatever -allow-exec says.
+func runCommands(table Table, allowed bool) error {
+	for i, row := range table {
+		for j, cell := range row {
+			if cell.Type != Command {
+				continue
+			}
+			if !allowed {
+				return fmt.Errorf("%s: shell commands can't be run by this sheet", cellName(i, j))
+			}
+
+			out, err := runCommand(cell.Content[1:])
+			if err != nil {
+				return fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+
+			// Like any other data coming from outside, the output is never
+			// evaluated
+			c := parseCell(out)
+			if c.Type != Number && c.Content != "" {
+				c.Type = Text
+			}
+			table[i][j] = c.withMeta(cell)
+		}
+	}
+	return nil
+}
+
+func runCommand(command string) (string, error) {
+	if *deterministicFlag {
+		return "", fmt.Errorf("shell commands are disabled by -deterministic")
+	}
+	if !*allowExecFlag {
+		return "", fmt.Errorf("shell commands require -allow-exec")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	if err := runCommands(parseTable("!echo 1"), true); err == nil || !strings.Contains(err.Error(), "-allow-exec") {
+		t.Errorf("expected commands to require -allow-exec, got %v", err)
+	}
+
+	*allowExecFlag = true
+	defer func() { *allowExecFlag = false }()
+
+	table := parseTable("!echo 42|!printf '=A0 disk'|=A0*2")
+	if err := runCommands(table, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	for j, want := range []Cell{{Content: "42.00", Type: Number}, {Content: "=A0 disk", Type: Text}, {Content: "84.00", Type: Number}} {
+		if table[0][j] != want {
+			t.Errorf("%s: got %+v, want %+v", cellName(0, j), table[0][j], want)
+		}
+	}
+
+	// Whoever loads the sheet can forbid commands altogether
+	if err := runCommands(parseTable("!echo 1"), false); err == nil || err.Error() != "A0: shell commands can't be run by this sheet" {
+		t.Errorf("got error %v", err)
+	}
+
+	err := runCommands(parseTable("!echo oops >&2; exit 3"), true)
+	if err == nil || err.Error() != "A0: exit status 3: oops" {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchCacheTTL is how long a fetched URL is reused before fetching it
+// again, so that a URL used by many cells (or by many recalculations of the
+// same sheet) is only requested once.
+const fetchCacheTTL = time.Minute
+
+const fetchMaxSize = 1 << 20
+
+type fetchEntry struct {
+	body    string
+	fetched time.Time
+}
+
+var fetchCache = struct {
+	sync.Mutex
+	entries map[string]fetchEntry
+}{entries: make(map[string]fetchEntry)}
+
+func init() {
+	RegisterFunc("FETCH", fetchFunc)
+}
+
+// fetchFunc implements `FETCH("url")`, which evaluates to the trimmed body of
+// the response: a number when it can be parsed as one, a text otherwise.
+func fetchFunc(args ...Value) (Value, error) {
+	if len(args) != 1 || args[0].Kind() != TextKind {
+		return Value{}, fmt.Errorf("expected FETCH(\"url\")")
+	}
+
+	body, err := fetchURL(args[0].Text())
+	if err != nil {
+		return Value{}, err
+	}
+	if value, err := parseNumber(body); err == nil {
+		return NumberValue(value), nil
+	}
+	return TextValue(body), nil
+}
+
+// callsFetch tells whether the formula calls FETCH. Sheets which aren't
+// local, like the ones sent to the server, can't hold such formulas, so that
+// they can't make requests with the network access of whoever runs minicel.
+func callsFetch(formula string) bool {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		return false
+	}
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if ident, ok := call.Fun.(*ast.Ident); ok && funcName(ident.Name) == "FETCH" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// fetchURL returns the trimmed body of the response to a GET request, which
+// is only made when -allow-net is passed, for the formulas of local sheets.
+func fetchURL(url string) (string, error) {
+	if *deterministicFlag {
+		return "", fmt.Errorf("disabled by -deterministic")
+	}
+	if !*allowNetFlag {
+		return "", fmt.Errorf("requires -allow-net")
+	}
+
+	fetchCache.Lock()
+	entry, ok := fetchCache.entries[url]
+	fetchCache.Unlock()
+	if ok && time.Since(entry.fetched) < fetchCacheTTL {
+		return entry.body, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *fetchTimeoutFlag)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, fetchMaxSize))
+	if err != nil {
+		return "", err
+	}
+
+	entry = fetchEntry{body: strings.TrimSpace(string(body)), fetched: time.Now()}
+	fetchCache.Lock()
+	fetchCache.entries[url] = entry
+	fetchCache.Unlock()
+	return entry.body, nil
+}
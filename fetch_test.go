@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	*allowNetFlag = true
+	defer func() { *allowNetFlag = false }()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/price":
+			fmt.Fprintln(w, "42.5")
+		case "/name":
+			fmt.Fprintln(w, "=A1 widget")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	table := parseTable(fmt.Sprintf(`=FETCH("%[1]s/price")|=FETCH("%[1]s/price")*2|=FETCH("%[1]s/name")`, srv.URL))
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	for j, want := range []Cell{{Content: "42.50", Type: Number}, {Content: "85.00", Type: Number}, {Content: "=A1 widget", Type: Text}} {
+		if table[0][j] != want {
+			t.Errorf("%s: got %+v, want %+v", cellName(0, j), table[0][j], want)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want 2", requests)
+	}
+
+	table = parseTable(fmt.Sprintf(`=FETCH("%s/missing")`, srv.URL))
+	if err := evalTable(table); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want a 404 error", err)
+	}
+}
+
+func TestFetchRequiresAllowNet(t *testing.T) {
+	table := parseTable(`=FETCH("http://localhost/")`)
+	if err := evalTable(table); err == nil {
+		t.Error("FETCH should fail without -allow-net")
+	}
+}
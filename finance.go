@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	RegisterFunc("PMT", pmt)
+	RegisterFunc("FV", fv)
+	RegisterFunc("PV", pv)
+	RegisterFunc("NPV", npv)
+	RegisterFunc("IRR", irr)
+}
+
+// tvmArgs returns the arguments of the time value of money functions, which
+// all take a rate, a number of periods, a third amount and optionally a
+// fourth amount and when payments are due: 0 (the default) at the end of
+// each period, 1 at the beginning.
+func tvmArgs(args []Value, usage string) (rate, nper, a, b, due float64, err error) {
+	if len(args) < 3 || len(args) > 5 {
+		return 0, 0, 0, 0, 0, fmt.Errorf("expected %s", usage)
+	}
+
+	nums := make([]float64, 5)
+	for k, arg := range args {
+		if nums[k], err = arg.Number(); err != nil {
+			return 0, 0, 0, 0, 0, err
+		}
+	}
+	if nums[4] != 0 && nums[4] != 1 {
+		return 0, 0, 0, 0, 0, fmt.Errorf("type must be 0 or 1, got %g", nums[4])
+	}
+	return nums[0], nums[1], nums[2], nums[3], nums[4], nil
+}
+
+// annuity returns the factor by which a payment is multiplied over nper
+// periods, so that pv*(1+rate)^nper + pmt*annuity + fv = 0.
+func annuity(rate, nper, due float64) float64 {
+	if rate == 0 {
+		return nper
+	}
+	return (1 + rate*due) * (math.Pow(1+rate, nper) - 1) / rate
+}
+
+// pmt implements `PMT(rate, nper, pv, [fv], [type])`, the payment of each
+// period of a loan. Like in other spreadsheets money paid out is negative,
+// so the payments of a loan (a positive pv) are negative.
+func pmt(args ...Value) (Value, error) {
+	rate, nper, pv, fv, due, err := tvmArgs(args, "PMT(rate, nper, pv, [fv], [type])")
+	if err != nil {
+		return Value{}, err
+	}
+	if nper == 0 {
+		return Value{}, fmt.Errorf("nper can't be 0")
+	}
+	return NumberValue(-(pv*math.Pow(1+rate, nper) + fv) / annuity(rate, nper, due)), nil
+}
+
+// fv implements `FV(rate, nper, pmt, [pv], [type])`, the value reached
+// after nper periods.
+func fv(args ...Value) (Value, error) {
+	rate, nper, pmt, pv, due, err := tvmArgs(args, "FV(rate, nper, pmt, [pv], [type])")
+	if err != nil {
+		return Value{}, err
+	}
+	return NumberValue(-(pv*math.Pow(1+rate, nper) + pmt*annuity(rate, nper, due))), nil
+}
+
+// pv implements `PV(rate, nper, pmt, [fv], [type])`, the value today of a
+// series of payments.
+func pv(args ...Value) (Value, error) {
+	rate, nper, pmt, fv, due, err := tvmArgs(args, "PV(rate, nper, pmt, [fv], [type])")
+	if err != nil {
+		return Value{}, err
+	}
+	return NumberValue(-(fv + pmt*annuity(rate, nper, due)) / math.Pow(1+rate, nper)), nil
+}
+
+// npv implements `NPV(rate, values...)`, the net present value of cash
+// flows at the end of each period, so that the first one is discounted too.
+func npv(args ...Value) (Value, error) {
+	if len(args) < 2 {
+		return Value{}, fmt.Errorf("expected NPV(rate, values...)")
+	}
+	rate, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	flows, err := numbers(args[1:])
+	if err != nil {
+		return Value{}, err
+	}
+
+	v := 0.0
+	for k, flow := range flows {
+		v += flow / math.Pow(1+rate, float64(k+1))
+	}
+	return NumberValue(v), nil
+}
+
+// irr implements `IRR(values, [guess])`, the rate at which the net present
+// value of the cash flows is zero, found with Newton's method starting from
+// guess (10% by default).
+func irr(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("expected IRR(values, [guess])")
+	}
+	flows, err := numbers(args[:1])
+	if err != nil {
+		return Value{}, err
+	}
+	rate := 0.1
+	if len(args) == 2 {
+		if rate, err = args[1].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+
+	var positive, negative bool
+	for _, flow := range flows {
+		positive = positive || flow > 0
+		negative = negative || flow < 0
+	}
+	if !positive || !negative {
+		return Value{}, fmt.Errorf("expected both positive and negative cash flows")
+	}
+
+	for i := 0; i < 100; i++ {
+		v, dv := 0.0, 0.0
+		for k, flow := range flows {
+			v += flow / math.Pow(1+rate, float64(k))
+			dv -= float64(k) * flow / math.Pow(1+rate, float64(k+1))
+		}
+		if dv == 0 {
+			break
+		}
+
+		next := rate - v/dv
+		if math.Abs(next-rate) < 1e-10 {
+			return NumberValue(next), nil
+		}
+		rate = next
+	}
+	return Value{}, fmt.Errorf("couldn't find a rate, try another guess")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// formatSheet rewrites the source of a sheet in a canonical way, without
+// evaluating it: cells are trimmed and padded so that the pipes line up,
+// and formulas are spaced like gofmt spaces Go expressions, with references
+// in upper case. Malformed formulas are left as they are.
+func formatSheet(content string) string {
+	lines := strings.Split(normalizeNewlines(strings.TrimPrefix(strings.TrimSpace(content), "\ufeff")), "\n")
+
+	// Regions are written as @name = A1:B2, above the table, along with the
+	// rules, which are left as they are
+	var sb strings.Builder
+	for len(lines) > 1 {
+		if m := ruleLineRegexp.FindStringSubmatch(strings.TrimSpace(lines[0])); m != nil {
+			fmt.Fprintf(&sb, "@%s %s\n", m[1], m[2])
+			lines = lines[1:]
+			continue
+		}
+		m := regionRegexp.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			break
+		}
+		if from, to, err := parseRange(m[2]); err == nil {
+			m[2] = from.String() + ":" + to.String()
+		}
+		fmt.Fprintf(&sb, "@%s = %s\n", m[1], m[2])
+		lines = lines[1:]
+	}
+
+	var widths []int
+	rows := make([][]string, len(lines))
+	for i, line := range lines {
+		for j, part := range strings.Split(line, "|") {
+			cell := formatCell(part)
+			rows[i] = append(rows[i], cell)
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := displayWidth(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	for _, row := range rows {
+		var line strings.Builder
+		for j, cell := range row {
+			if j > 0 {
+				line.WriteString(" | ")
+			}
+			line.WriteString(cell)
+			if j < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[j]-displayWidth(cell)))
+			}
+		}
+		sb.WriteString(strings.TrimRight(line.String(), " "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// formatCell formats the source of a single cell, keeping its format, note,
+// link and comment.
+func formatCell(content string) string {
+	part, meta := splitMeta(content)
+	if strings.HasPrefix(part, "=") {
+		if expr, err := parseFormula(part[1:]); err == nil {
+			part = "=" + formatFormula(canonicalRefs(expr))
+		}
+	}
+	if meta.Format != "" {
+		part += " @" + meta.Format
+	}
+	if meta.Note != "" {
+		part += " {note: " + meta.Note + "}"
+	}
+	if meta.Link != "" {
+		part += " {link: " + meta.Link + "}"
+	}
+	if meta.Comment != "" {
+		part += " ; " + meta.Comment
+	}
+	return strings.TrimSpace(part)
+}
+
+// canonicalRefs writes every reference and range inside expr in upper case.
+func canonicalRefs(expr ast.Expr) ast.Expr {
+	walkRefs(expr, func(ref cellRef) (cellRef, error) { return ref, nil })
+	return expr
+}
+
+// fmtCommand implements `minicel fmt`, printing the formatted sheets, or
+// rewriting them with -w.
+func fmtCommand(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the sheet files instead of printing it")
+	list := fs.Bool("l", false, "only list the sheets whose formatting differs")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: minicel fmt [-w] [-l] sheet...")
+	}
+
+	for _, file := range fs.Args() {
+		c, err := readSheet(file)
+		if err != nil {
+			return err
+		}
+		formatted := formatSheet(c)
+
+		switch {
+		case *list:
+			if formatted != c {
+				fmt.Println(file)
+			}
+		case *write:
+			if formatted == c {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(file, []byte(formatted), info.Mode()); err != nil {
+				return err
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestFormatSheet(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"A|B\n1|2", "A | B\n1 | 2\n"},
+		{"  Name |Qty  \r\nApples| 4\n", "Name   | Qty\nApples | 4\n"},
+		{"=a1+B1*2|=SUM( a1 : $b$1 )", "=A1 + B1*2 | =SUM(A1:$B$1)\n"},
+		{"=(A1+A2)/2|=IF(A1>0,\"yes\",\"no\")", "=(A1 + A2) / 2 | =IF(A1 > 0, \"yes\", \"no\")\n"},
+		{"42 {link: https://example.com}  {note:  Q3 }|x", "42 {note: Q3} {link: https://example.com} | x\n"},
+		{"=a1*2 {note: x}  @%.4f|3 @%d", "=A1 * 2 @%.4f {note: x} | 3 @%d\n"},
+		{"=a1*2;x|3  ;  per year|; left", "=a1*2;x | 3 ; per year | ; left\n"},
+		{"=A1+|x\n\n1", "=A1+ | x\n\n1\n"},
+		{"café|1\nab|2", "café | 1\nab   | 2\n"},
+		{"@sales=a0:b1\nName|Qty\nPens|=SUM(sales[Qty])", "@sales = A0:B1\nName | Qty\nPens | =SUM(sales[Qty])\n"},
+	}
+	for _, tt := range tests {
+		got := formatSheet(tt.source)
+		if got != tt.want {
+			t.Errorf("formatSheet(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+		if again := formatSheet(got); again != got {
+			t.Errorf("formatting %q again gives %q", got, again)
+		}
+	}
+}
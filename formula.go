@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var refRegexp = regexp.MustCompile(`^(\$?)([A-Za-z])(\$?)(\d+)$`)
+
+// cellRef is a reference to a cell inside a formula. Its row and column can
+// be anchored with `$` (like in `$A$1`) so that they are left untouched when
+// the formula is cloned. Columns can be written in lower case too, but are
+// always written back in upper case.
+type cellRef struct {
+	Row, Col       int
+	AbsRow, AbsCol bool
+}
+
+func parseRef(name string) (cellRef, error) {
+	ref, ok := scanRef(name)
+	if !ok {
+		return cellRef{}, invalidRef(name)
+	}
+	return ref, nil
+}
+
+// scanRef is like parseRef, only telling whether name is a reference
+// rather than what's wrong with it. It's scanned by hand rather than matched
+// against refRegexp, as it's called for every identifier of every formula.
+func scanRef(name string) (ref cellRef, ok bool) {
+	s := name
+	if ref.AbsCol = strings.HasPrefix(s, "$"); ref.AbsCol {
+		s = s[1:]
+	}
+	if s == "" || s[0] >= utf8.RuneSelf || !unicode.IsLetter(rune(s[0])) {
+		return cellRef{}, false
+	}
+	ref.Col = int(unicode.ToUpper(rune(s[0])) - 'A')
+	s = s[1:]
+	if ref.AbsRow = strings.HasPrefix(s, "$"); ref.AbsRow {
+		s = s[1:]
+	}
+	if s == "" {
+		return cellRef{}, false
+	}
+	for k := 0; k < len(s); k++ {
+		if s[k] < '0' || s[k] > '9' {
+			return cellRef{}, false
+		}
+	}
+
+	var err error
+	if ref.Row, err = strconv.Atoi(s); err != nil {
+		return cellRef{}, false
+	}
+	return ref, true
+}
+
+var badRefRegexp = regexp.MustCompile(`^\$?([A-Za-z]*)\$?(\d*)(.*)$`)
+
+// invalidRef reports a malformed cell reference, telling what's wrong with
+// it.
+func invalidRef(name string) error {
+	// Anchors inside formulas are still encoded when the reference is malformed
+	if decoded := strings.ReplaceAll(name, "_", "$"); badRefRegexp.FindStringSubmatch(decoded)[3] == "" {
+		name = decoded
+	}
+	m := badRefRegexp.FindStringSubmatch(name)
+	letters, digits, rest := m[1], m[2], m[3]
+
+	var reason string
+	switch {
+	case letters == "" && digits == "":
+		reason = "expected a column from A to Z followed by a row, like A1"
+	case letters == "":
+		reason = "missing the column, like A" + digits
+	case digits == "" && rest == "":
+		reason = "missing the row, like " + letters + "1"
+	case rest != "":
+		reason = fmt.Sprintf("unexpected %q after %s%s", rest, letters, digits)
+	case len(letters) > 1:
+		reason = "columns only go from A to Z"
+	default:
+		reason = "the row is too big"
+	}
+	return fmt.Errorf("invalid cell identifier %q: %s", name, reason)
+}
+
+func (ref cellRef) String() string {
+	var sb strings.Builder
+	if ref.AbsCol {
+		sb.WriteByte('$')
+	}
+	sb.WriteByte(byte('A' + ref.Col))
+	if ref.AbsRow {
+		sb.WriteByte('$')
+	}
+	sb.WriteString(strconv.Itoa(ref.Row))
+	return sb.String()
+}
+
+// parseRange parses a range of cells like `A1:B3`.
+func parseRange(name string) (from, to cellRef, err error) {
+	a, b, ok := strings.Cut(name, ":")
+	if !ok {
+		return cellRef{}, cellRef{}, fmt.Errorf("invalid range %q", name)
+	}
+	if from, err = parseRef(a); err != nil {
+		return cellRef{}, cellRef{}, err
+	}
+	if to, err = parseRef(b); err != nil {
+		return cellRef{}, cellRef{}, err
+	}
+	return from, to, nil
+}
+
+var wholeColsRegexp = regexp.MustCompile(`^([A-Za-z]):([A-Za-z])$`)
+var wholeRowsRegexp = regexp.MustCompile(`^(\d+):(\d+)$`)
+
+// parseWholeRange parses a range of whole columns like `A:C`, whose corners
+// have -1 as row, or of whole rows like `2:4`, whose corners have -1 as
+// column.
+func parseWholeRange(name string) (from, to cellRef, ok bool) {
+	if m := wholeColsRegexp.FindStringSubmatch(name); m != nil {
+		from = cellRef{Row: -1, Col: int(unicode.ToUpper(rune(m[1][0])) - 'A')}
+		to = cellRef{Row: -1, Col: int(unicode.ToUpper(rune(m[2][0])) - 'A')}
+		return from, to, true
+	}
+	if m := wholeRowsRegexp.FindStringSubmatch(name); m != nil {
+		a, errA := strconv.Atoi(m[1])
+		b, errB := strconv.Atoi(m[2])
+		if errA == nil && errB == nil {
+			return cellRef{Row: a, Col: -1}, cellRef{Row: b, Col: -1}, true
+		}
+	}
+	return cellRef{}, cellRef{}, false
+}
+
+// rangeCorners returns the top left and bottom right corners of the range,
+// whichever corners it was written with.
+func rangeCorners(from, to cellRef) (cellRef, cellRef) {
+	if from.Row > to.Row {
+		from.Row, to.Row = to.Row, from.Row
+	}
+	if from.Col > to.Col {
+		from.Col, to.Col = to.Col, from.Col
+	}
+	return from, to
+}
+
+// rangeCells returns the positions of every cell inside the range, row by
+// row, whichever corners it was written with. Ranges are only enumerated
+// once checked against the table, as their size isn't bounded by it.
+func rangeCells(from, to cellRef) [][2]int {
+	lo, hi := rangeCorners(from, to)
+	var cells [][2]int
+	for i := lo.Row; i <= hi.Row; i++ {
+		for j := lo.Col; j <= hi.Col; j++ {
+			cells = append(cells, [2]int{i, j})
+		}
+	}
+	return cells
+}
+
+// rangeSep stands for `:` while parsing formulas. It's a letter, so that a
+// range like `A1:B3` is parsed as a single identifier.
+const rangeSep = "ː"
+
+// refErrorIdent stands for `#REF!`, left inside formulas by references to
+// deleted cells, while parsing them. Like rangeSep, ǃ is a letter.
+const refErrorIdent = "REFǃ"
+
+// parseFormula parses the expression of a formula, without the leading `=`.
+//
+// Formulas are parsed as Go expressions, but Go doesn't allow `$` inside
+// identifiers nor `:` inside expressions, so anchors are encoded as `_` and
+// ranges as identifiers before handing the formula to go/parser, and decoded
+// again inside the resulting identifiers. Formulas pasted from Excel are
+// translated first by translateExcel.
+func parseFormula(formula string) (ast.Expr, error) {
+	src := encodeFormula(formula)
+	excel, err := translateExcel(src)
+	if err != nil {
+		msg := strings.ReplaceAll(err.Error(), rangeSep, ":")
+		return nil, fmt.Errorf("malformed formula %q: %s", "="+formula, msg)
+	}
+	if excel != "" {
+		src = excel
+	}
+	expr, err := parser.ParseExpr(src)
+	if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+		// Positions refer to the encoded formula, so only the message is kept
+		msg := strings.ReplaceAll(list[0].Msg, rangeSep, ":")
+		return nil, fmt.Errorf("malformed formula %q: %s", "="+formula, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			ident.Name = decodeIdent(ident.Name)
+		}
+		return true
+	})
+	return expr, nil
+}
+
+// decodeIdent is the inverse of encodeFormula for a single identifier.
+func decodeIdent(name string) string {
+	if name == refErrorIdent {
+		return errRef.Error()
+	}
+	if strings.Contains(name, dotSep) {
+		return strings.ReplaceAll(name, dotSep, ".")
+	}
+	if rows := strings.Replace(strings.TrimPrefix(name, rangeSep), rangeSep, ":", 1); rows != name && wholeRowsRegexp.MatchString(rows) {
+		return rows
+	}
+	if cols := strings.Replace(name, rangeSep, ":", 1); wholeColsRegexp.MatchString(cols) {
+		return cols
+	}
+	parts := strings.Split(name, rangeSep)
+	for k, part := range parts {
+		decoded := strings.ReplaceAll(part, "_", "$")
+		if !refRegexp.MatchString(decoded) {
+			return name
+		}
+		parts[k] = decoded
+	}
+	if len(parts) > 2 {
+		return name
+	}
+	return strings.Join(parts, ":")
+}
+
+// encodeFormula replaces every `$` outside of string literals with `_`,
+// every `:` with rangeSep, dropping the spaces around it, and every `#REF!`
+// with refErrorIdent. Ranges of whole rows, like `2:4`, start with rangeSep
+// too, so that they are identifiers rather than numbers.
+func encodeFormula(formula string) string {
+	var buf []byte
+	var quote byte
+	for i := 0; i < len(formula); i++ {
+		c := formula[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(formula) {
+				buf = append(buf, c)
+				i++
+				c = formula[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '$':
+			c = '_'
+		case strings.HasPrefix(formula[i:], errRef.Error()):
+			buf = append(buf, refErrorIdent...)
+			i += len(errRef.Error()) - 1
+			continue
+		case c == ':':
+			for len(buf) > 0 && buf[len(buf)-1] == ' ' {
+				buf = buf[:len(buf)-1]
+			}
+			for i+1 < len(formula) && formula[i+1] == ' ' {
+				i++
+			}
+			if k := trailingRow(buf); k >= 0 && leadingRow(formula[i+1:]) {
+				buf = append(buf[:k], append([]byte(rangeSep), buf[k:]...)...)
+			}
+			buf = append(buf, rangeSep...)
+			continue
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
+
+// isIdentByte tells whether c can be part of an identifier or a number.
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 0x80 || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// trailingRow returns where the number at the end of buf starts, or -1
+// when buf doesn't end with a number standing on its own.
+func trailingRow(buf []byte) int {
+	k := len(buf)
+	for k > 0 && buf[k-1] >= '0' && buf[k-1] <= '9' {
+		k--
+	}
+	if k == len(buf) || k > 0 && isIdentByte(buf[k-1]) {
+		return -1
+	}
+	return k
+}
+
+// leadingRow tells whether s starts with a number standing on its own.
+func leadingRow(s string) bool {
+	k := 0
+	for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+		k++
+	}
+	return k > 0 && (k == len(s) || !isIdentByte(s[k]))
+}
+
+// invalidRange reports a malformed range, still encoded like inside parsed
+// formulas.
+func invalidRange(name string) error {
+	parts := strings.Split(name, rangeSep)
+	name = strings.Join(parts, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid range %q: expected two corners, like A1:B3", name)
+	}
+	for _, part := range parts {
+		if _, err := parseRef(strings.ReplaceAll(part, "_", "$")); err != nil {
+			return fmt.Errorf("invalid range %q: %w", name, err)
+		}
+	}
+	return fmt.Errorf("invalid range %q", name)
+}
+
+// formatFormula is the inverse of parseFormula.
+func formatFormula(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+// walkIdents calls fn for every identifier inside expr that could be a
+// reference, stopping as soon as fn returns false.
+func walkIdents(expr ast.Expr, fn func(ident *ast.Ident) bool) {
+	done := false
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		if done {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// Function names are never cell references, even when they look
+			// like one (e.g. `LOG10`), only their arguments can be
+			for _, arg := range n.Args {
+				ast.Inspect(arg, visit)
+			}
+			return false
+		case *ast.SelectorExpr:
+			return false
+		case *ast.Ident:
+			done = !fn(n)
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+}
+
+// walkRefs calls fn for every cell reference inside expr, including both
+// corners of ranges, replacing each one with the reference returned by fn.
+// It stops at the first error.
+func walkRefs(expr ast.Expr, fn func(ref cellRef) (cellRef, error)) error {
+	var err error
+	walkIdents(expr, func(ident *ast.Ident) bool {
+		if ref, ok := scanRef(ident.Name); ok {
+			if ref, err = fn(ref); err == nil {
+				ident.Name = ref.String()
+			}
+		} else if from, to, e := parseRange(ident.Name); e == nil {
+			if from, err = fn(from); err != nil {
+				return false
+			}
+			if to, err = fn(to); err == nil {
+				ident.Name = from.String() + ":" + to.String()
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// formulaRefs returns every cell referenced by the formula, including every
+// cell inside its ranges, with the table telling where whole columns and
+// rows end.
+func formulaRefs(table Table, formula string) ([]cellRef, error) {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		return nil, err
+	}
+	return exprRefs(table, expr), nil
+}
+
+// exprRefs returns every cell referenced by a parsed formula, like
+// formulaRefs.
+func exprRefs(table Table, expr ast.Expr) []cellRef {
+	var refs []cellRef
+	width := -1
+	walkIdents(expr, func(ident *ast.Ident) bool {
+		if ref, ok := scanRef(ident.Name); ok {
+			refs = append(refs, ref)
+		} else if from, to, err := parseRange(ident.Name); err == nil {
+			// Only the part of the range inside the table holds cells
+			if width < 0 {
+				width = tableWidth(table)
+			}
+			from, to = rangeCorners(from, to)
+			if to.Row >= len(table) {
+				to.Row = len(table) - 1
+			}
+			if to.Col >= width {
+				to.Col = width - 1
+			}
+			for _, pos := range rangeCells(from, to) {
+				refs = append(refs, cellRef{Row: pos[0], Col: pos[1]})
+			}
+		} else if from, to, ok := parseWholeRange(ident.Name); ok {
+			if from, to, err := dataRange(table, from, to); err == nil {
+				for _, pos := range rangeCells(from, to) {
+					refs = append(refs, cellRef{Row: pos[0], Col: pos[1]})
+				}
+			}
+		}
+		return true
+	})
+	return refs
+}
+
+// shiftReferences moves every relative cell reference inside the formula by
+// the given amount of rows and columns.
+func shiftReferences(formula string, rows, cols int) (string, error) {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		return "", err
+	}
+
+	err = walkRefs(expr, func(ref cellRef) (cellRef, error) {
+		shifted := ref
+		if !ref.AbsRow {
+			shifted.Row += rows
+		}
+		if !ref.AbsCol {
+			shifted.Col += cols
+		}
+		if shifted.Col < 0 || shifted.Col >= 26 || shifted.Row < 0 {
+			return ref, fmt.Errorf("cloned reference %s out of bounds", ref)
+		}
+		return shifted, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "=" + formatFormula(expr), nil
+}
+
+// shiftTokens is like shiftReferences, for formulas written by it. Rather
+// than parsing them and writing them back, it scans them for references,
+// so that clones of clones don't pay for parsing again a formula that is
+// already written canonically.
+func shiftTokens(formula string, rows, cols int) (string, error) {
+	return mapRefTokens(formula, func(ref cellRef, token string) (string, error) {
+		shifted := ref
+		if !ref.AbsRow {
+			shifted.Row += rows
+		}
+		if !ref.AbsCol {
+			shifted.Col += cols
+		}
+		if shifted.Col < 0 || shifted.Col >= 26 || shifted.Row < 0 {
+			return "", fmt.Errorf("cloned reference %s out of bounds", ref)
+		}
+		return shifted.String(), nil
+	})
+}
+
+// mapRefTokens replaces every reference inside the formula with what fn
+// returns for it, given the reference and how it's written, skipping the
+// ones inside strings.
+func mapRefTokens(formula string, fn func(ref cellRef, token string) (string, error)) (string, error) {
+	buf, err := appendRefTokens(make([]byte, 0, len(formula)+8), formula, func(buf []byte, ref cellRef, token string) ([]byte, error) {
+		token, err := fn(ref, token)
+		return append(buf, token...), err
+	})
+	return string(buf), err
+}
+
+// appendRefTokens is like mapRefTokens, appending the formula to buf and
+// letting fn append the references.
+func appendRefTokens(buf []byte, formula string, fn func(buf []byte, ref cellRef, token string) ([]byte, error)) ([]byte, error) {
+	var quote byte
+	for i := 0; i < len(formula); {
+		c := formula[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(formula) {
+				buf = append(buf, c)
+				i++
+				c = formula[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '$' || isIdentByte(c):
+			k := i
+			for k < len(formula) && (formula[k] == '$' || isIdentByte(formula[k])) {
+				k++
+			}
+			token := formula[i:k]
+			// Numbers and function names are never references
+			if ref, ok := scanRef(token); ok && (k == len(formula) || formula[k] != '(') {
+				var err error
+				if buf, err = fn(buf, ref, token); err != nil {
+					return nil, err
+				}
+			} else {
+				buf = append(buf, token...)
+			}
+			i = k
+			continue
+		}
+		buf = append(buf, c)
+		i++
+	}
+	return buf, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShiftReferences(t *testing.T) {
+	tests := []struct {
+		formula    string
+		rows, cols int
+		want       string
+	}{
+		{"=A1+B1", 1, 0, "=A2 + B2"},
+		{"=A1+A2", 1, 0, "=A2 + A3"},
+		{"=A1*A10", 1, 0, "=A2 * A11"},
+		{"=A1+B1", 0, 1, "=B1 + C1"},
+		{"=$A$1+A$1+$A1", 1, 1, "=$A$1 + B$1 + $A2"},
+		{`=IF(A1, "B2 is Big", C3)`, 1, 0, `=IF(A2, "B2 is Big", C4)`},
+		{"=LOG10(A1)+F2(B2)", 1, 1, "=LOG10(B2) + F2(C3)"},
+		{"=STDEV.S(A1)", 1, 0, "=STDEV.S(A2)"},
+		{"=SUM(A1:B3)", 1, 1, "=SUM(B2:C4)"},
+		{"=MEDIAN(A$1 : A3, $C$1:$C$9)", 2, 0, "=MEDIAN(A$1:A5, $C$1:$C$9)"},
+		{"=((A1+1)*(B1-1))/2", 1, 0, "=((A2 + 1) * (B2 - 1)) / 2"},
+		{"=a1+$b$1+sum(c1:D2)", 1, 0, "=A2 + $B$1 + sum(C2:D3)"},
+	}
+
+	for _, tt := range tests {
+		got, err := shiftReferences(tt.formula, tt.rows, tt.cols)
+		if err != nil {
+			t.Errorf("shiftReferences(%q): %v", tt.formula, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("shiftReferences(%q, %d, %d) = %q, want %q", tt.formula, tt.rows, tt.cols, got, tt.want)
+		}
+	}
+}
+
+func TestShiftTokens(t *testing.T) {
+	// Shifting a formula already written by shiftReferences by scanning it
+	// must give the same result as parsing it again
+	for _, formula := range []string{
+		"=A1+B1", "=$A$1+A$1+$A1", `=IF(A1, "B2 is \"Big\"", C3)`, "=LOG10(A1)+F2(B2)", "=STDEV.S(A1)",
+		"=MEDIAN(A$1 : A3, $C$1:$C$9)", "=((A1+1)*(B1-1))/2", "=a1+$b$1+sum(c1:D2)", "=SUM(B:B)+SUM(2:3)*1e5", "=#REF!+A1",
+	} {
+		canonical, err := shiftReferences(formula, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := shiftReferences(canonical, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := shiftTokens(canonical, 2, 1); err != nil || got != want {
+			t.Errorf("shiftTokens(%q) = %q, %v, want %q", canonical, got, err, want)
+		}
+	}
+
+	for _, formula := range []string{"=A0 + 1", "=Z1"} {
+		if _, err := shiftTokens(formula, -1, 1); err == nil || err.Error() != "cloned reference "+formula[1:3]+" out of bounds" {
+			t.Errorf("shiftTokens(%q) = %v", formula, err)
+		}
+	}
+}
+
+func BenchmarkResolveClones(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("A|B|C\n1|2|=A1*B1+SUM($A$1:A1)\n")
+	for k := 0; k < 5000; k++ {
+		sb.WriteString(":^+|:^|:^\n")
+	}
+	source := sb.String()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := resolveClones(parseTable(source)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseTable(b *testing.B) {
+	var sb strings.Builder
+	for k := 0; k < 100000; k++ {
+		sb.WriteString("Pens {note: x}|12.5|=A1*2|:^|text ; comment|1e3|@%d|4|5|6\n")
+	}
+	source := sb.String()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		parseTable(source)
+	}
+}
+
+func TestParseTableConcurrently(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var sb strings.Builder
+	for k := 0; k < 3*parallelParseRows; k++ {
+		fmt.Fprintf(&sb, "Row %d {note: n%d}|%d|=A%d*2|:^|x ; c|@%%d\n", k, k, k, k)
+	}
+	source := sb.String()
+
+	lines := strings.Split(source, "\n")
+	want := make(Table, len(lines))
+	parseRows(want, lines, 0, len(lines))
+	if got := parseTable(source); !reflect.DeepEqual(got, want) {
+		t.Error("rows parsed concurrently differ from the ones parsed in order")
+	}
+}
+
+func TestShiftReferencesOutOfBounds(t *testing.T) {
+	for _, formula := range []string{"=A0+1", "=Z1"} {
+		if _, err := shiftReferences(formula, -1, 1); err == nil {
+			t.Errorf("shiftReferences(%q) should fail", formula)
+		}
+	}
+}
+
+func TestMalformedReferences(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"=A", `A0: invalid cell identifier "A": missing the row, like A1`},
+		{"=$A+1", `A0: invalid cell identifier "$A": missing the row, like A1`},
+		{"=A1B", `A0: invalid cell identifier "A1B": unexpected "B" after A1`},
+		{"=AA1", `A0: invalid cell identifier "AA1": columns only go from A to Z`},
+		{"=a1b", `A0: invalid cell identifier "a1b": unexpected "b" after a1`},
+		{"=SUM(A1:B)", `A0: invalid range "A1:B": invalid cell identifier "B": missing the row, like B1`},
+		{"=A1:B2:C3", `A0: invalid range "A1:B2:C3": expected two corners, like A1:B3`},
+		{"=1A", `A0: malformed formula "=1A": expected 'EOF', found A`},
+	}
+
+	for _, tt := range tests {
+		err := evalTable(parseTable(tt.formula))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %s", tt.formula, err, tt.want)
+		}
+	}
+}
+
+func TestWholeRanges(t *testing.T) {
+	table := parseTable("Item|Qty|Price\npens|2|1.5\nink|3|\n|\nTotal|=SUM(B:B)|=SUM(1 : 2)")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	if got := tableContents(table[4:]); got != "Total|5.00|6.50" {
+		t.Errorf("got %q", got)
+	}
+
+	tests := []struct {
+		name     string
+		from, to cellRef
+	}{
+		{"a:c", cellRef{Row: 1, Col: 0}, cellRef{Row: 4, Col: 2}},
+		{"C:C", cellRef{Row: 1, Col: 2}, cellRef{Row: 4, Col: 2}},
+		{"0:0", cellRef{Row: 0, Col: 0}, cellRef{Row: 0, Col: 2}},
+		{"3:2", cellRef{Row: 2, Col: 0}, cellRef{Row: 3, Col: 1}},
+	}
+	for _, tt := range tests {
+		whole, wholeTo, ok := parseWholeRange(tt.name)
+		if !ok {
+			t.Errorf("parseWholeRange(%q) failed", tt.name)
+			continue
+		}
+		from, to, err := dataRange(table, whole, wholeTo)
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("%s: got %v:%v, %v, want %v:%v", tt.name, from, to, err, tt.from, tt.to)
+		}
+	}
+
+	for _, formula := range []string{"=SUM(D:D)", "=SUM(9:9)", "=SUM(2:2)"} {
+		if err := evalTable(parseTable("A|B\n1|2\n|\n" + formula)); err == nil {
+			t.Errorf("%s should fail", formula)
+		}
+	}
+
+	// Numbers next to a colon are only rows on their own
+	for formula, want := range map[string]string{"SUM(1:2)": "SUM(1:2)", "A1:B2": "A1:B2", "IF(A1, 1, 2)": "IF(A1, 1, 2)", "x(A:B)": "x(A:B)"} {
+		expr, err := parseFormula(formula)
+		if err != nil || formatFormula(expr) != want {
+			t.Errorf("parseFormula(%q) = %v, %v", formula, formatFormula(expr), err)
+		}
+	}
+}
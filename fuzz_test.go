@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzParseTable(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("csv", "*.csv"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		c, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(c))
+	}
+	f.Add(":")
+	f.Add(":^")
+	f.Add("=Z99")
+	f.Add("1|2\n=A0+B0")
+	f.Add("0\r0")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		content := strings.TrimSpace(input)
+		table := parseTable(content)
+
+		lines := strings.Split(normalizeNewlines(content), "\n")
+		if len(table) != len(lines) {
+			t.Fatalf("got %d rows, want %d", len(table), len(lines))
+		}
+		for i, line := range lines {
+			if want := len(strings.Split(line, "|")); len(table[i]) != want {
+				t.Fatalf("row %d: got %d cells, want %d", i, len(table[i]), want)
+			}
+		}
+
+		if err := resolveClones(table); err != nil {
+			return
+		}
+		for i, row := range table {
+			for j, cell := range row {
+				if cell.Type == Clone {
+					t.Fatalf("%s: unresolved clone %q", cellName(i, j), cell.Content)
+				}
+			}
+		}
+
+		if err := evalTable(table); err != nil {
+			return
+		}
+		for i, row := range table {
+			for j, cell := range row {
+				if cell.Type == Expression {
+					t.Fatalf("%s: unevaluated expression %q", cellName(i, j), cell.Content)
+				}
+			}
+		}
+
+		dumpTable(ioutil.Discard, table)
+	})
+}
+
+func FuzzParseExpr(f *testing.F) {
+	f.Add("A0+B0")
+	f.Add("A1*B1/2")
+	f.Add("69+420")
+	f.Add("A")
+	f.Add("a1")
+	f.Add("Z99")
+	f.Add("C0")
+	f.Add("\"text\"")
+	f.Add("$A$1+A$1*$B1")
+
+	table := parseTable("A|B|C\n1|2|=A1\n3|4|")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		expr, err := parseFormula(input)
+		if err != nil {
+			return
+		}
+		parseExpr(table, expr)
+	})
+}
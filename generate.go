@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxGenerated is the most values a generator can produce.
+const maxGenerated = 1 << 20
+
+func init() {
+	RegisterFunc("SEQUENCE", sequence)
+	RegisterFunc("LINSPACE", linspace)
+	RegisterFunc("UNIQUE", unique)
+	RegisterFunc("SORT", sortFunc)
+	RegisterFunc("FILTER", filter)
+}
+
+// sequence implements `SEQUENCE(rows, [columns], [start], [step])`, a range
+// counting from start (1 by default) by step (1 by default), row by row.
+func sequence(args ...Value) (Value, error) {
+	if len(args) < 1 || len(args) > 4 {
+		return Value{}, fmt.Errorf("expected SEQUENCE(rows, [columns], [start], [step])")
+	}
+	rows, err := positionArg(args[0], "rows")
+	if err != nil {
+		return Value{}, err
+	}
+	cols := 1
+	if len(args) > 1 {
+		if cols, err = positionArg(args[1], "columns"); err != nil {
+			return Value{}, err
+		}
+	}
+	start, step := 1.0, 1.0
+	if len(args) > 2 {
+		if start, err = args[2].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+	if len(args) > 3 {
+		if step, err = args[3].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+	if rows*cols > maxGenerated {
+		return Value{}, fmt.Errorf("too many values, at most %d can be generated", maxGenerated)
+	}
+
+	values := make([][]Value, rows)
+	for i := range values {
+		values[i] = make([]Value, cols)
+		for j := range values[i] {
+			values[i][j] = NumberValue(start + float64(i*cols+j)*step)
+		}
+	}
+	return ArrayValue(values), nil
+}
+
+// linspace implements `LINSPACE(start, stop, n)`, a column of n numbers
+// evenly spaced from start to stop, both included.
+func linspace(args ...Value) (Value, error) {
+	if len(args) != 3 {
+		return Value{}, fmt.Errorf("expected LINSPACE(start, stop, n)")
+	}
+	start, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	stop, err := args[1].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	n, err := positionArg(args[2], "n")
+	if err != nil {
+		return Value{}, err
+	}
+	if n > maxGenerated {
+		return Value{}, fmt.Errorf("too many values, at most %d can be generated", maxGenerated)
+	}
+
+	values := make([][]Value, n)
+	for i := range values {
+		x := start
+		if n > 1 {
+			x += (stop - start) * float64(i) / float64(n-1)
+		}
+		values[i] = []Value{NumberValue(x)}
+	}
+	return ArrayValue(values), nil
+}
+
+// rowKey identifies a row of values regardless of the case of texts, like
+// compare does.
+func rowKey(row []Value) string {
+	key := make([]string, len(row))
+	for j, v := range row {
+		key[j] = fmt.Sprintf("%d:%s", v.Kind(), strings.ToLower(v.Text()))
+	}
+	return strings.Join(key, "\x00")
+}
+
+// unique implements `UNIQUE(range)`, the rows of the range without the ones
+// repeating a previous row.
+func unique(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("expected UNIQUE(range)")
+	}
+
+	seen := make(map[string]bool)
+	var rows [][]Value
+	for _, row := range args[0].Rows() {
+		if key := rowKey(row); !seen[key] {
+			seen[key] = true
+			rows = append(rows, row)
+		}
+	}
+	return ArrayValue(rows), nil
+}
+
+// sortFunc implements `SORT(range, [column], [order])`, the rows of the range
+// sorted by one of its columns (the first one by default), in ascending
+// order (1, the default) or descending order (-1).
+func sortFunc(args ...Value) (Value, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return Value{}, fmt.Errorf("expected SORT(range, [column], [order])")
+	}
+	rows := append([][]Value(nil), args[0].Rows()...)
+
+	col := 1
+	var err error
+	if len(args) > 1 {
+		if col, err = positionArg(args[1], "column"); err != nil {
+			return Value{}, err
+		}
+	}
+	if col > len(rows[0]) {
+		return Value{}, fmt.Errorf("column %d out of bounds, the range has %d", col, len(rows[0]))
+	}
+	order := 1.0
+	if len(args) > 2 {
+		if order, err = args[2].Number(); err != nil {
+			return Value{}, err
+		}
+		if order != 1 && order != -1 {
+			return Value{}, fmt.Errorf("unknown order %g, expected 1 or -1", order)
+		}
+	}
+
+	sort.SliceStable(rows, func(a, b int) bool {
+		return float64(rows[a][col-1].compare(rows[b][col-1]))*order < 0
+	})
+	return ArrayValue(rows), nil
+}
+
+// filter implements `FILTER(range, include)`, the rows of the range for
+// which include, a column as tall as the range, is true.
+func filter(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected FILTER(range, include)")
+	}
+	rows := args[0].Rows()
+	include, err := vector(args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	if len(include) != len(rows) {
+		return Value{}, fmt.Errorf("include has %d values, expected one for each of the %d rows", len(include), len(rows))
+	}
+
+	var filtered [][]Value
+	for i, row := range rows {
+		ok, err := include[i].Bool()
+		if err != nil {
+			return Value{}, err
+		}
+		if ok {
+			filtered = append(filtered, row)
+		}
+	}
+	if len(filtered) == 0 {
+		return Value{}, fmt.Errorf("no rows left")
+	}
+	return ArrayValue(filtered), nil
+}
@@ -1,3 +1,5 @@
 module github.com/andreacoradi/minicel
 
-go 1.16
+go 1.18
+
+require github.com/lib/pq v1.10.9
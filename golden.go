@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// goldenCase is a sheet under testdata/ paired with the output it is
+// expected to render. Sheets that fail to evaluate expect the error message,
+// prefixed with "error: ", as their last line.
+type goldenCase struct {
+	Name       string
+	InputPath  string
+	OutputPath string
+}
+
+func loadGoldenCases(dir string) ([]goldenCase, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.mcl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []goldenCase
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".mcl")
+		cases = append(cases, goldenCase{
+			Name:       name,
+			InputPath:  input,
+			OutputPath: strings.TrimSuffix(input, ".mcl") + ".out",
+		})
+	}
+	return cases, nil
+}
+
+// run evaluates the case input and returns both the actual and the expected
+// output.
+func (gc goldenCase) run() (got, want string, err error) {
+	input, err := ioutil.ReadFile(gc.InputPath)
+	if err != nil {
+		return "", "", err
+	}
+	output, err := ioutil.ReadFile(gc.OutputPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return renderGolden(string(input)), string(output), nil
+}
+
+func renderGolden(input string) string {
+	var buf bytes.Buffer
+	if err := runSheet(&buf, input); err != nil {
+		fmt.Fprintf(&buf, "error: %v\n", err)
+	}
+	return buf.String()
+}
+
+// runGoldenTests runs every case inside dir, reporting the outcome of each to
+// w. It returns false if at least one of them failed.
+func runGoldenTests(w io.Writer, dir string) (bool, error) {
+	cases, err := loadGoldenCases(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(cases) == 0 {
+		return false, fmt.Errorf("no test cases found in %s", dir)
+	}
+
+	var failed int
+	for _, gc := range cases {
+		got, want, err := gc.run()
+		if err != nil {
+			return false, err
+		}
+
+		if got != want {
+			failed++
+			fmt.Fprintf(w, "FAIL %s\n", gc.Name)
+			fmt.Fprintf(w, "--- expected\n%s--- got\n%s", want, got)
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", gc.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(cases)-failed, failed)
+	return failed == 0, nil
+}
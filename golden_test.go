@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+var updateFlag = flag.Bool("update", false, "rewrite the expected output of golden files")
+
+func TestGolden(t *testing.T) {
+	cases, err := loadGoldenCases("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gc := range cases {
+		gc := gc
+		t.Run(gc.Name, func(t *testing.T) {
+			if *updateFlag {
+				input, err := ioutil.ReadFile(gc.InputPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(gc.OutputPath, []byte(renderGolden(string(input))), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, want, err := gc.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("output mismatch\n--- expected\n%s--- got\n%s", want, got)
+			}
+		})
+	}
+}
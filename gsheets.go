@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The gsheets command talks to the Google Sheets API v4 directly, signing in
+// with the JSON key of a service account. The spreadsheet must be shared with
+// the e-mail address of the service account.
+
+var sheetsAPI = "https://sheets.googleapis.com/v4/spreadsheets"
+
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gsheetsClient makes authenticated requests to the Sheets API.
+type gsheetsClient struct {
+	token string
+}
+
+func newGSheetsClient(credentials string) (*gsheetsClient, error) {
+	c, err := ioutil.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(c, &account); err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	assertion, err := account.assertion(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.PostForm(account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("couldn't sign in as %s: %s %s", account.ClientEmail, resp.Status, token.Error)
+	}
+	return &gsheetsClient{token: token.AccessToken}, nil
+}
+
+// assertion returns the signed JWT exchanged for an access token.
+func (account serviceAccount) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid credentials: missing private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("invalid credentials: expected an RSA private key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (c *gsheetsClient) do(method, url string, body interface{}, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// values returns the values inside rng, rendered as requested.
+func (c *gsheetsClient) values(id, rng, render string) ([][]interface{}, error) {
+	u := fmt.Sprintf("%s/%s/values/%s?valueRenderOption=%s", sheetsAPI, url.PathEscape(id), url.PathEscape(rng), render)
+	var result struct {
+		Values [][]interface{} `json:"values"`
+	}
+	if err := c.do(http.MethodGet, u, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Values, nil
+}
+
+// pullSheet converts the range of a spreadsheet to the source of a minicel
+// sheet. Formulas are kept when minicel understands them, while the others
+// are replaced by their value.
+func (c *gsheetsClient) pullSheet(id, rng string) (string, error) {
+	formulas, err := c.values(id, rng, "FORMULA")
+	if err != nil {
+		return "", err
+	}
+	values, err := c.values(id, rng, "FORMATTED_VALUE")
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for i, row := range formulas {
+		if len(row) > 26 {
+			return "", fmt.Errorf("row %d has more than 26 columns", i+1)
+		}
+
+		cells := make([]string, len(row))
+		for j, v := range row {
+			content := gsheetsValue(v)
+			formula := ""
+			if strings.HasPrefix(content, "=") {
+				if formula, err = renumberRows(content, -1); err == nil {
+					content = formula
+				} else if i < len(values) && j < len(values[i]) {
+					content = gsheetsValue(values[i][j])
+				}
+			}
+			if content != formula && !plainText(content) {
+				content = textFormula(content)
+			}
+			if strings.ContainsAny(content, "|\n") {
+				return "", fmt.Errorf("%s: %q can't be represented in a sheet", cellName(i, j), content)
+			}
+			cells[j] = content
+		}
+		sb.WriteString(strings.Join(cells, "|"))
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// plainText tells whether s is read back as itself when written as the
+// source of a cell, rather than as a formula, a command, a placeholder or
+// with a note, a format or a comment.
+func plainText(s string) bool {
+	if strings.HasPrefix(s, "=") || strings.HasPrefix(s, ":") || strings.HasPrefix(s, "!") || strings.Contains(s, "${") {
+		return false
+	}
+	part, _ := splitMeta(s)
+	return part == strings.TrimSpace(s)
+}
+
+// textFormula returns a formula giving the text s, for texts that would be
+// read as something else. The characters sheets give a meaning to are
+// escaped inside the string, so that placeholders and comments can't be
+// found in it.
+func textFormula(s string) string {
+	q := strconv.Quote(s)
+	var sb strings.Builder
+	sb.WriteString(`="`)
+	for k := 1; k < len(q)-1; k++ {
+		switch c := q[k]; {
+		case c == '\\' && q[k+1] == '"':
+			sb.WriteString(`\x22`)
+			k++
+		case c == '\\':
+			sb.WriteString(q[k : k+2])
+			k++
+		case strings.IndexByte("$|;{}@", c) >= 0:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// pushTable writes the evaluated table to the range of a spreadsheet.
+func (c *gsheetsClient) pushTable(id, rng string, table Table) error {
+	values := make([][]string, len(table))
+	for i, row := range table {
+		values[i] = make([]string, len(row))
+		for j, cell := range row {
+			values[i][j] = cell.Content
+			if cell.Type == Text && strings.HasPrefix(cell.Content, "=") {
+				// Values are parsed as if typed by a user, keep this one text
+				values[i][j] = "'" + cell.Content
+			}
+		}
+	}
+
+	u := fmt.Sprintf("%s/%s/values/%s?valueInputOption=USER_ENTERED", sheetsAPI, url.PathEscape(id), url.PathEscape(rng))
+	return c.do(http.MethodPut, u, map[string]interface{}{
+		"range":          rng,
+		"majorDimension": "ROWS",
+		"values":         values,
+	}, nil)
+}
+
+func gsheetsValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// renumberRows moves every cell reference inside the formula by the given
+// amount of rows, anchored or not. Spreadsheets count rows from 1, while
+// minicel counts them from 0.
+func renumberRows(formula string, rows int) (string, error) {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		return "", err
+	}
+
+	err = walkRefs(expr, func(ref cellRef) (cellRef, error) {
+		if ref.Row+rows < 0 {
+			return ref, fmt.Errorf("reference %s out of bounds", ref)
+		}
+		ref.Row += rows
+		return ref, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "=" + formatFormula(expr), nil
+}
+
+// gsheetsCommand implements the `gsheets` command.
+func gsheetsCommand(args []string) error {
+	const usage = "usage: minicel gsheets pull|push [-credentials key.json] [-range Sheet1] spreadsheet-id [sheet]"
+	if len(args) < 1 {
+		return fmt.Errorf(usage)
+	}
+
+	fs := flag.NewFlagSet("gsheets", flag.ExitOnError)
+	credentials := fs.String("credentials", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "JSON key of the service account")
+	rng := fs.String("range", "Sheet1", "range of the spreadsheet to read or write, in A1 notation")
+	fs.Parse(args[1:])
+
+	switch {
+	case args[0] == "pull" && fs.NArg() == 1:
+	case args[0] == "push" && fs.NArg() == 2:
+	default:
+		return fmt.Errorf(usage)
+	}
+	if *credentials == "" {
+		return fmt.Errorf("missing -credentials")
+	}
+
+	client, err := newGSheetsClient(*credentials)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "pull" {
+		source, err := client.pullSheet(fs.Arg(0), *rng)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(os.Stdout, source)
+		return err
+	}
+
+	c, err := readSheet(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	if err := evalTable(table); err != nil {
+		return err
+	}
+	return client.pushTable(fs.Arg(0), *rng, table)
+}
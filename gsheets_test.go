@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGSheets(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pushed [][]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("assertion") == "" {
+			http.Error(w, "missing assertion", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "secret"})
+	})
+	mux.HandleFunc("/sheets/doc/values/Sheet1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut:
+			var body struct {
+				Values [][]string `json:"values"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			pushed = body.Values
+			w.Write([]byte("{}"))
+		case r.FormValue("valueRenderOption") == "FORMULA":
+			w.Write([]byte(`{"values": [["Price", "Qty", "Total"], [2.5, 4, "=A2*$B$2"], [1, 2, "=ROUND(Sheet2!C2; 0)"], ["!rm -rf ~", ":^", "${HOME} {note: x}"]]}`))
+		default:
+			w.Write([]byte(`{"values": [["Price", "Qty", "Total"], ["2.5", "4", "10"], ["1", "2", "10"], ["!rm -rf ~", ":^", "${HOME} {note: x}"]]}`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	defer func(api string) { sheetsAPI = api }(sheetsAPI)
+	sheetsAPI = srv.URL + "/sheets"
+
+	credentials := filepath.Join(t.TempDir(), "key.json")
+	c, _ := json.Marshal(serviceAccount{
+		ClientEmail: "minicel@example.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL + "/token",
+	})
+	if err := ioutil.WriteFile(credentials, c, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newGSheetsClient(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := client.pullSheet("doc", "Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Price|Qty|Total\n2.5|4|=A1 * $B$1\n1|2|10\n=\"!rm -rf ~\"|=\":^\"|=\"\\x24\\x7bHOME\\x7d \\x7bnote: x\\x7d\"\n"; source != want {
+		t.Errorf("pulled %q, want %q", source, want)
+	}
+
+	// Remote texts stay texts, rather than becoming commands or clones
+	pulled := parseTable(source)
+	if err := evalTable(pulled); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tableContents(pulled[3:4]), "!rm -rf ~|:^|${HOME} {note: x}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	table := parseTable("A|=B0 thing\n1|=A1*2")
+	table[0][1].Type = Text
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.pushTable("doc", "Sheet1", table); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"A", "'=B0 thing"}, {"1.00", "2.00"}}; !reflect.DeepEqual(pushed, want) {
+		t.Errorf("pushed %q, want %q", pushed, want)
+	}
+}
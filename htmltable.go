@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+var htmlFlag = flag.Bool("html", false, "read sheets as HTML pages, taking the table picked by -nth")
+var nthVar = flag.Int("nth", 1, "which table of an HTML page, or worksheet of an Excel workbook, to read, counting from 1")
+
+// parseHTML extracts the -nth table of an HTML page, like one published on
+// the web. Merged cells span their columns, the ones spanning rows are only
+// kept in the first, and the tables inside cells only add their text to it.
+// Cells hold numbers or text, never formulas: a page can't run commands.
+func parseHTML(c string) (Table, error) {
+	if *nthVar < 1 {
+		return nil, fmt.Errorf("invalid -nth %d, tables are counted from 1", *nthVar)
+	}
+
+	var table Table
+	var text strings.Builder
+	tables, depth := 0, 0
+	inCell := false
+	span := 1
+	// below counts the rows each column is still taken by a cell above
+	var below []int
+
+	closeCell := func() {
+		if !inCell {
+			return
+		}
+		inCell = false
+		row := &table[len(table)-1]
+		content := strings.Join(strings.Fields(text.String()), " ")
+		cell := newCell(content, "")
+		if cell.Type != Empty && cell.Type != Number {
+			cell.Type = Text
+		}
+		if span > 1 {
+			cell.Span = span
+		}
+		*row = append(*row, cell)
+		for k := 1; k < span; k++ {
+			*row = append(*row, Cell{})
+		}
+		text.Reset()
+	}
+	// skipTaken leaves empty the columns taken by cells from the rows above
+	skipTaken := func() {
+		row := &table[len(table)-1]
+		for len(*row) < len(below) && below[len(*row)] > 0 {
+			below[len(*row)]--
+			*row = append(*row, Cell{})
+		}
+	}
+	// endRow also counts the row for the columns past its end
+	endRow := func() {
+		closeCell()
+		if len(table) == 0 {
+			return
+		}
+		skipTaken()
+		for j := len(table[len(table)-1]); j < len(below); j++ {
+			if below[j] > 0 {
+				below[j]--
+			}
+		}
+	}
+
+	for k := 0; k < len(c); {
+		if c[k] != '<' {
+			end := strings.IndexByte(c[k:], '<')
+			if end < 0 {
+				end = len(c) - k
+			}
+			if inCell {
+				text.WriteString(html.UnescapeString(c[k : k+end]))
+			}
+			k += end
+			continue
+		}
+		if strings.HasPrefix(c[k:], "<!--") {
+			end := strings.Index(c[k:], "-->")
+			if end < 0 {
+				break
+			}
+			k += end + len("-->")
+			continue
+		}
+		end := strings.IndexByte(c[k:], '>')
+		if end < 0 {
+			break
+		}
+		tag := c[k+1 : k+end]
+		k += end + 1
+
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.ToLower(strings.TrimPrefix(tag, "/"))
+		if i := strings.IndexAny(name, " \t\r\n/"); i >= 0 {
+			name = name[:i]
+		}
+
+		// Scripts and styles aren't text
+		if !closing && (name == "script" || name == "style") {
+			end := strings.Index(strings.ToLower(c[k:]), "</"+name)
+			if end < 0 {
+				break
+			}
+			k += end
+			continue
+		}
+
+		if name == "table" {
+			if !closing {
+				if depth == 0 {
+					tables++
+				}
+				depth++
+			} else if depth > 0 {
+				depth--
+				if depth == 0 && tables == *nthVar {
+					closeCell()
+					break
+				}
+			}
+			continue
+		}
+		if depth == 0 || tables != *nthVar {
+			continue
+		}
+		if depth > 1 {
+			if inCell && (name == "td" || name == "th" || name == "tr" || name == "br") {
+				text.WriteByte(' ')
+			}
+			continue
+		}
+
+		switch {
+		case name == "tr" && !closing:
+			endRow()
+			table = append(table, nil)
+		case (name == "td" || name == "th") && !closing:
+			closeCell()
+			if len(table) == 0 {
+				table = append(table, nil)
+			}
+			skipTaken()
+			inCell, span = true, htmlSpan(tag, "colspan", 1000)
+			rows := htmlSpan(tag, "rowspan", 65534)
+			for j := len(table[len(table)-1]); j < len(table[len(table)-1])+span; j++ {
+				for len(below) <= j {
+					below = append(below, 0)
+				}
+				below[j] = rows - 1
+			}
+		case (name == "td" || name == "th" || name == "tr") && closing:
+			closeCell()
+		case name == "br" && inCell:
+			text.WriteByte(' ')
+		}
+	}
+
+	closeCell()
+
+	if tables < *nthVar {
+		return nil, fmt.Errorf("found %d tables, can't read table %d", tables, *nthVar)
+	}
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+// htmlSpan returns the number in the colspan or rowspan attribute of the
+// tag, 1 when it's missing, and at most max like browsers do.
+func htmlSpan(tag, attr string, max int) int {
+	lower := strings.ToLower(tag)
+	k := strings.Index(lower, attr)
+	if k < 0 {
+		return 1
+	}
+	value := strings.TrimLeft(lower[k+len(attr):], " ")
+	if !strings.HasPrefix(value, "=") {
+		return 1
+	}
+	value = strings.Trim(strings.TrimLeft(value[1:], " "), `"'`)
+	if end := strings.IndexAny(value, `"' `); end >= 0 {
+		value = value[:end]
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 1
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
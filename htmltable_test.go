@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestParseHTML(t *testing.T) {
+	const page = `<html><head><script>var s = "<table>";</script></head><body>
+<table><tr><td>Menu</td></tr></table>
+<!-- <table> -->
+<table>
+  <thead><tr><th>Region</th><th colspan="2">Sales &amp; costs</th></tr></thead>
+  <tr><td rowspan=2>North</td><td>10</td><td>=1+1</td>
+  <tr><td>20<td>!rm -rf /
+  <tr><td>South</td><td>5<br>k</td><td><table><tr><td>in</td><td>ner</td></tr></table></td></tr>
+</table>`
+
+	defer func() { *nthVar = 1 }()
+	tests := []struct {
+		nth  int
+		want string
+	}{
+		{1, "Menu"},
+		{2, "Region|Sales & costs|\nNorth|10.00|=1+1\n|20.00|!rm -rf /\nSouth|5 k|in ner"},
+		{3, "found 2 tables, can't read table 3"},
+	}
+	for _, tt := range tests {
+		*nthVar = tt.nth
+		table, err := parseHTML(page)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = tableContents(table)
+		}
+		if got != tt.want {
+			t.Errorf("-nth %d: got %q, want %q", tt.nth, got, tt.want)
+		}
+	}
+
+	// Pages can't add formulas or commands
+	*nthVar = 2
+	table, err := parseHTML(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table[1][2].Type != Text || table[2][2].Type != Text || table[0][1].Span != 2 {
+		t.Errorf("got %+v", table)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+// catchesErrors are the functions getting the arguments that failed to
+// evaluate as error values, instead of failing with them.
+var catchesErrors = map[string]bool{
+	"ISERROR": true,
+	"IFERROR": true,
+}
+
+func init() {
+	RegisterFunc("ISNUMBER", isFunc("ISNUMBER", func(v Value) bool { return v.Kind() == NumberKind }))
+	RegisterFunc("ISTEXT", isFunc("ISTEXT", func(v Value) bool { return v.Kind() == TextKind }))
+	RegisterFunc("ISBLANK", isFunc("ISBLANK", func(v Value) bool { return v.Kind() == EmptyKind }))
+	RegisterFunc("ISERROR", isFunc("ISERROR", func(v Value) bool { return v.Kind() == ErrorKind }))
+	RegisterFunc("IFERROR", iferror)
+}
+
+// isFunc returns a function telling whether its single argument is of some
+// kind.
+func isFunc(name string, is func(v Value) bool) Func {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("expected %s(value)", name)
+		}
+		if args[0].Kind() == ArrayKind {
+			return Value{}, fmt.Errorf("expected a single value, got a range")
+		}
+		return BoolValue(is(args[0])), nil
+	}
+}
+
+// iferror implements `IFERROR(value, fallback)`, fallback when value is an
+// error and value otherwise.
+func iferror(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected IFERROR(value, fallback)")
+	}
+	if args[0].Kind() == ErrorKind {
+		return args[1], nil
+	}
+	return args[0], nil
+}
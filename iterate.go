@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strconv"
+)
+
+var iterativeFlag = flag.Bool("iterative", false, "allow formulas to depend on the ones evaluated after them, like circular references, evaluating the table again until it converges")
+var maxIterationsVar = flag.Int("max-iterations", 100, "maximum number of times -iterative evaluates the table")
+var epsilonVar = flag.Float64("epsilon", 0.001, "largest change of a number between two evaluations for -iterative to stop")
+
+// evalIterative evaluates the table over and over, like spreadsheets do
+// with iterative calculation, so that formulas can depend on the ones
+// evaluated after them, even circularly, like a model converging to a goal.
+// Every evaluation goes through the formulas in order, with the ones not
+// evaluated yet holding the value they got the time before, starting out
+// empty. It stops once no number changes by more than -epsilon and no other
+// value changes at all, or after -max-iterations evaluations, returning the
+// errors of the last one.
+func evalIterative(table Table) error {
+	source := table.copy()
+	var prev Table
+	for n := 0; n < *maxIterationsVar; n++ {
+		next := source.copy()
+		for i, row := range source {
+			for j, cell := range row {
+				if cell.Type == Expression {
+					next[i][j] = Cell{}
+					if prev != nil {
+						next[i][j] = prev[i][j]
+					}
+				}
+			}
+		}
+
+		var errs evalErrors
+		for i, row := range source {
+			for j, cell := range row {
+				// Formulas can reference their own value of the time before
+				var err error
+				if cell.Type == Expression {
+					var value Value
+					if value, err = evalFormula(next, i, j, cell.Content[1:]); err == nil {
+						next[i][j] = cell
+						err = setValue(next, i, j, value)
+					}
+				}
+				if err := settleCell(next, i, j, cell, err); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+
+		converged := prev != nil && !changed(source, prev, next)
+		copy(table, next)
+		prev = next
+		if converged || n == *maxIterationsVar-1 {
+			if len(errs) > 0 {
+				return errs
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// changed returns whether the value of a formula of the source changed
+// between two evaluations, by more than -epsilon for numbers.
+func changed(source, prev, next Table) bool {
+	for i, row := range source {
+		for j, cell := range row {
+			if cell.Type != Expression {
+				continue
+			}
+			a, b := prev[i][j], next[i][j]
+			if a.Type == Number && b.Type == Number {
+				x, errX := strconv.ParseFloat(a.Content, 64)
+				y, errY := strconv.ParseFloat(b.Content, 64)
+				if errX == nil && errY == nil {
+					if math.Abs(x-y) > *epsilonVar {
+						return true
+					}
+					continue
+				}
+			}
+			if a.Content != b.Content {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIterative(t *testing.T) {
+	defer func(iterative bool, max int, epsilon float64) {
+		*iterativeFlag, *maxIterationsVar, *epsilonVar = iterative, max, epsilon
+	}(*iterativeFlag, *maxIterationsVar, *epsilonVar)
+
+	tests := []struct {
+		source     string
+		max        int
+		epsilon    float64
+		want       string
+		wantErrors []string
+	}{
+		// The bonus is a tenth of the profit after the bonus
+		{"Revenue|1000\nBonus|=B2*0.1\nProfit|=B0-B1", 100, 0.001, "Revenue|1000.00\nBonus|90.91\nProfit|909.09", nil},
+		// Counting up, stopped by -max-iterations or by -epsilon
+		{"Count|=B0+1", 5, 0.001, "Count|5.00", nil},
+		{"Count|=B0+1", 5, 1, "Count|2.00", nil},
+		// Only the errors of the last evaluation are returned
+		{"A|=B0*2|=A0+1|=X", 100, 0.001, "A|0.00|#ERROR|#ERROR", []string{"C0: ", "D0: "}},
+	}
+	for _, tt := range tests {
+		*iterativeFlag, *maxIterationsVar, *epsilonVar = true, tt.max, tt.epsilon
+		table := parseTable(tt.source)
+		err := evalTable(table)
+		var got []string
+		for _, row := range table {
+			var cells []string
+			for _, cell := range row {
+				cells = append(cells, cell.Content)
+			}
+			got = append(got, strings.Join(cells, "|"))
+		}
+		if strings.Join(got, "\n") != tt.want {
+			t.Errorf("%q evaluated to %q, want %q", tt.source, strings.Join(got, "\n"), tt.want)
+		}
+		errs, _ := err.(evalErrors)
+		if len(errs) != len(tt.wantErrors) || (err != nil && len(errs) == 0) {
+			t.Errorf("%q failed with %v, want %d errors", tt.source, err, len(tt.wantErrors))
+			continue
+		}
+		for k, want := range tt.wantErrors {
+			if !strings.HasPrefix(errs[k].Error(), want) {
+				t.Errorf("%q failed with %v, want %s", tt.source, errs[k], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var maxRowsVar = flag.Int("max-rows", 1<<20, "fail on sheets with more rows than this, 0 for no limit")
+var maxColsVar = flag.Int("max-cols", 26, "fail on sheets with more columns than this, 0 for no limit (formulas can only refer to columns A to Z)")
+var maxCellsVar = flag.Int("max-cells", 10_000_000, "fail on sheets with more cells than this, 0 for no limit")
+
+// checkLimits makes sure that the source content of a sheet is within
+// -max-rows, -max-cols and -max-cells before it is parsed, so that enormous
+// inputs fail right away instead of exhausting memory.
+func checkLimits(content string) error {
+	rows, cols, cells := 1, 1, 1
+	rowCells := 1
+	for k := 0; k < len(content); k++ {
+		switch content[k] {
+		case '\n':
+			rows, rowCells = rows+1, 1
+			cells++
+		case '|':
+			rowCells++
+			cells++
+			if rowCells > cols {
+				cols = rowCells
+			}
+		default:
+			continue
+		}
+		if err := checkSize(rows, cols, cells); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTableLimits is like checkLimits, for tables that have grown after
+// being parsed.
+func checkTableLimits(table Table) error {
+	cols, cells := 0, 0
+	for _, row := range table {
+		if len(row) > cols {
+			cols = len(row)
+		}
+		cells += len(row)
+	}
+	return checkSize(len(table), cols, cells)
+}
+
+func checkSize(rows, cols, cells int) error {
+	switch {
+	case *maxRowsVar > 0 && rows > *maxRowsVar:
+		return fmt.Errorf("the sheet has more than %d rows, raise -max-rows to allow it", *maxRowsVar)
+	case *maxColsVar > 0 && cols > *maxColsVar:
+		return fmt.Errorf("the sheet has more than %d columns, raise -max-cols to allow it", *maxColsVar)
+	case *maxCellsVar > 0 && cells > *maxCellsVar:
+		return fmt.Errorf("the sheet has more than %d cells, raise -max-cells to allow it", *maxCellsVar)
+	}
+	return nil
+}
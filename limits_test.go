@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	defer func(rows, cols, cells int) {
+		*maxRowsVar, *maxColsVar, *maxCellsVar = rows, cols, cells
+	}(*maxRowsVar, *maxColsVar, *maxCellsVar)
+	*maxRowsVar, *maxColsVar, *maxCellsVar = 3, 2, 5
+
+	tests := []struct {
+		sheet string
+		want  string
+	}{
+		{"1|2\n3|4\n5", ""},
+		{"1\n2\n3\n4", "the sheet has more than 3 rows, raise -max-rows to allow it"},
+		{"1|2|3", "the sheet has more than 2 columns, raise -max-cols to allow it"},
+		{"1|2\n3|4\n5|6", "the sheet has more than 5 cells, raise -max-cells to allow it"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		err := runSheet(&out, tt.sheet)
+		if tt.want == "" && err != nil {
+			t.Errorf("%q: %v", tt.sheet, err)
+		} else if tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%q: got error %v, want %s", tt.sheet, err, tt.want)
+		}
+	}
+
+	*maxRowsVar = 0
+	if err := runSheet(&strings.Builder{}, "1\n2\n3\n4"); err != nil {
+		t.Errorf("got %v without a limit on rows", err)
+	}
+}
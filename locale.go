@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"time"
+)
+
+var outLocaleVar = flag.String("out-locale", "", "write numbers and dates following the conventions of a locale, like de-DE (see the README for the list)")
+
+// outLocale are the conventions of a locale for writing numbers and dates.
+type outLocale struct {
+	decimal, group string
+	date           string // Layout of dates, as in the time package
+}
+
+var outLocales = map[string]outLocale{
+	"en-US": {".", ",", "01/02/2006"},
+	"en-GB": {".", ",", "02/01/2006"},
+	"de-DE": {",", ".", "02.01.2006"},
+	"de-CH": {".", "'", "02.01.2006"},
+	"fr-FR": {",", " ", "02/01/2006"},
+	"it-IT": {",", ".", "02/01/2006"},
+	"es-ES": {",", ".", "02/01/2006"},
+	"nl-NL": {",", ".", "02-01-2006"},
+	"ja-JP": {".", ",", "2006/01/02"},
+}
+
+func outLocaleNames() string {
+	var names []string
+	for name := range outLocales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// localizeTable returns a copy of the evaluated table written with
+// -out-locale, or the table itself without it. It's only meant for
+// rendering: formulas must keep reading the cells as they are.
+func localizeTable(table Table) Table {
+	loc, ok := outLocales[*outLocaleVar]
+	if !ok {
+		return table
+	}
+	localized := table.copy()
+	for _, row := range localized {
+		for j, cell := range row {
+			row[j] = loc.cell(cell)
+		}
+	}
+	return localized
+}
+
+func (loc outLocale) cell(cell Cell) Cell {
+	switch cell.Type {
+	case Number:
+		cell.Content = loc.number(cell.Content)
+	case Text:
+		for _, layout := range dateLayouts {
+			date, err := time.Parse(layout, cell.Content)
+			if err != nil {
+				continue
+			}
+			if strings.Contains(layout, "15:04") {
+				cell.Content = date.Format(loc.date + " 15:04")
+			} else {
+				cell.Content = date.Format(loc.date)
+			}
+			break
+		}
+	}
+	return cell
+}
+
+// number writes a formatted number, like -1234.5 or 1.5e+06, with the
+// decimal and grouping separators of the locale.
+func (loc outLocale) number(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	exp := ""
+	if k := strings.IndexAny(s, "eE"); k >= 0 {
+		s, exp = s[:k], s[k:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" {
+		// NaN and infinities
+		return sign + s + exp
+	}
+
+	var sb strings.Builder
+	sb.WriteString(sign)
+	for k, c := range whole {
+		if k > 0 && (len(whole)-k)%3 == 0 {
+			sb.WriteString(loc.group)
+		}
+		sb.WriteRune(c)
+	}
+	if hasFrac {
+		sb.WriteString(loc.decimal)
+		sb.WriteString(frac)
+	}
+	sb.WriteString(exp)
+	return sb.String()
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestLocalizeTable(t *testing.T) {
+	defer func(loc string) { *outLocaleVar = loc }(*outLocaleVar)
+
+	table := parseTable("1234567.5|-1000|12|2024-03-01|2024-03-01 09:30|Total|=A0/0|NaN")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	for loc, want := range map[string]string{
+		"":      "1234567.50|-1000.00|12.00|2024-03-01|2024-03-01 09:30|Total|#DIV/0!|NaN",
+		"de-DE": "1.234.567,50|-1.000,00|12,00|01.03.2024|01.03.2024 09:30|Total|#DIV/0!|NaN",
+		"en-US": "1,234,567.50|-1,000.00|12.00|03/01/2024|03/01/2024 09:30|Total|#DIV/0!|NaN",
+	} {
+		*outLocaleVar = loc
+		if got := tableContents(localizeTable(table)); got != want {
+			t.Errorf("%s: got %q, want %q", loc, got, want)
+		}
+	}
+
+	// Only the rendered copy is localized
+	if got := table[0][0].Content; got != "1234567.50" {
+		t.Errorf("the table was changed to %q", got)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import "fmt"
+
+func init() {
+	RegisterFunc("AND", and)
+	RegisterFunc("OR", or)
+	RegisterFunc("NOT", not)
+	RegisterFunc("XOR", xor)
+	RegisterFunc("IF", ifFunc)
+	RegisterFunc("SWITCH", switchFunc)
+	RegisterFunc("CHOOSE", choose)
+}
+
+// picksArgs are the functions whose result is one of their arguments, which
+// is evaluated only once picked, like in other spreadsheets: =IF(A1=0, 0,
+// 1/A1) doesn't fail when A1 is 0, since 1/A1 isn't evaluated then.
+var picksArgs = map[string]argPicker{
+	"IF":     {key: firstArg, pick: pickIf},
+	"SWITCH": {key: switchKey, pick: pickSwitch},
+	"CHOOSE": {key: firstArg, pick: pickChoose},
+}
+
+// An argPicker tells which arguments of a function are always evaluated, the
+// keys, and which one of the others is picked given their values, or -1 if
+// none is. The arguments not picked are left empty.
+type argPicker struct {
+	key  func(k, n int) bool
+	pick func(args []Value) int
+}
+
+func firstArg(k, n int) bool { return k == 0 }
+
+// pickIf picks the branch of IF the condition chooses.
+func pickIf(args []Value) int {
+	if len(args) != 2 && len(args) != 3 {
+		return -1
+	}
+	if cond, err := args[0].Bool(); err != nil || (!cond && len(args) == 2) {
+		return -1
+	} else if !cond {
+		return 2
+	}
+	return 1
+}
+
+// switchKey tells whether the argument k of SWITCH is the value or a case,
+// rather than a result or the default.
+func switchKey(k, n int) bool { return k == 0 || (k%2 == 1 && k+1 < n) }
+
+// pickSwitch picks the result of the first case equal to the value, or the
+// default.
+func pickSwitch(args []Value) int {
+	if len(args) < 3 || args[0].Kind() == ArrayKind {
+		return -1
+	}
+	k := 1
+	for ; k+1 < len(args); k += 2 {
+		if args[0].compare(args[k]) == 0 {
+			return k + 1
+		}
+	}
+	if k < len(args) {
+		return k
+	}
+	return -1
+}
+
+// pickChoose picks the value at the index CHOOSE is given.
+func pickChoose(args []Value) int {
+	if len(args) < 2 {
+		return -1
+	}
+	if index, err := positionArg(args[0], "index"); err == nil && index < len(args) {
+		return index
+	}
+	return -1
+}
+
+// booleans flattens the arguments of a logical function into booleans. Like
+// numbers does, empty cells and texts inside ranges are skipped, while any
+// other argument must be a boolean.
+func booleans(args []Value) ([]bool, error) {
+	var bools []bool
+	for _, arg := range args {
+		if arg.Kind() != ArrayKind {
+			b, err := arg.Bool()
+			if err != nil {
+				return nil, err
+			}
+			bools = append(bools, b)
+			continue
+		}
+
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				switch v.Kind() {
+				case BoolKind, NumberKind:
+					bools = append(bools, v.num != 0)
+				case TextKind:
+					// Cells holding TRUE or FALSE are Text cells
+					if b, err := v.Bool(); err == nil {
+						bools = append(bools, b)
+					}
+				}
+			}
+		}
+	}
+	if len(bools) == 0 {
+		return nil, fmt.Errorf("expected at least a boolean")
+	}
+	return bools, nil
+}
+
+// and implements `AND(values...)`, whether every value is true.
+func and(args ...Value) (Value, error) {
+	bools, err := booleans(args)
+	if err != nil {
+		return Value{}, err
+	}
+	for _, b := range bools {
+		if !b {
+			return BoolValue(false), nil
+		}
+	}
+	return BoolValue(true), nil
+}
+
+// or implements `OR(values...)`, whether any value is true.
+func or(args ...Value) (Value, error) {
+	bools, err := booleans(args)
+	if err != nil {
+		return Value{}, err
+	}
+	for _, b := range bools {
+		if b {
+			return BoolValue(true), nil
+		}
+	}
+	return BoolValue(false), nil
+}
+
+// xor implements `XOR(values...)`, whether an odd number of values is true.
+func xor(args ...Value) (Value, error) {
+	bools, err := booleans(args)
+	if err != nil {
+		return Value{}, err
+	}
+	odd := false
+	for _, b := range bools {
+		odd = odd != b
+	}
+	return BoolValue(odd), nil
+}
+
+// not implements `NOT(value)`.
+func not(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("expected NOT(value)")
+	}
+	b, err := args[0].Bool()
+	if err != nil {
+		return Value{}, err
+	}
+	return BoolValue(!b), nil
+}
+
+// ifFunc implements `IF(condition, then, [else])`, where a missing else is
+// FALSE.
+func ifFunc(args ...Value) (Value, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return Value{}, fmt.Errorf("expected IF(condition, then, [else])")
+	}
+	cond, err := args[0].Bool()
+	if err != nil {
+		return Value{}, err
+	}
+	if cond {
+		return args[1], nil
+	}
+	if len(args) == 3 {
+		return args[2], nil
+	}
+	return BoolValue(false), nil
+}
+
+// switchFunc implements `SWITCH(value, case, result, ..., [default])`, the
+// result of the first case equal to value, or default when none is.
+func switchFunc(args ...Value) (Value, error) {
+	if len(args) < 3 {
+		return Value{}, fmt.Errorf("expected SWITCH(value, case, result, ..., [default])")
+	}
+	value := args[0]
+	if value.Kind() == ArrayKind {
+		return Value{}, fmt.Errorf("expected a single value, got a range")
+	}
+
+	cases := args[1:]
+	for len(cases) >= 2 {
+		if value.compare(cases[0]) == 0 {
+			return cases[1], nil
+		}
+		cases = cases[2:]
+	}
+	if len(cases) == 1 {
+		return cases[0], nil
+	}
+	return Value{}, fmt.Errorf("no case matches %q", value.Text())
+}
+
+// choose implements `CHOOSE(index, values...)`, the value at index counting
+// from 1.
+func choose(args ...Value) (Value, error) {
+	if len(args) < 2 {
+		return Value{}, fmt.Errorf("expected CHOOSE(index, values...)")
+	}
+	index, err := positionArg(args[0], "index")
+	if err != nil {
+		return Value{}, err
+	}
+	if index >= len(args) {
+		return Value{}, fmt.Errorf("index %d out of bounds, there are %d values", index, len(args)-1)
+	}
+	return args[index], nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	RegisterFunc("INDEX", indexFunc)
+	RegisterFunc("MATCH", matchFunc)
+	RegisterFunc("TRANSPOSE", transpose)
+	RegisterFunc("VLOOKUP", tableLookup("VLOOKUP", "column"))
+	RegisterFunc("HLOOKUP", tableLookup("HLOOKUP", "row"))
+}
+
+// positionArg returns an argument holding a position counted from 1.
+func positionArg(v Value, name string) (int, error) {
+	n, err := v.Number()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if n != math.Trunc(n) || n < 1 {
+		return 0, fmt.Errorf("%s must be a whole number greater than 0, got %g", name, n)
+	}
+	return int(n), nil
+}
+
+// vector returns the values of a range spanning a single row or column.
+func vector(v Value) ([]Value, error) {
+	rows := v.Rows()
+	if len(rows) == 1 {
+		return rows[0], nil
+	}
+	if len(rows[0]) != 1 {
+		return nil, fmt.Errorf("expected a single row or column, got %dx%d cells", len(rows), len(rows[0]))
+	}
+
+	values := make([]Value, len(rows))
+	for i, row := range rows {
+		values[i] = row[0]
+	}
+	return values, nil
+}
+
+// indexFunc implements `INDEX(range, row, [column])`. A range spanning a single
+// row or column only needs one position.
+func indexFunc(args ...Value) (Value, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return Value{}, fmt.Errorf("expected INDEX(range, row, [column])")
+	}
+
+	rows := args[0].Rows()
+	var row, col int
+	var err error
+	if len(args) == 2 {
+		values, err := vector(args[0])
+		if err != nil {
+			return Value{}, fmt.Errorf("%w, use INDEX(range, row, column)", err)
+		}
+		pos, err := positionArg(args[1], "position")
+		if err != nil {
+			return Value{}, err
+		}
+		if pos > len(values) {
+			return Value{}, fmt.Errorf("position %d out of range, which has %d cells", pos, len(values))
+		}
+		return values[pos-1], nil
+	}
+
+	if row, err = positionArg(args[1], "row"); err != nil {
+		return Value{}, err
+	}
+	if col, err = positionArg(args[2], "column"); err != nil {
+		return Value{}, err
+	}
+	if row > len(rows) || col > len(rows[0]) {
+		return Value{}, fmt.Errorf("cell %d, %d out of range, which has %dx%d cells", row, col, len(rows), len(rows[0]))
+	}
+	return rows[row-1][col-1], nil
+}
+
+// matchFunc implements `MATCH(value, range, [type])`, returning the
+// position of value inside range. Unlike most spreadsheets, the type defaults
+// to 0, an exact match, while 1 and -1 look for the largest value smaller or
+// equal to it, and the smallest value greater or equal to it, inside sorted
+// ranges.
+func matchFunc(args ...Value) (Value, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return Value{}, fmt.Errorf("expected MATCH(value, range, [type])")
+	}
+	values, err := vector(args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	matchType := 0.0
+	if len(args) == 3 {
+		if matchType, err = args[2].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+
+	found := -1
+	for i, v := range values {
+		c := v.compare(args[0])
+		if matchType == 0 && c == 0 {
+			found = i
+			break
+		}
+		// Sorted ranges can stop as soon as they go past the value
+		if (matchType > 0 && c > 0) || (matchType < 0 && c < 0) {
+			break
+		}
+		if matchType != 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Value{}, fmt.Errorf("%q not found", args[0].Text())
+	}
+	return NumberValue(float64(found + 1)), nil
+}
+
+// tableLookup returns `VLOOKUP(value, range, column, [sorted])`, the cell in
+// the given column of the row of range whose first cell is value, or
+// `HLOOKUP(value, range, row, [sorted])`, which looks along the first row
+// instead. Like in other spreadsheets, and unlike MATCH, sorted defaults to
+// TRUE, finding the last row whose first cell is smaller or equal to value,
+// as if range was sorted by it, while FALSE looks for an exact match.
+func tableLookup(name, along string) Func {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 3 && len(args) != 4 {
+			return Value{}, fmt.Errorf("expected %s(value, range, %s, [sorted])", name, along)
+		}
+		if args[0].Kind() == ArrayKind {
+			return Value{}, fmt.Errorf("expected a single value, got a range")
+		}
+		rows := args[1].Rows()
+		if along == "row" {
+			transposed, err := transpose(args[1])
+			if err != nil {
+				return Value{}, err
+			}
+			rows = transposed.Rows()
+		}
+		pos, err := positionArg(args[2], along)
+		if err != nil {
+			return Value{}, err
+		}
+		if pos > len(rows[0]) {
+			return Value{}, fmt.Errorf("%s %d out of range, which has %d", along, pos, len(rows[0]))
+		}
+		sorted := true
+		if len(args) == 4 {
+			if sorted, err = args[3].Bool(); err != nil {
+				return Value{}, err
+			}
+		}
+
+		found := -1
+		for i, row := range rows {
+			c := row[0].compare(args[0])
+			if !sorted && c == 0 {
+				found = i
+				break
+			}
+			if sorted && c > 0 {
+				break
+			}
+			if sorted {
+				found = i
+			}
+		}
+		if found < 0 {
+			return Value{}, fmt.Errorf("%q not found", args[0].Text())
+		}
+		return rows[found][pos-1], nil
+	}
+}
+
+// transpose implements `TRANSPOSE(range)`, the range with its rows turned
+// into columns.
+func transpose(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("expected TRANSPOSE(range)")
+	}
+	rows := args[0].Rows()
+
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	transposed := make([][]Value, cols)
+	for j := range transposed {
+		transposed[j] = make([]Value, len(rows))
+		for i, row := range rows {
+			if j < len(row) {
+				transposed[j][i] = row[j]
+			}
+		}
+	}
+	return ArrayValue(transposed), nil
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// The language server speaks just enough of the Language Server Protocol to
+// offer diagnostics, hovers, go-to-definition and the renaming of regions
+// for minicel sheets. Sheets are always synchronized in full.
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+var cellErrorRegexp = regexp.MustCompile(`^([A-Z]\d+): (.*)$`)
+
+// refTokenRegexp matches the references inside a line, in either case, as
+// its first group, and not the end of names like LOG10.
+var refTokenRegexp = regexp.MustCompile(`(?:^|[^A-Za-z0-9_$])(\$?[A-Za-z]\$?\d+)\b`)
+
+// regionNameRegexp matches the names regions can be given, like inside
+// regionRegexp.
+var regionNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// lspDocument is a sheet opened in the editor.
+type lspDocument struct {
+	text    string
+	lines   []string
+	offset  int // The lines defining regions, above the first row
+	regions map[string]region
+	values  Table
+	err     error
+}
+
+func newLSPDocument(text string) *lspDocument {
+	doc := &lspDocument{text: text, lines: strings.Split(normalizeNewlines(text), "\n")}
+
+	regions, offset, rest, err := splitRegions(text)
+	if err != nil {
+		doc.err = err
+		return doc
+	}
+	doc.offset, doc.regions = offset, regions
+
+	// Unlike the command line, keep every line so that rows match lines
+	table := parseTable(strings.TrimRight(rest, "\n"))
+	if err := resolveRegions(table, regions); err != nil {
+		doc.err = err
+		return doc
+	}
+	if err := resolveClones(table); err != nil {
+		doc.err = err
+		return doc
+	}
+	rules, err := splitRules(text)
+	if err == nil {
+		err = applyRules(table, rules)
+	}
+	if err != nil {
+		doc.err = err
+		return doc
+	}
+	// Cells that fail don't stop the others from being evaluated
+	source := table.copy()
+	doc.err = evalTable(table)
+	doc.values = table
+
+	// Formulas referring to the ones not evaluated yet might be in a cycle
+	if errs, ok := doc.err.(evalErrors); ok {
+		for k, err := range errs {
+			m := cellErrorRegexp.FindStringSubmatch(err.Error())
+			if m == nil || !errors.Is(err, errNotEvaluated) {
+				continue
+			}
+			if row, col, err := parseCellName(m[1]); err == nil {
+				if cycle := formulaCycle(source, row, col); cycle != nil {
+					errs[k] = fmt.Errorf("%s: circular reference %s", m[1], strings.Join(cycle, " → "))
+				}
+			}
+		}
+	}
+	return doc
+}
+
+// formulaCycle returns the cells of the shortest cycle of formulas going
+// through the cell at row i and column j, from it back to it, or nil if
+// there's none.
+func formulaCycle(table Table, i, j int) []string {
+	start := [2]int{i, j}
+	prev := map[[2]int][2]int{start: start}
+	queue := [][2]int{start}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		refs, err := formulaRefs(table, table[pos[0]][pos[1]].Content)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs {
+			next := [2]int{ref.Row, ref.Col}
+			if next == start {
+				cycle := []string{cellName(start[0], start[1])}
+				for ; pos != start; pos = prev[pos] {
+					cycle = append(cycle, cellName(pos[0], pos[1]))
+				}
+				cycle = append(cycle, cycle[0])
+				// The path was followed backwards
+				for a, b := 1, len(cycle)-2; a < b; a, b = a+1, b-1 {
+					cycle[a], cycle[b] = cycle[b], cycle[a]
+				}
+				return cycle
+			}
+			if _, seen := prev[next]; seen || next[0] < 0 || next[0] >= len(table) || next[1] < 0 || next[1] >= len(table[next[0]]) {
+				continue
+			}
+			if table[next[0]][next[1]].Type == Expression {
+				prev[next] = pos
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// cellRange returns the range spanned by the trimmed content of a cell.
+func (doc *lspDocument) cellRange(row, col int) (lspRange, bool) {
+	row += doc.offset
+	if row >= len(doc.lines) {
+		return lspRange{}, false
+	}
+
+	line := doc.lines[row]
+	start := 0
+	for j := 0; j < col; j++ {
+		i := strings.IndexByte(line[start:], '|')
+		if i < 0 {
+			return lspRange{}, false
+		}
+		start += i + 1
+	}
+	end := len(line)
+	if i := strings.IndexByte(line[start:], '|'); i >= 0 {
+		end = start + i
+	}
+
+	part := line[start:end]
+	start += len(part) - len(strings.TrimLeft(part, " \t"))
+	end -= len(part) - len(strings.TrimRight(part, " \t\r"))
+	if end < start {
+		end = start
+	}
+
+	return lspRange{
+		Start: lspPosition{row, utf16Len(line[:start])},
+		End:   lspPosition{row, utf16Len(line[:end])},
+	}, true
+}
+
+// cellAt returns the cell under the given position, along with the byte
+// offset of the position inside the line.
+func (doc *lspDocument) cellAt(pos lspPosition) (row, col, offset int, ok bool) {
+	if pos.Line < doc.offset || pos.Line >= len(doc.lines) {
+		return 0, 0, 0, false
+	}
+
+	line := doc.lines[pos.Line]
+	offset = utf16Offset(line, pos.Character)
+	return pos.Line - doc.offset, strings.Count(line[:offset], "|"), offset, true
+}
+
+func (doc *lspDocument) diagnostics() []lspDiagnostic {
+	diagnostics := []lspDiagnostic{}
+	if doc.err == nil {
+		return diagnostics
+	}
+
+	errs, ok := doc.err.(evalErrors)
+	if !ok {
+		errs = evalErrors{doc.err}
+	}
+
+	// Errors about a cell start with its name, anything else is reported at
+	// the start of the sheet
+	for _, err := range errs {
+		rng := lspRange{}
+		msg := err.Error()
+		if m := cellErrorRegexp.FindStringSubmatch(msg); m != nil {
+			if row, col, err := parseCellName(m[1]); err == nil {
+				if r, ok := doc.cellRange(row, col); ok {
+					rng = r
+				}
+			}
+		}
+
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    rng,
+			Severity: 1,
+			Source:   "minicel",
+			Message:  msg,
+		})
+	}
+	return diagnostics
+}
+
+func (doc *lspDocument) hover(pos lspPosition) interface{} {
+	row, col, _, ok := doc.cellAt(pos)
+	if !ok || doc.values == nil || row >= len(doc.values) || col >= len(doc.values[row]) {
+		return nil
+	}
+
+	cell := doc.values[row][col]
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": fmt.Sprintf("**%s** (%s): `%s`", cellName(row, col), cell.Type, cell.Content),
+		},
+	}
+}
+
+func (doc *lspDocument) definition(uri string, pos lspPosition) interface{} {
+	row, _, offset, ok := doc.cellAt(pos)
+	if !ok {
+		return nil
+	}
+
+	line := doc.lines[row+doc.offset]
+	for _, m := range refTokenRegexp.FindAllStringSubmatchIndex(line, -1) {
+		loc := m[2:4]
+		if offset < loc[0] || offset > loc[1] {
+			continue
+		}
+		ref, err := parseRef(line[loc[0]:loc[1]])
+		if err != nil {
+			return nil
+		}
+		if rng, ok := doc.cellRange(ref.Row, ref.Col); ok {
+			return lspLocation{URI: uri, Range: rng}
+		}
+	}
+	return nil
+}
+
+// rename returns the edits renaming the region under the given position,
+// where it's defined and inside every formula, to name.
+func (doc *lspDocument) rename(uri string, pos lspPosition, name string) (interface{}, error) {
+	if pos.Line >= len(doc.lines) {
+		return nil, fmt.Errorf("no region to rename here")
+	}
+	// The name under the position, which has to be one of the occurrences
+	line := doc.lines[pos.Line]
+	start, end := utf16Offset(line, pos.Character), utf16Offset(line, pos.Character)
+	for start > 0 && isIdentByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isIdentByte(line[end]) {
+		end++
+	}
+	old := line[start:end]
+
+	var edits []lspTextEdit
+	found := false
+	for _, occurrence := range doc.regionNames(old) {
+		edits = append(edits, lspTextEdit{Range: occurrence, NewText: name})
+		if occurrence.Start.Line == pos.Line && occurrence.Start.Character <= pos.Character && pos.Character <= occurrence.End.Character {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no region to rename here")
+	}
+
+	if !regionNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid region name %q", name)
+	}
+	if _, err := parseRef(name); err == nil {
+		return nil, fmt.Errorf("region %s would shadow a cell", name)
+	}
+	if _, ok := doc.regions[name]; ok && name != old {
+		return nil, fmt.Errorf("region %s is already defined", name)
+	}
+	return map[string]interface{}{
+		"changes": map[string][]lspTextEdit{uri: edits},
+	}, nil
+}
+
+// regionNames returns the ranges spanned by the name of the region, where
+// it's defined and where formulas use it, leaving out the calls to functions
+// with the same name and the headers inside brackets.
+func (doc *lspDocument) regionNames(name string) []lspRange {
+	if _, ok := doc.regions[name]; !ok {
+		return nil
+	}
+	span := func(line, start int) lspRange {
+		text := doc.lines[line]
+		return lspRange{
+			Start: lspPosition{line, utf16Len(text[:start])},
+			End:   lspPosition{line, utf16Len(text[:start+len(name)])},
+		}
+	}
+
+	var ranges []lspRange
+	for k, line := range doc.lines {
+		if k < doc.offset {
+			if m := regionRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil && m[1] == name {
+				ranges = append(ranges, span(k, strings.IndexByte(line, '@')+1))
+			}
+			continue
+		}
+		start := 0
+		for _, content := range strings.Split(line, "|") {
+			lead := len(content) - len(strings.TrimLeft(content, " \t"))
+			if part, _ := splitMeta(content); strings.HasPrefix(part, "=") {
+				for _, i := range formulaNames(part, name) {
+					ranges = append(ranges, span(k, start+lead+i))
+				}
+			}
+			start += len(content) + 1
+		}
+	}
+	return ranges
+}
+
+// formulaNames returns the offsets of name inside the formula, where it
+// stands on its own outside of strings and isn't the name of a function nor
+// a header inside brackets.
+func formulaNames(formula, name string) []int {
+	var offsets []int
+	for i := 0; i < len(formula); {
+		switch c := formula[i]; {
+		case c == '"':
+			// Quotes are escaped with backslashes, or doubled like in Excel,
+			// which amounts to a string ending right before another one
+			for i++; i < len(formula) && formula[i] != '"'; i++ {
+				if formula[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case isIdentByte(c):
+			j := i
+			for j < len(formula) && isIdentByte(formula[j]) {
+				j++
+			}
+			before := strings.TrimRight(formula[:i], " ")
+			after := strings.TrimLeft(formula[j:], " ")
+			if formula[i:j] == name && !strings.HasSuffix(before, "[") && !strings.HasSuffix(before, "$") && !strings.HasPrefix(after, "(") {
+				offsets = append(offsets, i)
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return offsets
+}
+
+// lspServer handles the messages of a single editor session.
+type lspServer struct {
+	w    io.Writer
+	docs map[string]*lspDocument
+}
+
+func (s *lspServer) send(msg lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *lspServer) publishDiagnostics(uri string) error {
+	params, _ := json.Marshal(map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": s.docs[uri].diagnostics(),
+	})
+	return s.send(lspMessage{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// handle processes a single message, returning false once the client asked
+// the server to exit.
+func (s *lspServer) handle(msg lspMessage) (bool, error) {
+	var result interface{}
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "minicel"},
+		}
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return true, err
+		}
+		s.docs[params.TextDocument.URI] = newLSPDocument(params.TextDocument.Text)
+		return true, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return true, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[params.TextDocument.URI] = newLSPDocument(params.ContentChanges[n-1].Text)
+			return true, s.publishDiagnostics(params.TextDocument.URI)
+		}
+		return true, nil
+	case "textDocument/didClose":
+		var params lspTextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return true, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return true, nil
+	case "textDocument/hover", "textDocument/definition":
+		var params lspTextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return true, err
+		}
+		if doc := s.docs[params.TextDocument.URI]; doc != nil {
+			if msg.Method == "textDocument/hover" {
+				result = doc.hover(params.Position)
+			} else {
+				result = doc.definition(params.TextDocument.URI, params.Position)
+			}
+		}
+	case "textDocument/rename":
+		var params struct {
+			lspTextDocumentPositionParams
+			NewName string `json:"newName"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return true, err
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			break
+		}
+		var err error
+		if result, err = doc.rename(params.TextDocument.URI, params.Position, params.NewName); err != nil {
+			// The request failed, but the session goes on
+			return true, s.send(lspMessage{ID: msg.ID, Error: &lspError{Code: -32803, Message: err.Error()}})
+		}
+	case "shutdown":
+	case "exit":
+		return false, nil
+	default:
+		if msg.ID == nil {
+			// Notifications we don't care about
+			return true, nil
+		}
+		return true, s.send(lspMessage{ID: msg.ID, Error: &lspError{Code: -32601, Message: "method not found: " + msg.Method}})
+	}
+
+	if msg.ID == nil {
+		return true, nil
+	}
+	if result == nil {
+		// The result must be present, even if null
+		return true, s.send(lspMessage{ID: msg.ID, Result: json.RawMessage("null")})
+	}
+	return true, s.send(lspMessage{ID: msg.ID, Result: result})
+}
+
+// runLSP serves a language server session over r and w until the client
+// exits.
+func runLSP(r io.Reader, w io.Writer) error {
+	s := &lspServer{w: w, docs: make(map[string]*lspDocument)}
+	br := bufio.NewReader(r)
+	for {
+		length := -1
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+				if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+					return fmt.Errorf("invalid header %q", line)
+				}
+			}
+		}
+		if length < 0 {
+			return fmt.Errorf("missing Content-Length header")
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return err
+		}
+
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		more, err := s.handle(msg)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// utf16Len returns the length of s in UTF-16 code units, which is how LSP
+// counts characters.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// utf16Offset converts a position in UTF-16 code units to a byte offset
+// inside s.
+func utf16Offset(s string, character int) int {
+	units := 0
+	for i, r := range s {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(s)
+}
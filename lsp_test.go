@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func lspRequest(id int, method string, params interface{}) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if id == 0 {
+		body, _ = json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  method,
+			"params":  params,
+		})
+	}
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func lspResponses(t *testing.T, output string) []lspMessage {
+	var msgs []lspMessage
+	for _, part := range strings.Split(output, "Content-Length: ")[1:] {
+		var msg lspMessage
+		body := part[strings.Index(part, "\r\n\r\n")+4:]
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestLSPSession(t *testing.T) {
+	const uri = "file:///sum.mcl"
+	doc := map[string]interface{}{"uri": uri}
+	input := lspRequest(1, "initialize", map[string]interface{}{}) +
+		lspRequest(0, "textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "text": "A | B\n1 | 2\n=C1+B1 | =A9"},
+		}) +
+		lspRequest(0, "textDocument/didChange", map[string]interface{}{
+			"textDocument":   doc,
+			"contentChanges": []map[string]string{{"text": "A | B\n1 | 2\n=A1+B1 | =B1"}},
+		}) +
+		lspRequest(2, "textDocument/hover", map[string]interface{}{
+			"textDocument": doc,
+			"position":     map[string]int{"line": 2, "character": 2},
+		}) +
+		lspRequest(3, "textDocument/definition", map[string]interface{}{
+			"textDocument": doc,
+			"position":     map[string]int{"line": 2, "character": 4},
+		}) +
+		lspRequest(4, "shutdown", nil) +
+		lspRequest(0, "exit", nil)
+
+	var out bytes.Buffer
+	if err := runLSP(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := lspResponses(t, out.String())
+	if len(msgs) != 6 {
+		t.Fatalf("got %d messages, want 6:\n%s", len(msgs), out.String())
+	}
+
+	var diagnostics struct {
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	json.Unmarshal(msgs[1].Params, &diagnostics)
+	// Every cell that fails is reported
+	if len(diagnostics.Diagnostics) != 2 || diagnostics.Diagnostics[0].Range.Start != (lspPosition{2, 0}) || diagnostics.Diagnostics[1].Range.Start != (lspPosition{2, 9}) {
+		t.Errorf("got diagnostics %+v", diagnostics.Diagnostics)
+	}
+	json.Unmarshal(msgs[2].Params, &diagnostics)
+	if len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("got diagnostics %+v after the fix", diagnostics.Diagnostics)
+	}
+
+	hover, _ := json.Marshal(msgs[3].Result)
+	if !strings.Contains(string(hover), "3.00") {
+		t.Errorf("got hover %s", hover)
+	}
+
+	var definition lspLocation
+	raw, _ := json.Marshal(msgs[4].Result)
+	json.Unmarshal(raw, &definition)
+	if want := (lspLocation{uri, lspRange{lspPosition{1, 4}, lspPosition{1, 5}}}); definition != want {
+		t.Errorf("got definition %+v, want %+v", definition, want)
+	}
+}
+
+func TestLSPCycles(t *testing.T) {
+	doc := newLSPDocument("A|B|C\n=B1|=a1+1|=C2\n=ABS(B1)|=b1*2|=3")
+	var msgs []string
+	for _, d := range doc.diagnostics() {
+		msgs = append(msgs, d.Message)
+	}
+	want := []string{
+		"A1: circular reference A1 → B1 → A1",
+		"C1: C2 isn't evaluated yet, formulas can only refer to the ones above them or to their left, without cycles",
+	}
+	if strings.Join(msgs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics %q, want %q", msgs, want)
+	}
+
+	// References can be written lowercase, unlike names of functions
+	for _, tt := range []struct {
+		pos  lspPosition
+		want interface{}
+	}{
+		{lspPosition{1, 5}, lspLocation{"file:///s.mcl", lspRange{lspPosition{1, 0}, lspPosition{1, 3}}}},
+		{lspPosition{2, 10}, lspLocation{"file:///s.mcl", lspRange{lspPosition{1, 4}, lspPosition{1, 9}}}},
+		{lspPosition{2, 4}, nil},
+	} {
+		if got := doc.definition("file:///s.mcl", tt.pos); got != tt.want {
+			t.Errorf("definition at %+v = %+v, want %+v", tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestLSPRename(t *testing.T) {
+	const uri = "file:///sales.mcl"
+	doc := newLSPDocument("@sales = A0:B2\n  @other=A0:A2\nName|Qty\npens|2\nink|3\n=SUM(sales[Qty]) {note: sales}|=COUNT( sales ) + sales(1)\n\"sales\"|=LEN(\"sales[Qty]\")&sales[sales]")
+
+	edit := func(line, start int) lspTextEdit {
+		return lspTextEdit{lspRange{lspPosition{line, start}, lspPosition{line, start + 5}}, "deals"}
+	}
+	want := []lspTextEdit{edit(0, 1), edit(5, 5), edit(5, 39), edit(6, 27)}
+	for _, pos := range []lspPosition{{0, 3}, {5, 5}, {5, 44}} {
+		result, err := doc.rename(uri, pos, "deals")
+		if err != nil {
+			t.Fatalf("renaming at %+v: %v", pos, err)
+		}
+		got := result.(map[string]interface{})["changes"].(map[string][]lspTextEdit)[uri]
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("renaming at %+v: got edits %+v, want %+v", pos, got, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		pos  lspPosition
+		name string
+		want string
+	}{
+		{lspPosition{3, 1}, "deals", "no region to rename here"},
+		{lspPosition{5, 55}, "deals", "no region to rename here"},
+		{lspPosition{0, 3}, "other", "region other is already defined"},
+		{lspPosition{0, 3}, "B2", "region B2 would shadow a cell"},
+		{lspPosition{0, 3}, "2x", `invalid region name "2x"`},
+	} {
+		if _, err := doc.rename(uri, tt.pos, tt.name); err == nil || err.Error() != tt.want {
+			t.Errorf("renaming at %+v to %s: got error %v, want %q", tt.pos, tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// mergeMarker is the content of the cells merged with the one to their
+// left, like the two after Sales in `Sales | << | << | Costs`, so that it
+// spans them all when rendered.
+const mergeMarker = "<<"
+
+// mergeCells empties the cells holding mergeMarker, widening the span of
+// the first cell to their left that doesn't hold it.
+func mergeCells(table Table) error {
+	for i, row := range table {
+		lead := -1
+		for j, cell := range row {
+			if cell.Type != Text || cell.Content != mergeMarker {
+				lead = j
+				continue
+			}
+			if lead < 0 {
+				return fmt.Errorf("%s: no cell to the left to merge with", cellName(i, j))
+			}
+			if row[lead].Span == 0 {
+				row[lead].Span = 1
+			}
+			row[lead].Span++
+			row[j] = Cell{}
+		}
+	}
+	return nil
+}
+
+// span returns how many columns the cell spans, at least 1.
+func (c Cell) span() int {
+	if c.Span < 1 {
+		return 1
+	}
+	return c.Span
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestMergeCells(t *testing.T) {
+	table := parseTable("Sales|<<|<<|Costs|<<\nx|y")
+	if err := mergeCells(table); err != nil {
+		t.Fatal(err)
+	}
+	for j, want := range []int{3, 0, 0, 2, 0} {
+		if got := table[0][j].Span; got != want {
+			t.Errorf("span of %s = %d, want %d", cellName(0, j), got, want)
+		}
+	}
+	if table[0][1] != (Cell{}) {
+		t.Errorf("merged cell B0 = %+v, want it empty", table[0][1])
+	}
+
+	if err := mergeCells(parseTable("A|B\n<<|x")); err == nil || err.Error() != "A1: no cell to the left to merge with" {
+		t.Errorf("got error %v", err)
+	}
+}
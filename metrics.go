@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// recalcBuckets are the upper bounds, in seconds, of the buckets of the
+// recalculation latency histogram.
+var recalcBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// serverMetrics collects what happens to the sheets of the server, exposed
+// at /metrics in the Prometheus text format.
+var serverMetrics = &metrics{buckets: make([]uint64, len(recalcBuckets))}
+
+type metrics struct {
+	mu             sync.Mutex
+	recalcs        uint64
+	evaluatedCells uint64
+	errors         uint64
+	buckets        []uint64
+	durationSum    float64
+}
+
+func (m *metrics) observeRecalc(d time.Duration, cells int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recalcs++
+	m.evaluatedCells += uint64(cells)
+	if err != nil {
+		m.errors++
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range recalcBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.durationSum += seconds
+}
+
+// write writes the metrics, along with the number of non-empty cells of each
+// sheet.
+func (m *metrics) write(w io.Writer, cells map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP minicel_recalculations_total Number of times a sheet has been evaluated.")
+	fmt.Fprintln(w, "# TYPE minicel_recalculations_total counter")
+	fmt.Fprintln(w, "minicel_recalculations_total", m.recalcs)
+
+	fmt.Fprintln(w, "# HELP minicel_evaluated_cells_total Number of formulas evaluated.")
+	fmt.Fprintln(w, "# TYPE minicel_evaluated_cells_total counter")
+	fmt.Fprintln(w, "minicel_evaluated_cells_total", m.evaluatedCells)
+
+	fmt.Fprintln(w, "# HELP minicel_errors_total Number of evaluations that ended with an error.")
+	fmt.Fprintln(w, "# TYPE minicel_errors_total counter")
+	fmt.Fprintln(w, "minicel_errors_total", m.errors)
+
+	fmt.Fprintln(w, "# HELP minicel_recalculation_duration_seconds Time spent evaluating a sheet.")
+	fmt.Fprintln(w, "# TYPE minicel_recalculation_duration_seconds histogram")
+	for i, bound := range recalcBuckets {
+		fmt.Fprintf(w, "minicel_recalculation_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "minicel_recalculation_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.recalcs)
+	fmt.Fprintln(w, "minicel_recalculation_duration_seconds_sum", strconv.FormatFloat(m.durationSum, 'g', -1, 64))
+	fmt.Fprintln(w, "minicel_recalculation_duration_seconds_count", m.recalcs)
+
+	names := make([]string, 0, len(cells))
+	for name := range cells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP minicel_cells Number of non-empty cells of a sheet.")
+	fmt.Fprintln(w, "# TYPE minicel_cells gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "minicel_cells{sheet=%q} %d\n", name, cells[name])
+	}
+}
+
+func (ws *workspace) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ws.mu.RLock()
+	cells := make(map[string]int, len(ws.sheets))
+	for name, s := range ws.sheets {
+		s.mu.Lock()
+		for _, row := range s.source {
+			for _, cell := range row {
+				if cell.Type != Empty {
+					cells[name]++
+				}
+			}
+		}
+		s.mu.Unlock()
+		if _, ok := cells[name]; !ok {
+			cells[name] = 0
+		}
+	}
+	ws.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	serverMetrics.write(w, cells)
+}
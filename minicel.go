@@ -0,0 +1,1042 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"math"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Cell struct {
+	Content string   `json:"content"`
+	Type    CellType `json:"type"`
+	Note    string   `json:"note,omitempty"`
+	Link    string   `json:"link,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	Span    int      `json:"span,omitempty"`
+	Invalid string   `json:"invalid,omitempty"`
+
+	rule *rule
+	// spilled are the rows and columns of the range a formula spilled,
+	// starting from its own cell
+	spilled [2]int
+}
+
+type CellType int
+
+//go:generate stringer -type=CellType
+const (
+	Empty CellType = iota
+	Text
+	Number
+	Expression
+	Clone
+	Command
+)
+
+func (t CellType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *CellType) UnmarshalText(text []byte) error {
+	for i := 0; i < len(_CellType_index)-1; i++ {
+		if CellType(i).String() == string(text) {
+			*t = CellType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid cell type %q", text)
+}
+
+type Table [][]Cell
+
+var debugFlag = flag.Bool("dbg", false, "enable intermediate representation and other debug infos")
+var prettyPrintFlag = flag.Bool("pp", false, "pretty prints the cells with padding in-between")
+var alignmentVar = flag.String("algn", "left", "set one of three valid alignments for cells (left, center, right)")
+var colorFlag = flag.Bool("color", false, "style the cells of the plain output with their @style rules, and the ones breaking validation rules in red")
+var numberFormatVar = flag.String("fmt", "%.2f", "printf-like formatting for floating point numbers inside cells")
+var nanVar = flag.String("nan", "error", "how NaN and infinite results are written: error (as #NUM!) or literal (as NaN, +Inf and -Inf)")
+var strictFlag = flag.Bool("strict", false, "fail on empty cells used as numbers, booleans used as numbers and the other way around, rows of different lengths and numbers losing digits when formatted")
+var streamFlag = flag.Bool("stream", false, "print every row as a JSON object as soon as it's evaluated")
+var templateVar = flag.String("template", "", "render the evaluated table through a Go text/template file instead")
+var allowDBFlag = flag.Bool("allow-db", false, "allow DBQUERY cells to query databases")
+var allowNetFlag = flag.Bool("allow-net", false, "allow FETCH cells to make HTTP requests")
+var allowExecFlag = flag.Bool("allow-exec", false, "allow !command cells to run shell commands")
+var fetchTimeoutFlag = flag.Duration("fetch-timeout", 10*time.Second, "timeout of the HTTP requests made by FETCH cells")
+
+// loadSheet parses the sheet source content and gets it ready to be
+// evaluated, running queries and commands and resolving clones.
+func loadSheet(c string) (Table, error) {
+	if *csvFlag {
+		table, err := loadCSV(c)
+		if err != nil {
+			return nil, err
+		}
+		return table, applyRules(table, nil)
+	}
+	if *htmlFlag {
+		table, err := parseHTML(c)
+		if err != nil {
+			return nil, err
+		}
+		return table, applyRules(table, nil)
+	}
+	if *yamlFlag {
+		table, err := parseYAML(c)
+		if err != nil {
+			return nil, err
+		}
+		return table, applyRules(table, nil)
+	}
+	if *xlsxFlag {
+		table, err := parseXLSX(c)
+		if err != nil {
+			return nil, err
+		}
+		return table, applyRules(table, nil)
+	}
+
+	regions, _, content, err := splitRegions(strings.TrimSpace(c))
+	if err != nil {
+		return nil, err
+	}
+	rules, err := splitRules(strings.TrimSpace(c))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimits(content); err != nil {
+		return nil, err
+	}
+	if *strictFlag {
+		if err := checkStrict(content); err != nil {
+			return nil, err
+		}
+	}
+	table := parseTable(content)
+	if err := resolveRegions(table, regions); err != nil {
+		return nil, err
+	}
+	if table, err = prepareTable(table); err != nil {
+		return nil, err
+	}
+	return table, applyRules(table, rules)
+}
+
+// loadCSV is like loadSheet, for CSV files.
+func loadCSV(c string) (Table, error) {
+	dialect, err := flagDialect()
+	if err != nil {
+		return nil, err
+	}
+	return dialect.load(c)
+}
+
+// prepareTable runs the queries and commands of a table parsed from a local
+// file and resolves its clones.
+func prepareTable(table Table) (Table, error) {
+	table, err := preloadTable(table, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveClones(table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// runSheet parses, evaluates and renders the sheet source content to w.
+func runSheet(w io.Writer, c string) error {
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+
+	if *debugFlag {
+		dumpTable(w, table)
+		fmt.Fprintln(w, strings.Repeat("-", 80))
+	}
+
+	if *streamFlag {
+		if *iterativeFlag {
+			return fmt.Errorf("-stream can't be used with -iterative, which evaluates every row more than once")
+		}
+		return streamTable(w, table)
+	}
+	if *streamTextFlag {
+		if *iterativeFlag {
+			return fmt.Errorf("-stream-text can't be used with -iterative, which evaluates every row more than once")
+		}
+		return streamText(w, table)
+	}
+
+	// Cells that fail are rendered as #ERROR, and their errors returned after
+	evalErr := evalTable(table)
+	table = localizeTable(table)
+	if *templateVar != "" {
+		if err := renderTemplate(w, table, *templateVar); err != nil {
+			return err
+		}
+		return evalErr
+	}
+	dumpTable(w, table)
+	return evalErr
+}
+
+// evalErrors are the errors of the cells that failed to evaluate, in the
+// order they were found.
+type evalErrors []error
+
+func (errs evalErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for k, err := range errs {
+		msgs[k] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// evalTable evaluates every row of the table, carrying on past the cells
+// that fail, which it returns as evalErrors.
+func evalTable(table Table) error {
+	return evalTableWith(table, nil)
+}
+
+// evalTableWith evaluates the table like evalTable, taking the value of the
+// formulas from the cache when they're there already, and keeping the new
+// ones there. A nil cache evaluates every formula, and so does -iterative.
+func evalTableWith(table Table, cache *recalcCache) error {
+	if *iterativeFlag {
+		return evalIterative(table)
+	}
+	cache.begin()
+	var errs evalErrors
+	for i := range table {
+		if err := evalRow(table, i, cache); err != nil {
+			errs = append(errs, err.(evalErrors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// evalRow evaluates a single row of the table. Rows are evaluated in order,
+// so its formulas can only depend on the rows above it. Cells that fail are
+// replaced by #ERROR, which propagates to the cells using them, and their
+// errors are returned as evalErrors once the whole row is evaluated.
+func evalRow(table Table, i int, cache *recalcCache) error {
+	var errs evalErrors
+	for j := range table[i] {
+		if err := evalAt(table, i, j, cache); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// evalAt evaluates the cell at row i and column j for evalRow, replacing it
+// with #ERROR when it fails and checking it against its rules otherwise.
+// The error returned is prefixed with the name of the cell.
+func evalAt(table Table, i, j int, cache *recalcCache) error {
+	cell := table[i][j]
+	return settleCell(table, i, j, cell, cache.evalCell(table, i, j))
+}
+
+// settleCell finishes the evaluation of the cell at row i and column j,
+// whose source was cell, for evalAt, given the error evaluating it.
+func settleCell(table Table, i, j int, cell Cell, err error) error {
+	if *traceFlag && cell.Type == Expression {
+		if err != nil {
+			fmt.Fprintf(traceOutput, "%s failed: %v\n", cellName(i, j), err)
+		} else {
+			fmt.Fprintf(traceOutput, "%s is %s\n", cellName(i, j), table[i][j].Content)
+		}
+	}
+	if err != nil {
+		table[i][j] = errorCell(err).withMeta(cell)
+		return fmt.Errorf("%s: %w", cellName(i, j), err)
+	}
+	if table[i][j].rule != nil {
+		// Cells breaking their rules keep their value, to be highlighted
+		table[i][j].Invalid = ""
+		if err := table[i][j].rule.check(table[i][j]); err != nil {
+			table[i][j].Invalid = err.Error()
+			return fmt.Errorf("%s: %w", cellName(i, j), err)
+		}
+	}
+	return nil
+}
+
+// errorCell is the cell taking the place of one that failed to evaluate,
+// holding the error too under -dbg.
+func errorCell(err error) Cell {
+	if *debugFlag {
+		return Cell{Content: fmt.Sprintf("%s: %v", errEval, err), Type: Text}
+	}
+	return Cell{Content: errEval.Error(), Type: Text}
+}
+
+func evalCell(table Table, i, j int) error {
+	cell := table[i][j]
+	switch cell.Type {
+	case Expression:
+		value, err := evalFormula(table, i, j, cell.Content[1:])
+		if err != nil {
+			return err
+		}
+		return setValue(table, i, j, value)
+	case Clone:
+		return fmt.Errorf("there should be no Clones after initial evaluation")
+	case Command:
+		return fmt.Errorf("shell commands only run when the sheet is loaded")
+	}
+	return nil
+}
+
+// evalFormula returns the value of the formula of the cell at row i and
+// column j, without replacing it.
+func evalFormula(table Table, i, j int, formula string) (Value, error) {
+	if *traceFlag {
+		fmt.Fprintf(traceOutput, "%s = %s\n", cellName(i, j), formula)
+	}
+	// Tracing needs the syntax tree, to show every step
+	if !*traceFlag {
+		if p := compiledFormula(formula, i, j); p != nil {
+			return p.eval(table, i, j)
+		}
+	}
+	expr, err := parseFormula(formula)
+	if err != nil {
+		return Value{}, err
+	}
+	return parseExpr(table, expr)
+}
+
+// setValue replaces the formula in the cell at row i and column j with its
+// value, spilling ranges into the cells next to it.
+func setValue(table Table, i, j int, value Value) error {
+	if value.Kind() == ArrayKind {
+		return spill(table, i, j, value)
+	}
+	cell := table[i][j]
+	c, err := value.cell()
+	if err != nil {
+		return err
+	}
+	if c.Type == Number && cell.Format != "" {
+		c.Content = formatNumberWith(value.num, cell.Format)
+	}
+	table[i][j] = c.withMeta(cell)
+	return nil
+}
+
+// spill writes the values of a range into the cells starting from the one
+// holding the formula, to its right and below it, which must be empty.
+func spill(table Table, i, j int, value Value) error {
+	rows := value.Rows()
+	if len(rows) == 0 {
+		return fmt.Errorf("a range can't be the value of a cell")
+	}
+	if i+len(rows) > len(table) {
+		return fmt.Errorf("range of %d rows spills past the end of the table", len(rows))
+	}
+
+	for r, row := range rows {
+		for c := range row {
+			if r == 0 && c == 0 {
+				continue
+			}
+			if j+c < len(table[i+r]) {
+				if cell := table[i+r][j+c]; cell.Type != Empty || cell.Content != "" {
+					return fmt.Errorf("range spills over %s, which isn't empty", cellName(i+r, j+c))
+				}
+			}
+		}
+	}
+
+	cols := 0
+	for r, row := range rows {
+		for c, v := range row {
+			cell, err := v.cell()
+			if err != nil {
+				return err
+			}
+			for len(table[i+r]) <= j+c {
+				table[i+r] = append(table[i+r], Cell{})
+			}
+			table[i+r][j+c] = cell
+		}
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	table[i][j].spilled = [2]int{len(rows), cols}
+	return nil
+}
+
+// parallelParseRows is how many rows a sheet needs for its rows to be
+// parsed concurrently, below which starting the goroutines costs more than
+// it saves.
+const parallelParseRows = 1024
+
+// parseTable parses every cell of the sheet source content. Large sheets
+// have their rows split in chunks, parsed concurrently by as many workers
+// as GOMAXPROCS, each one filling its own rows of the table.
+func parseTable(content string) Table {
+	// Sources not read through readSheet, like in the LSP, can still have a BOM
+	content = normalizeNewlines(strings.TrimPrefix(content, "\ufeff"))
+	lines := strings.Split(content, "\n")
+
+	if *debugFlag {
+		fmt.Println("Rows:", len(lines))
+	}
+
+	table := make(Table, len(lines))
+	workers := runtime.GOMAXPROCS(0)
+	if len(lines) < parallelParseRows || workers == 1 {
+		parseRows(table, lines, 0, len(lines))
+		return table
+	}
+
+	chunk := (len(lines) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < len(lines); lo += chunk {
+		hi := lo + chunk
+		if hi > len(lines) {
+			hi = len(lines)
+		}
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			parseRows(table, lines, lo, hi)
+		}(lo, hi)
+	}
+	wg.Wait()
+	return table
+}
+
+// parseRows parses the lines from lo to hi into the same rows of the table.
+func parseRows(table Table, lines []string, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		parts := strings.Split(lines[i], "|")
+		table[i] = make([]Cell, len(parts))
+		for j, p := range parts {
+			table[i][j] = parseCell(p)
+		}
+	}
+}
+
+// normalizeNewlines turns Windows (\r\n) and old Mac (\r) line endings into
+// \n, so that a stray \r can't end up inside the last cell of a row.
+func normalizeNewlines(content string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+}
+
+var metaRegexp = regexp.MustCompile(`\s*\{(note|link):\s*([^{}]*?)\s*\}$`)
+
+// cellFormatRegexp matches the format of a cell, like `=A1*B1 @%.4f`, which
+// writes its number instead of -fmt. There's no width, as numbers padded
+// with spaces couldn't be read back by the cells using them.
+var cellFormatRegexp = regexp.MustCompile(`(?:^|\s)@(%\+?(?:\.\d+)?[dfFeEgG])$`)
+
+// parseCell infers the type of a single cell from its source content, after
+// taking away the note and the link that can follow it, like in
+// `42 {note: Q3 estimate} {link: https://example.com}`.
+func parseCell(content string) Cell {
+	part, meta := splitMeta(content)
+	return newCell(part, meta.Format).withMeta(meta)
+}
+
+// newCell returns the cell with the given source, without note and link,
+// writing it with format if it's a number.
+func newCell(part, format string) Cell {
+	// FIXME: Find a way to eliminate empty cell rows or columns
+	var t CellType
+
+	if strings.HasPrefix(part, "=") {
+		t = Expression
+	} else if strings.HasPrefix(part, ":") {
+		t = Clone
+	} else if strings.HasPrefix(part, "!") {
+		t = Command
+	} else if value, err := strconv.ParseFloat(part, 64); err == nil && (isFinite(value) || *nanVar == "literal") {
+		t = Number
+		part = formatNumberWith(value, format)
+	} else if part != "" {
+		t = Text
+	}
+
+	return Cell{
+		Content: part,
+		Type:    t,
+		Format:  format,
+	}
+}
+
+// splitMeta splits the source content of a cell from its note, its link and
+// its format, which can follow it in any order, and from its comment, which
+// follows them all. They're returned as the fields of meta.
+func splitMeta(content string) (part string, meta Cell) {
+	part, meta.Comment = splitComment(strings.TrimSpace(content))
+	for {
+		// Most cells have neither, and the regexps would cost more than parsing
+		if strings.Contains(part, "@%") {
+			if m := cellFormatRegexp.FindStringSubmatchIndex(part); m != nil {
+				meta.Format = part[m[2]:m[3]]
+				part = strings.TrimSpace(part[:m[0]])
+				continue
+			}
+		}
+		if !strings.HasSuffix(part, "}") {
+			return part, meta
+		}
+		m := metaRegexp.FindStringSubmatchIndex(part)
+		if m == nil {
+			return part, meta
+		}
+		value := part[m[4]:m[5]]
+		if part[m[2]:m[3]] == "note" {
+			meta.Note = value
+		} else {
+			meta.Link = value
+		}
+		part = part[:m[0]]
+	}
+}
+
+// splitComment splits a cell from its comment, like `=B1*12 ; per year`,
+// which starts at the first semicolon preceded by a space and outside of
+// the strings of a formula. Comments are left out of the evaluation.
+func splitComment(content string) (part, comment string) {
+	quoted := false
+	for k := 0; k < len(content); k++ {
+		switch {
+		case content[k] == '"':
+			quoted = !quoted
+		case content[k] == ';' && !quoted && (k == 0 || content[k-1] == ' ' || content[k-1] == '\t'):
+			return strings.TrimSpace(content[:k]), strings.TrimSpace(content[k+1:])
+		}
+	}
+	return content, ""
+}
+
+// withMeta returns the cell with the note, the link, the format, the
+// comment, the span and the rules of another one, which it's replacing.
+func (c Cell) withMeta(from Cell) Cell {
+	c.Note, c.Link, c.Format, c.Comment = from.Note, from.Link, from.Format, from.Comment
+	c.Span, c.rule = from.Span, from.rule
+	return c
+}
+
+// copy returns a deep copy of the table, so that evaluating one doesn't
+// affect the other.
+func (table Table) copy() Table {
+	c := make(Table, len(table))
+	for i, row := range table {
+		c[i] = append([]Cell(nil), row...)
+	}
+	return c
+}
+
+// parseExpr evaluates a formula, or any part of it.
+func parseExpr(table Table, expr ast.Expr) (Value, error) {
+	value, err := evalExpr(table, expr)
+	if *traceFlag && err == nil {
+		traceStep(expr, value)
+	}
+	return value, err
+}
+
+func evalExpr(table Table, expr ast.Expr) (Value, error) {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if value, ok := paramsVar[ident.Name]; ok {
+			return NumberValue(value), nil
+		}
+		if ident.Name == errRef.Error() {
+			return ErrorValue(errRef), nil
+		}
+		switch strings.ToUpper(ident.Name) {
+		case "TRUE":
+			return BoolValue(true), nil
+		case "FALSE":
+			return BoolValue(false), nil
+		}
+
+		if strings.Contains(ident.Name, ":") {
+			return getRange(table, ident)
+		}
+		if strings.Contains(ident.Name, rangeSep) {
+			return Value{}, invalidRange(ident.Name)
+		}
+
+		cell, err := getCell(table, ident)
+		if err != nil {
+			return Value{}, err
+		}
+		value, err := valueOf(cell)
+		if err == errNotEvaluated {
+			return Value{}, fmt.Errorf("%s %w", ident.Name, err)
+		}
+		return value, err
+	}
+
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		return parseExpr(table, paren.X)
+	}
+
+	if binaryExpr, ok := expr.(*ast.BinaryExpr); ok {
+		x, err := parseExpr(table, binaryExpr.X)
+		if err != nil {
+			return Value{}, err
+		}
+		y, err := parseExpr(table, binaryExpr.Y)
+		if err != nil {
+			return Value{}, err
+		}
+		return evalBinary(binaryExpr.Op, x, y, func(side int) string {
+			if side == 0 {
+				return identName(binaryExpr.X)
+			}
+			return identName(binaryExpr.Y)
+		})
+	}
+
+	if unaryExpr, ok := expr.(*ast.UnaryExpr); ok && (unaryExpr.Op == token.SUB || unaryExpr.Op == token.ADD) {
+		value, err := parseExpr(table, unaryExpr.X)
+		if err != nil {
+			return Value{}, err
+		}
+		return evalUnary(unaryExpr.Op, value, func(int) string { return identName(unaryExpr.X) })
+	}
+
+	if lit, ok := expr.(*ast.BasicLit); ok {
+		if lit.Kind == token.STRING {
+			text, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			return TextValue(text), nil
+		}
+		value, err := parseNumber(lit.Value)
+		if err != nil {
+			return Value{}, err
+		}
+		return NumberValue(value), nil
+	}
+
+	if call, ok := expr.(*ast.CallExpr); ok {
+		ident, ok := call.Fun.(*ast.Ident)
+		if !ok {
+			return Value{}, fmt.Errorf("couldn't parse expr")
+		}
+		fn, ok := lookupFunc(ident.Name)
+		if !ok {
+			return Value{}, fmt.Errorf("unknown function %s", ident.Name)
+		}
+
+		args := make([]Value, len(call.Args))
+		eval := func(k int) error {
+			value, err := parseExpr(table, call.Args[k])
+			if err != nil && catchesErrors[funcName(ident.Name)] {
+				value = ErrorValue(err)
+			} else if err != nil {
+				return err
+			}
+			args[k] = value
+			return nil
+		}
+		picker, picks := picksArgs[funcName(ident.Name)]
+		for k := range call.Args {
+			if picks && !picker.key(k, len(args)) {
+				continue
+			}
+			if err := eval(k); err != nil {
+				return Value{}, err
+			}
+		}
+		if picks {
+			if k := picker.pick(args); k >= 0 {
+				if err := eval(k); err != nil {
+					return Value{}, err
+				}
+			}
+		}
+		return evalCall(ident.Name, fn, args)
+	}
+
+	return Value{}, fmt.Errorf("couldn't parse expr")
+}
+
+// evalBinary applies an operator to the values of its operands, named by
+// name when they're references, 0 being the one on the left.
+func evalBinary(op token.Token, x, y Value, name func(side int) string) (Value, error) {
+	if x.Kind() == ErrorKind {
+		return x, nil
+	}
+	if y.Kind() == ErrorKind {
+		return y, nil
+	}
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return evalComparison(op, x, y)
+	case token.AND:
+		// Like in other spreadsheets, & joins the texts of its operands
+		if x.Kind() == ArrayKind || y.Kind() == ArrayKind {
+			return Value{}, fmt.Errorf("expected a single value, got a range")
+		}
+		return TextValue(x.Text() + y.Text()), nil
+	}
+	if x, y := timeText(x), timeText(y); isTime(x) || isTime(y) {
+		return timeArith(op, x, y)
+	}
+
+	lhs, err := operand(x, name, 0)
+	if err != nil {
+		return Value{}, err
+	}
+	rhs, err := operand(y, name, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch op {
+	case token.ADD:
+		return NumberValue(lhs + rhs), nil
+	case token.SUB:
+		return NumberValue(lhs - rhs), nil
+	case token.MUL:
+		return NumberValue(lhs * rhs), nil
+	case token.QUO:
+		if rhs == 0 {
+			return ErrorValue(errDivZero), nil
+		}
+		return NumberValue(lhs / rhs), nil
+	case token.XOR:
+		// ^ raises to a power, like in other spreadsheets
+		if n := math.Pow(lhs, rhs); !math.IsNaN(n) {
+			return NumberValue(n), nil
+		}
+		return ErrorValue(errNum), nil
+	}
+	return Value{}, fmt.Errorf("couldn't parse expr")
+}
+
+// evalComparison compares two values with a comparison operator, like
+// spreadsheets do: empty values are 0 next to numbers and an empty text
+// next to texts, and texts are compared case-insensitively.
+func evalComparison(op token.Token, x, y Value) (Value, error) {
+	if x.Kind() == ArrayKind || y.Kind() == ArrayKind {
+		return Value{}, fmt.Errorf("expected a single value, got a range")
+	}
+	blank := func(v, other Value) Value {
+		if v.Kind() != EmptyKind {
+			return v
+		}
+		if other.Kind() == TextKind {
+			return TextValue("")
+		}
+		return NumberValue(0)
+	}
+	cmp := blank(x, y).compare(blank(y, x))
+	switch op {
+	case token.EQL:
+		return BoolValue(cmp == 0), nil
+	case token.NEQ:
+		return BoolValue(cmp != 0), nil
+	case token.LSS:
+		return BoolValue(cmp < 0), nil
+	case token.LEQ:
+		return BoolValue(cmp <= 0), nil
+	case token.GTR:
+		return BoolValue(cmp > 0), nil
+	}
+	return BoolValue(cmp >= 0), nil
+}
+
+// evalUnary applies the sign op to the value of its operand, named by name
+// when it's a reference.
+func evalUnary(op token.Token, value Value, name func(side int) string) (Value, error) {
+	if value.Kind() == ErrorKind {
+		return value, nil
+	}
+	if value.Kind() == DurationKind {
+		if op == token.SUB {
+			value.num = -value.num
+		}
+		return value, nil
+	}
+
+	x, err := operand(value, name, 0)
+	if err != nil {
+		return Value{}, err
+	}
+	if op == token.SUB {
+		x = -x
+	}
+	return NumberValue(x), nil
+}
+
+// evalCall calls the function fn, named name inside the formula, with the
+// values of its arguments. Error codes among them are its result, unless it
+// catches errors.
+func evalCall(name string, fn Func, args []Value) (Value, error) {
+	if !catchesErrors[funcName(name)] {
+		if errValue, ok := firstErrorCode(args); ok {
+			return errValue, nil
+		}
+	}
+
+	value, err := fn(args...)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %w", strings.ToUpper(name), err)
+	}
+	return value, nil
+}
+
+// identName returns the name of the identifier expr, if it's one.
+func identName(expr ast.Expr) string {
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// operand returns the value of an operand of an arithmetic operator, which
+// must be a number, telling which cell it comes from through name.
+func operand(value Value, name func(side int) string, side int) (float64, error) {
+	n, err := value.Number()
+	if err == nil {
+		return n, nil
+	}
+	if name := name(side); refRegexp.MatchString(name) {
+		if value.Kind() == EmptyKind {
+			return 0, fmt.Errorf("empty cell %s should not be used inside expressions", name)
+		}
+		return 0, fmt.Errorf("text cell %s should not be used inside expressions", name)
+	}
+	return n, err
+}
+
+// firstErrorCode returns the first of the errorCodes inside the arguments of
+// a function, ranges included.
+func firstErrorCode(args []Value) (Value, bool) {
+	for _, arg := range args {
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if v.Kind() == ErrorKind {
+					return v, true
+				}
+			}
+		}
+	}
+	return Value{}, false
+}
+
+func isTime(v Value) bool {
+	return v.Kind() == DateKind || v.Kind() == DurationKind
+}
+
+// timeText reads texts like `"8h"` as the date or duration they spell, so
+// that they can be used inside formulas.
+func timeText(v Value) Value {
+	if v.Kind() != TextKind {
+		return v
+	}
+	if value, err := valueOf(Cell{Content: v.text, Type: Text}); err == nil {
+		return value
+	}
+	return v
+}
+
+func getCell(table Table, ident *ast.Ident) (Cell, error) {
+	ref, err := parseRef(ident.Name)
+	if err != nil {
+		return Cell{}, err
+	}
+	return cellAt(table, ref, ident.Name)
+}
+
+// cellAt returns the cell ref refers to, named name in errors, or like ref
+// itself when name is empty.
+func cellAt(table Table, ref cellRef, name string) (Cell, error) {
+	// Only rows shorter than the reference need the width of the table,
+	// which takes going through every row
+	if ref.Row >= len(table) || ref.Col >= len(table[ref.Row]) && ref.Col >= tableWidth(table) {
+		if name == "" {
+			name = ref.String()
+		}
+		return Cell{}, refError(table, name)
+	}
+	// Like inside ranges, cells past the end of a shorter row are empty
+	if ref.Col >= len(table[ref.Row]) {
+		return Cell{}, nil
+	}
+
+	cell := table[ref.Row][ref.Col]
+	return cell, nil
+}
+
+// tableWidth returns the number of columns of the widest row.
+func tableWidth(table Table) int {
+	width := 0
+	for _, row := range table {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	return width
+}
+
+// refError reports a reference to cells outside of the table, like the
+// #REF! error of other spreadsheets.
+func refError(table Table, name string) error {
+	width := tableWidth(table)
+	if len(table) == 0 || width == 0 {
+		return fmt.Errorf("#REF! %s is out of bounds, the table is empty", name)
+	}
+	return fmt.Errorf("#REF! %s is out of bounds, the table spans A0:%s", name, cellName(len(table)-1, width-1))
+}
+
+// getRange returns the values of the cells inside a range. Cells past the
+// end of a shorter row are empty.
+func getRange(table Table, ident *ast.Ident) (Value, error) {
+	from, to, err := parseRange(ident.Name)
+	if whole, wholeTo, ok := parseWholeRange(ident.Name); ok {
+		from, to, err = whole, wholeTo, nil
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	return rangeAt(table, from, to, ident.Name)
+}
+
+// rangeAt returns the values of the cells from one reference to the other,
+// spanning the cells holding data for whole columns and rows, named name in
+// errors, or like the references themselves when name is empty.
+func rangeAt(table Table, from, to cellRef, name string) (Value, error) {
+	isWhole := from.Row < 0 || from.Col < 0
+	var err error
+	if isWhole {
+		if from, to, err = dataRange(table, from, to); err != nil {
+			return Value{}, err
+		}
+	}
+
+	// The corners are checked before going through the cells, as a range
+	// can be far larger than the table
+	if _, last := rangeCorners(from, to); last.Row >= len(table) || last.Col >= len(table[last.Row]) && last.Col >= tableWidth(table) {
+		if name == "" {
+			name = from.String() + ":" + to.String()
+		}
+		return Value{}, refError(table, name)
+	}
+	cells := rangeCells(from, to)
+
+	var rows [][]Value
+	for _, pos := range cells {
+		i, j := pos[0], pos[1]
+		if pos[1] == cells[0][1] {
+			rows = append(rows, nil)
+		}
+
+		// Whole columns and rows leave out the formulas not evaluated yet,
+		// like the one using them
+		var value Value
+		if j < len(table[i]) && !(isWhole && table[i][j].Type == Expression) {
+			if value, err = valueOf(table[i][j]); err == errNotEvaluated {
+				return Value{}, fmt.Errorf("%s %w", cellName(i, j), err)
+			} else if err != nil {
+				return Value{}, fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], value)
+	}
+	return ArrayValue(rows), nil
+}
+
+// dataRange returns the corners of the cells of whole columns or rows
+// holding data: from row 1, leaving out the header, to the last row with a
+// cell that isn't empty for columns, and from column A to the last column
+// with a cell that isn't empty for rows.
+func dataRange(table Table, from, to cellRef) (cellRef, cellRef, error) {
+	if from.Row < 0 {
+		name := fmt.Sprintf("%c:%c", 'A'+from.Col, 'A'+to.Col)
+		lo, hi := from.Col, to.Col
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		last := 0
+		for i := 1; i < len(table); i++ {
+			for j := lo; j <= hi && j < len(table[i]); j++ {
+				if table[i][j] != (Cell{}) {
+					last = i
+				}
+			}
+		}
+		if last == 0 {
+			return cellRef{}, cellRef{}, fmt.Errorf("#REF! %s has no cells below the header", name)
+		}
+		return cellRef{Row: 1, Col: lo}, cellRef{Row: last, Col: hi}, nil
+	}
+
+	name := fmt.Sprintf("%d:%d", from.Row, to.Row)
+	lo, hi := from.Row, to.Row
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if hi >= len(table) {
+		return cellRef{}, cellRef{}, refError(table, name)
+	}
+	last := -1
+	for i := lo; i <= hi; i++ {
+		for j, cell := range table[i] {
+			if cell != (Cell{}) && j > last {
+				last = j
+			}
+		}
+	}
+	if last < 0 {
+		return cellRef{}, cellRef{}, fmt.Errorf("#REF! %s has no cells", name)
+	}
+	return cellRef{Row: lo, Col: 0}, cellRef{Row: hi, Col: last}, nil
+}
+
+func parseNumber(s string) (float64, error) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func cellName(row, col int) string {
+	return fmt.Sprintf("%c%d", 'A'+col, row)
+}
+
+// parseCellName is the inverse of cellName, also accepting columns in lower
+// case.
+func parseCellName(name string) (row, col int, err error) {
+	if len(name) > 0 && name[0] >= 'a' && name[0] <= 'z' {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	if len(name) < 2 || name[0] < 'A' || name[0] > 'Z' {
+		return 0, 0, invalidRef(name)
+	}
+	row, err = strconv.Atoi(name[1:])
+	if err != nil || row < 0 {
+		return 0, 0, invalidRef(name)
+	}
+	return row, int(name[0] - 'A'), nil
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "io/ioutil"
+
+// mapFile reads the file at path, on systems where minicel can't map it
+// into memory.
+func mapFile(path string) ([]byte, func(), error) {
+	c, err := ioutil.ReadFile(path)
+	return c, func() {}, err
+}
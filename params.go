@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paramFlag collects the `-param name=value` flags.
+type paramFlag map[string]float64
+
+func (p paramFlag) String() string {
+	var pairs []string
+	for name, value := range p {
+		pairs = append(pairs, fmt.Sprintf("%s=%g", name, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p paramFlag) Set(s string) error {
+	name, raw, ok := strings.Cut(s, "=")
+	if !ok || !paramNameRegexp.MatchString(name) {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	if _, err := parseRef(name); err == nil {
+		return fmt.Errorf("parameter %s would shadow a cell", name)
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("parameter %s: %q is not a number", name, raw)
+	}
+	p[name] = value
+	return nil
+}
+
+var paramNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var placeholderRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+var paramsVar = paramFlag{}
+
+func init() {
+	flag.Var(paramsVar, "param", "name=value pair usable as name inside formulas and as ${name} inside cells (repeatable)")
+}
+
+// substitutePlaceholders replaces every `${NAME}` inside the cells with the
+// value of the parameter or, if there's none and env is set, of the
+// environment variable with that name. Cells are parsed again afterwards, so
+// that `${RATE}` can become a Number.
+func substitutePlaceholders(table Table, env bool) error {
+	for i, row := range table {
+		for j, cell := range row {
+			if !strings.Contains(cell.Content, "${") {
+				continue
+			}
+
+			var err error
+			content := placeholderRegexp.ReplaceAllStringFunc(cell.Content, func(m string) string {
+				name := m[2 : len(m)-1]
+				if value, ok := paramsVar[name]; ok {
+					return strconv.FormatFloat(value, 'g', -1, 64)
+				}
+				if !env {
+					err = fmt.Errorf("%s: undefined parameter %s, environment variables can't be read by this sheet", cellName(i, j), name)
+					return m
+				}
+				if value, ok := os.LookupEnv(name); ok {
+					return value
+				}
+				err = fmt.Errorf("%s: undefined variable %s", cellName(i, j), name)
+				return m
+			})
+			if err != nil {
+				return err
+			}
+			table[i][j] = newCell(strings.TrimSpace(content), cell.Format).withMeta(cell)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParams(t *testing.T) {
+	t.Setenv("MINICEL_RATE", "0.5")
+	if err := paramsVar.Set("tax=0.25"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(paramsVar, "tax")
+
+	var out strings.Builder
+	if err := runSheet(&out, "100|${MINICEL_RATE}|=A0*B0+tax|Rate ${tax}"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "100.00|0.50|50.25|Rate 0.25\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := runSheet(&out, "${MINICEL_UNDEFINED}")
+	if err == nil || err.Error() != "A0: undefined variable MINICEL_UNDEFINED" {
+		t.Errorf("got error %v", err)
+	}
+
+	for _, bad := range []string{"rate", "rate=x", "B2=1", "1x=2"} {
+		if err := paramsVar.Set(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Physical and converted types of Parquet columns
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetDate            = 6
+	parquetTimestampMillis = 9
+)
+
+// A parquetColumn is a column of the table with the type inferred from its
+// cells, and the encoded values of the ones that aren't empty.
+type parquetColumn struct {
+	name          string
+	typ           int
+	convertedType int
+	defined       []bool
+	values        bytes.Buffer
+}
+
+// renderParquet writes the table as a Parquet file, using the first row as
+// the names of the columns, or their letter when missing or repeated. Every
+// column is typed from the cells below the header: numbers are doubles,
+// TRUE and FALSE are booleans, dates are dates, or timestamps when some of
+// them have a time, and anything else is text. Empty cells are null. The
+// file has a single row group of uncompressed, plainly encoded pages.
+func renderParquet(w io.Writer, table Table) error {
+	if len(table) == 0 {
+		return fmt.Errorf("parquet: the table has no header")
+	}
+	rows := table[1:]
+	names := columnNames(table)
+	columns := make([]*parquetColumn, len(names))
+	for j, name := range names {
+		columns[j] = &parquetColumn{name: name, defined: make([]bool, len(rows))}
+		columns[j].encode(table, j)
+	}
+
+	// Column chunks are written one after the other after the magic number,
+	// with the metadata of the file in the footer
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, "PAR1"); err != nil {
+		return err
+	}
+	var chunks []parquetChunk
+	for _, col := range columns {
+		offset := cw.n
+		page := col.page()
+		var header thriftWriter
+		header.fieldI32(1, 0) // DATA_PAGE
+		header.fieldI32(2, int32(len(page)))
+		header.fieldI32(3, int32(len(page)))
+		header.fieldStruct(5)
+		header.fieldI32(1, int32(len(rows)))
+		header.fieldI32(2, 0) // PLAIN
+		header.fieldI32(3, 3) // RLE
+		header.fieldI32(4, 3) // RLE
+		header.end()
+		header.end()
+		if _, err := cw.Write(header.Bytes()); err != nil {
+			return err
+		}
+		if _, err := cw.Write(page); err != nil {
+			return err
+		}
+		chunks = append(chunks, parquetChunk{offset, cw.n - offset})
+	}
+
+	var meta thriftWriter
+	meta.fieldI32(1, 1)
+	meta.fieldList(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.fieldBinary(4, "schema")
+	meta.fieldI32(5, int32(len(columns)))
+	meta.end()
+	for _, col := range columns {
+		meta.begin()
+		meta.fieldI32(1, int32(col.typ))
+		meta.fieldI32(3, 1) // OPTIONAL
+		meta.fieldBinary(4, col.name)
+		if col.convertedType >= 0 {
+			meta.fieldI32(6, int32(col.convertedType))
+		}
+		meta.end()
+	}
+	meta.fieldI64(3, int64(len(rows)))
+	meta.fieldList(4, thriftStruct, 1)
+	meta.begin()
+	meta.fieldList(1, thriftStruct, len(columns))
+	var total int64
+	for k, col := range columns {
+		chunk := chunks[k]
+		total += chunk.size
+		meta.begin()
+		meta.fieldI64(2, chunk.offset)
+		meta.fieldStruct(3)
+		meta.fieldI32(1, int32(col.typ))
+		meta.fieldList(2, thriftI32, 2)
+		meta.varint(0) // PLAIN
+		meta.varint(3) // RLE
+		meta.fieldList(3, thriftBinary, 1)
+		meta.binary(col.name)
+		meta.fieldI32(4, 0) // UNCOMPRESSED
+		meta.fieldI64(5, int64(len(rows)))
+		meta.fieldI64(6, chunk.size)
+		meta.fieldI64(7, chunk.size)
+		meta.fieldI64(9, chunk.offset)
+		meta.end()
+		meta.end()
+	}
+	meta.fieldI64(2, total)
+	meta.fieldI64(3, int64(len(rows)))
+	meta.end()
+	meta.fieldBinary(6, "minicel")
+	meta.end()
+
+	footer := meta.Bytes()
+	footer = appendUint32(footer, uint32(len(footer)))
+	footer = append(footer, "PAR1"...)
+	_, err := cw.Write(footer)
+	return err
+}
+
+// parquetChunk is where a column chunk was written in the file.
+type parquetChunk struct {
+	offset, size int64
+}
+
+// encode infers the type of column j of the table and encodes the values of
+// its cells below the header.
+func (col *parquetColumn) encode(table Table, j int) {
+	typ, timed := inferColumn(table, j)
+	switch {
+	case typ == "number":
+		col.typ, col.convertedType = parquetDouble, -1
+	case typ == "bool":
+		col.typ, col.convertedType = parquetBoolean, -1
+	case typ == "date" && timed:
+		col.typ, col.convertedType = parquetInt64, parquetTimestampMillis
+	case typ == "date":
+		col.typ, col.convertedType = parquetInt32, parquetDate
+	default:
+		col.typ, col.convertedType = parquetByteArray, parquetUTF8
+	}
+
+	var bits []bool
+	for i, row := range table[1:] {
+		if j >= len(row) || row[j].Type == Empty {
+			continue
+		}
+		col.defined[i] = true
+		content := row[j].Content
+		switch col.typ {
+		case parquetDouble:
+			value, _ := strconv.ParseFloat(content, 64)
+			col.values.Write(appendUint64(nil, math.Float64bits(value)))
+		case parquetBoolean:
+			bits = append(bits, strings.ToUpper(content) == "TRUE")
+		case parquetInt64:
+			_, date := parseDate(content)
+			col.values.Write(appendUint64(nil, uint64(date.UnixNano()/int64(time.Millisecond))))
+		case parquetInt32:
+			_, date := parseDate(content)
+			col.values.Write(appendUint32(nil, uint32(int32(date.Unix()/(24*60*60)))))
+		default:
+			col.values.Write(appendUint32(nil, uint32(len(content))))
+			col.values.WriteString(content)
+		}
+	}
+	if bits != nil {
+		col.values.Write(packBits(bits))
+	}
+}
+
+// page returns the data of the only page of the column: the definition
+// levels telling the null values apart, bit-packed, followed by the values.
+func (col *parquetColumn) page() []byte {
+	levels := packBits(col.defined)
+	run := appendUvarint(nil, uint64(len(levels))<<1|1)
+	run = append(run, levels...)
+
+	page := appendUint32(nil, uint32(len(run)))
+	page = append(page, run...)
+	return append(page, col.values.Bytes()...)
+}
+
+// packBits packs the bits into bytes, least significant bit first.
+func packBits(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for k, bit := range bits {
+		if bit {
+			packed[k/8] |= 1 << (k % 8)
+		}
+	}
+	return packed
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, the way
+// Parquet files store their metadata. Fields must be written in order of
+// id, and every struct closed with end.
+type thriftWriter struct {
+	bytes.Buffer
+	lastIDs []int
+	lastID  int
+}
+
+func (t *thriftWriter) field(id, typ int) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta<<4 | typ))
+	} else {
+		t.WriteByte(byte(typ))
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.Write(appendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.Write(appendUvarint(nil, uint64(len(s))))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) fieldI32(id int, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) fieldI64(id int, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) fieldBinary(id int, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// fieldStruct starts a struct field, whose fields follow.
+func (t *thriftWriter) fieldStruct(id int) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// fieldList starts a list field of n elements, which follow. Structs in it
+// are started by begin and closed by end.
+func (t *thriftWriter) fieldList(id, typ, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n<<4 | typ))
+	} else {
+		t.WriteByte(byte(0xf0 | typ))
+		t.Write(appendUvarint(nil, uint64(n)))
+	}
+}
+
+// begin starts a struct in a list.
+func (t *thriftWriter) begin() {
+	t.lastIDs = append(t.lastIDs, t.lastID)
+	t.lastID = 0
+}
+
+// end closes the current struct.
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	if n := len(t.lastIDs); n > 0 {
+		t.lastID = t.lastIDs[n-1]
+		t.lastIDs = t.lastIDs[:n-1]
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderParquet(t *testing.T) {
+	table := parseTable("Item|Qty|Paid|Day|Item\nPens|2|TRUE|2024-03-01|\nInk|=B1*3|false|2024-03-02|x")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := renderParquet(&out, table); err != nil {
+		t.Fatal(err)
+	}
+
+	file := out.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatalf("no magic number in %q", file)
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	r := &thriftReader{b: file[len(file)-8-size : len(file)-8]}
+	meta := r.readStruct()
+
+	var names []string
+	var types []int64
+	for _, elem := range meta[2].([]interface{})[1:] {
+		fields := elem.(map[int16]interface{})
+		names = append(names, string(fields[4].([]byte)))
+		types = append(types, fields[1].(int64))
+	}
+	if want := []string{"Item", "Qty", "Paid", "Day", "E"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got columns %v, want %v", names, want)
+	}
+	want := []int64{parquetByteArray, parquetDouble, parquetBoolean, parquetInt32, parquetByteArray}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("got types %v, want %v", types, want)
+	}
+	if rows := meta[3].(int64); rows != 2 {
+		t.Errorf("got %d rows, want 2", rows)
+	}
+
+	// The page of Qty holds both rows, the second one evaluated
+	group := meta[4].([]interface{})[0].(map[int16]interface{})
+	chunk := group[1].([]interface{})[1].(map[int16]interface{})
+	offset := chunk[3].(map[int16]interface{})[9].(int64)
+	r = &thriftReader{b: file[offset:]}
+	header := r.readStruct()
+	page := r.b[:header[3].(int64)]
+	levels := int(binary.LittleEndian.Uint32(page))
+	values := page[4+levels:]
+	var got []float64
+	for ; len(values) >= 8; values = values[8:] {
+		got = append(got, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+	}
+	if !reflect.DeepEqual(got, []float64{2, 6}) {
+		t.Errorf("got %v, want [2 6]", got)
+	}
+
+	// The last column only has a value in the second row
+	chunk = group[1].([]interface{})[4].(map[int16]interface{})
+	offset = chunk[3].(map[int16]interface{})[9].(int64)
+	r = &thriftReader{b: file[offset:]}
+	r.readStruct()
+	if levels := r.b[4:6]; !bytes.Equal(levels, []byte{3, 2}) {
+		t.Errorf("got definition levels %v, want [3 2]", levels)
+	}
+	if !strings.Contains(string(r.b), "\x01\x00\x00\x00x") {
+		t.Errorf("no value x in %q", r.b)
+	}
+}
+
+// thriftReader decodes the Thrift compact protocol, just enough to check
+// what renderParquet writes.
+type thriftReader struct {
+	b []byte
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		v := r.uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n := r.uvarint()
+		s := r.b[:n]
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		header := r.b[0]
+		r.b = r.b[1:]
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for k := range list {
+			list[k] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header := r.b[0]
+		r.b = r.b[1:]
+		if header == 0 {
+			return fields
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			v := r.uvarint()
+			id = int16(v>>1) ^ -int16(v&1)
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+var cpuProfileVar = flag.String("cpuprofile", "", "write a CPU profile to this file")
+var memProfileVar = flag.String("memprofile", "", "write a memory profile to this file when done")
+
+// startProfiling starts the profiles asked for with -cpuprofile and
+// -memprofile, returning the function writing them out.
+func startProfiling() (stop func() error, err error) {
+	var cpu *os.File
+	if *cpuProfileVar != "" {
+		if cpu, err = os.Create(*cpuProfileVar); err != nil {
+			return nil, err
+		}
+		if err := runtimepprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+
+	return func() error {
+		if cpu != nil {
+			runtimepprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return err
+			}
+		}
+		if *memProfileVar == "" {
+			return nil
+		}
+		f, err := os.Create(*memProfileVar)
+		if err != nil {
+			return err
+		}
+		// Only memory that is still in use, after a collection, is interesting
+		runtime.GC()
+		if err := runtimepprof.WriteHeapProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// withPprof serves the profiles of net/http/pprof under /debug/pprof/,
+// passing every other request to handler.
+func withPprof(handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"go/ast"
+	"strings"
+)
+
+// volatileFuncs are the functions that can return something else when called
+// again with the same arguments, so that the formulas calling them are never
+// taken from a recalcCache.
+var volatileFuncs = map[string]bool{
+	"NOW":         true,
+	"TODAY":       true,
+	"RAND":        true,
+	"RANDBETWEEN": true,
+	"FETCH":       true,
+}
+
+// recalcCache keeps the values of the formulas of a sheet between its
+// evaluations, like the ones of the daemon whenever the file of a sheet
+// changes, so that only the formulas whose cells changed are evaluated
+// again. Values are keyed by the cell holding the formula, the formula
+// itself, its format and the values of the cells it references. The values
+// not used by an evaluation are dropped at the end of the next one.
+type recalcCache struct {
+	values, prev map[[sha256.Size]byte]Cell
+
+	// hits counts the formulas taken from the cache by the last evaluation
+	hits int
+}
+
+func newRecalcCache() *recalcCache {
+	return &recalcCache{values: make(map[[sha256.Size]byte]Cell)}
+}
+
+// begin starts an evaluation, moving the values of the previous one aside.
+func (c *recalcCache) begin() {
+	if c == nil {
+		return
+	}
+	c.prev, c.values, c.hits = c.values, make(map[[sha256.Size]byte]Cell), 0
+}
+
+// evalCell evaluates the cell at row i and column j like evalCell, taking
+// the value of its formula from the cache when it's there.
+func (c *recalcCache) evalCell(table Table, i, j int) error {
+	cell := table[i][j]
+	if c == nil || cell.Type != Expression || *traceFlag {
+		return evalCell(table, i, j)
+	}
+	key, ok := cacheKey(table, i, j)
+	if !ok {
+		return evalCell(table, i, j)
+	}
+	if value, ok := c.values[key]; ok {
+		c.hits++
+		table[i][j] = value.withMeta(cell)
+		return nil
+	}
+	if value, ok := c.prev[key]; ok {
+		c.hits++
+		c.values[key] = value
+		table[i][j] = value.withMeta(cell)
+		return nil
+	}
+
+	value, err := evalFormula(table, i, j, cell.Content[1:])
+	if err != nil {
+		return err
+	}
+	if err := setValue(table, i, j, value); err != nil {
+		return err
+	}
+	// Ranges spill into other cells, which the cache can't restore
+	if value.Kind() != ArrayKind {
+		c.values[key] = Cell{Content: table[i][j].Content, Type: table[i][j].Type}
+	}
+	return nil
+}
+
+// cacheKey hashes what the value of the formula at row i and column j
+// depends on. Formulas calling volatile functions, or referencing cells not
+// evaluated yet, have no key.
+func cacheKey(table Table, i, j int) (key [sha256.Size]byte, ok bool) {
+	cell := table[i][j]
+	expr, err := parseFormula(cell.Content[1:])
+	if err != nil {
+		return key, false
+	}
+	volatile := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if name, ok := call.Fun.(*ast.Ident); ok && volatileFuncs[funcName(name.Name)] {
+				volatile = true
+			}
+		}
+		return !volatile
+	})
+	if volatile {
+		return key, false
+	}
+
+	var b strings.Builder
+	b.WriteString(cellName(i, j))
+	b.WriteByte(0)
+	b.WriteString(cell.Format)
+	b.WriteByte(0)
+	b.WriteString(cell.Content)
+	for _, ref := range exprRefs(table, expr) {
+		b.WriteByte(0)
+		if ref.Row < 0 || ref.Row >= len(table) || ref.Col < 0 || ref.Col >= len(table[ref.Row]) {
+			continue
+		}
+		dep := table[ref.Row][ref.Col]
+		if dep.Type == Expression || dep.Type == Clone {
+			return key, false
+		}
+		b.WriteString(dep.Type.String())
+		b.WriteByte(0)
+		b.WriteString(dep.Content)
+	}
+	return sha256.Sum256([]byte(b.String())), true
+}
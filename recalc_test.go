@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecalcCache(t *testing.T) {
+	defer func(old bool) { *deterministicFlag = old }(*deterministicFlag)
+	*deterministicFlag = true
+	cache := newRecalcCache()
+	load := func(source string) *sheet {
+		s := newCachedSheet(source, cache, false)
+		if s.err != nil {
+			t.Fatal(s.err)
+		}
+		if want := newSheet(source); !reflect.DeepEqual(s.values, want.values) {
+			t.Errorf("values taken from the cache = %v, want %v", s.values, want.values)
+		}
+		return s
+	}
+
+	load("Item|Cost|Total\nPens|2|=B1*3\nInk|5|=B2*3\nAll|=SUM(B1:B2)|=C1+C2 @%.2f\nNow|=NOW()|=SEQUENCE(2)\n||\n")
+	if cache.hits != 0 {
+		t.Errorf("first evaluation took %d values from the cache, want 0", cache.hits)
+	}
+
+	// Only the cost of ink changed: the total of pens, which doesn't depend
+	// on it, comes from the cache, while NOW() and the ranges never do
+	s := load("Item|Cost|Total\nPens|2|=B1*3\nInk|6|=B2*3\nAll|=SUM(B1:B2)|=C1+C2 @%.2f\nNow|=NOW()|=SEQUENCE(2)\n||\n")
+	if cache.hits != 1 {
+		t.Errorf("second evaluation took %d values from the cache, want 1", cache.hits)
+	}
+	if got := s.values[3][2].Content; got != "24.00" {
+		t.Errorf("C3 = %s, want 24.00", got)
+	}
+
+	// Loaded again as it is, only NOW() and the ranges are evaluated again
+	load("Item|Cost|Total\nPens|2|=B1*3\nInk|6|=B2*3\nAll|=SUM(B1:B2)|=C1+C2 @%.2f\nNow|=NOW()|=SEQUENCE(2)\n||\n")
+	if cache.hits != 4 {
+		t.Errorf("third evaluation took %d values from the cache, want 4", cache.hits)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+func init() {
+	RegisterFunc("REGEXMATCH", regexmatch)
+	RegisterFunc("REGEXEXTRACT", regexextract)
+	RegisterFunc("REGEXREPLACE", regexreplace)
+}
+
+// maxCachedPatterns is the most compiled patterns kept around, so that a
+// formula cloned down a column compiles its pattern only once.
+const maxCachedPatterns = 256
+
+var patterns = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compilePattern compiles a pattern in the syntax of the regexp package,
+// caching the result.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patterns.Lock()
+	defer patterns.Unlock()
+
+	if re, ok := patterns.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns.m) >= maxCachedPatterns {
+		patterns.m = make(map[string]*regexp.Regexp)
+	}
+	patterns.m[pattern] = re
+	return re, nil
+}
+
+// regexArgs returns the text and the compiled pattern of a regex function.
+func regexArgs(args []Value, usage string, n int) (string, *regexp.Regexp, error) {
+	if len(args) != n {
+		return "", nil, fmt.Errorf("expected %s", usage)
+	}
+	re, err := compilePattern(args[1].Text())
+	if err != nil {
+		return "", nil, err
+	}
+	return args[0].Text(), re, nil
+}
+
+// regexmatch implements `REGEXMATCH(text, pattern)`, whether the pattern
+// matches any part of the text.
+func regexmatch(args ...Value) (Value, error) {
+	text, re, err := regexArgs(args, "REGEXMATCH(text, pattern)", 2)
+	if err != nil {
+		return Value{}, err
+	}
+	return BoolValue(re.MatchString(text)), nil
+}
+
+// regexextract implements `REGEXEXTRACT(text, pattern)`, the first match of
+// the pattern inside the text, or of its first group if it has any.
+func regexextract(args ...Value) (Value, error) {
+	text, re, err := regexArgs(args, "REGEXEXTRACT(text, pattern)", 2)
+	if err != nil {
+		return Value{}, err
+	}
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return Value{}, fmt.Errorf("%q doesn't match %q", text, re)
+	}
+	if len(m) > 1 {
+		return TextValue(m[1]), nil
+	}
+	return TextValue(m[0]), nil
+}
+
+// regexreplace implements `REGEXREPLACE(text, pattern, replacement)`, the
+// text with every match of the pattern replaced. The replacement can refer
+// to groups as `$1`, or `${name}` for named ones.
+func regexreplace(args ...Value) (Value, error) {
+	text, re, err := regexArgs(args, "REGEXREPLACE(text, pattern, replacement)", 3)
+	if err != nil {
+		return Value{}, err
+	}
+	return TextValue(re.ReplaceAllString(text, args[2].Text())), nil
+}
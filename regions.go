@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var regionRegexp = regexp.MustCompile(`^@([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(\S+)$`)
+
+// region is a named block of cells, defined by a line like `@sales = A2:D13`
+// at the top of the sheet. Its first row holds the names of its columns, so
+// that formulas can use `sales[Revenue]` for the cells below the header
+// Revenue, and `sales` for every cell below the header.
+type region struct {
+	from, to cellRef
+}
+
+// splitRegions parses the lines defining regions at the top of a sheet,
+// returning them along with how many lines they take and the rest of the
+// content. Those lines don't count as rows.
+func splitRegions(content string) (regions map[string]region, lines int, rest string, err error) {
+	regions = make(map[string]region)
+	rest = content
+	for strings.HasPrefix(strings.TrimSpace(rest), "@") {
+		line, after, _ := strings.Cut(rest, "\n")
+		lines++
+		if ruleLineRegexp.MatchString(strings.TrimSpace(line)) {
+			// Rules are parsed by splitRules
+			rest = after
+			continue
+		}
+		m := regionRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			return nil, 0, "", fmt.Errorf("line %d: invalid region %q, expected one like @sales = A2:D13", lines, strings.TrimSpace(line))
+		}
+		name := m[1]
+		if _, err := parseRef(name); err == nil {
+			return nil, 0, "", fmt.Errorf("line %d: region %s would shadow a cell", lines, name)
+		}
+		if _, ok := regions[name]; ok {
+			return nil, 0, "", fmt.Errorf("line %d: region %s is defined twice", lines, name)
+		}
+		from, to, err := parseRange(m[2])
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("line %d: %w", lines, err)
+		}
+		if from.Row > to.Row {
+			from.Row, to.Row = to.Row, from.Row
+		}
+		if from.Col > to.Col {
+			from.Col, to.Col = to.Col, from.Col
+		}
+		regions[name] = region{from, to}
+		rest = after
+	}
+	return regions, lines, rest, nil
+}
+
+// resolveRegions replaces the structured references of every formula with
+// the ranges they stand for.
+func resolveRegions(table Table, regions map[string]region) error {
+	if len(regions) == 0 {
+		return nil
+	}
+	for i, row := range table {
+		for j, cell := range row {
+			if cell.Type != Expression {
+				continue
+			}
+			content, err := resolveStructuredRefs(table, regions, cell.Content)
+			if err != nil {
+				return fmt.Errorf("%s: %w", cellName(i, j), err)
+			}
+			table[i][j].Content = content
+		}
+	}
+	return nil
+}
+
+// resolveStructuredRefs replaces `name[Header]` (or `name["Some header"]`)
+// inside a formula with the range of the column below the header, and
+// `name` alone with every cell below the header. The ranges are anchored,
+// so that cloning the formula doesn't move them.
+func resolveStructuredRefs(table Table, regions map[string]region, formula string) (string, error) {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		// Malformed formulas fail anyway once evaluated
+		return formula, nil
+	}
+
+	changed := false
+	expr, err = mapExpr(expr, func(expr ast.Expr) (ast.Expr, error) {
+		var name, header string
+		switch e := expr.(type) {
+		case *ast.Ident:
+			name = e.Name
+		case *ast.IndexExpr:
+			x, ok := e.X.(*ast.Ident)
+			if !ok {
+				return expr, nil
+			}
+			name = x.Name
+			switch index := e.Index.(type) {
+			case *ast.Ident:
+				header = index.Name
+			case *ast.BasicLit:
+				if index.Kind != token.STRING {
+					return expr, nil
+				}
+				header, _ = strconv.Unquote(index.Value)
+			default:
+				return expr, nil
+			}
+		default:
+			return expr, nil
+		}
+
+		r, ok := regions[name]
+		if !ok {
+			return expr, nil
+		}
+		changed = true
+		from, to, err := r.body(table, header)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return &ast.Ident{Name: from.String() + ":" + to.String()}, nil
+	})
+	if err != nil || !changed {
+		return formula, err
+	}
+	return "=" + formatFormula(expr), nil
+}
+
+// body returns the anchored corners of the cells below the header of the
+// region, only of the column with the given header unless it's empty.
+func (r region) body(table Table, header string) (from, to cellRef, err error) {
+	if r.to.Row == r.from.Row {
+		return cellRef{}, cellRef{}, fmt.Errorf("no rows below the header %s:%s", r.from, r.to)
+	}
+	from = cellRef{Row: r.from.Row + 1, Col: r.from.Col, AbsRow: true, AbsCol: true}
+	to = cellRef{Row: r.to.Row, Col: r.to.Col, AbsRow: true, AbsCol: true}
+	if header == "" {
+		return from, to, nil
+	}
+
+	for col := r.from.Col; col <= r.to.Col; col++ {
+		if r.from.Row < len(table) && col < len(table[r.from.Row]) && strings.EqualFold(table[r.from.Row][col].Content, header) {
+			from.Col, to.Col = col, col
+			return from, to, nil
+		}
+	}
+	return cellRef{}, cellRef{}, fmt.Errorf("no column %q", header)
+}
+
+// mapExpr replaces every node of expr with the one returned by fn, from the
+// leaves up. Function names are left alone, only their arguments are mapped.
+func mapExpr(expr ast.Expr, fn func(expr ast.Expr) (ast.Expr, error)) (ast.Expr, error) {
+	var err error
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		e.X, err = mapExpr(e.X, fn)
+	case *ast.UnaryExpr:
+		e.X, err = mapExpr(e.X, fn)
+	case *ast.BinaryExpr:
+		if e.X, err = mapExpr(e.X, fn); err == nil {
+			e.Y, err = mapExpr(e.Y, fn)
+		}
+	case *ast.CallExpr:
+		for k := range e.Args {
+			if e.Args[k], err = mapExpr(e.Args[k], fn); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fn(expr)
+}
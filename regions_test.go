@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStructuredRefs(t *testing.T) {
+	regions, lines, rest, err := splitRegions("@sales = C3:A0\n  @Other=b0:b9  \nName|Qty|Price\nPens|2|1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines != 2 || !strings.HasPrefix(rest, "Name|") || regions["sales"] != (region{cellRef{Row: 0, Col: 0}, cellRef{Row: 3, Col: 2}}) {
+		t.Fatalf("got %v, %d lines, rest %q", regions, lines, rest)
+	}
+
+	table := parseTable(rest)
+	tests := []struct {
+		formula, want string
+	}{
+		{"=SUM(sales[Qty])", "=SUM($B$1:$B$3)"},
+		{`=AVERAGE(sales["price"]) * 2`, "=AVERAGE($C$1:$C$3) * 2"},
+		{"=MAX(sales) + SUM(Other[Qty])", "=MAX($A$1:$C$3) + SUM($B$1:$B$9)"},
+		{"=sales(A1)", "=sales(A1)"},
+		{"=SUM(A1:A2", "=SUM(A1:A2"},
+	}
+	for _, tt := range tests {
+		got, err := resolveStructuredRefs(table, regions, tt.formula)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveStructuredRefs(%q) = %q, %v, want %q", tt.formula, got, err, tt.want)
+		}
+	}
+
+	for _, formula := range []string{"=sales[Total]", "=Other[Name]"} {
+		if _, err := resolveStructuredRefs(table, regions, formula); err == nil {
+			t.Errorf("resolveStructuredRefs(%q) should fail", formula)
+		}
+	}
+	for _, content := range []string{"@A1 = A1:B2\nx", "@x = A1\ny", "@x = A1:B2\n@x = A1:B2\ny"} {
+		if _, _, _, err := splitRegions(content); err == nil {
+			t.Errorf("splitRegions(%q) should fail", content)
+		}
+	}
+}
+
+func TestLSPRegions(t *testing.T) {
+	doc := newLSPDocument("@qty = A0:A2\nQty|Total\n1|=SUM(qty)\n2|=NOPE()\n")
+	diagnostics := doc.diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Range.Start != (lspPosition{3, 2}) {
+		t.Fatalf("got %+v", diagnostics)
+	}
+	if hover := doc.hover(lspPosition{2, 3}); !strings.Contains(fmt.Sprint(hover), "**B1** (Number): `3.00`") {
+		t.Errorf("got hover %v", hover)
+	}
+	if hover := doc.hover(lspPosition{0, 3}); hover != nil {
+		t.Errorf("got hover %v over the regions", hover)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+func dumpTable(w io.Writer, table Table) {
+	sep := columnSep()
+
+	// Estimate column widths
+	var widths []int
+	texts := make([][]string, len(table))
+	for i, row := range table {
+		texts[i] = make([]string, len(row))
+		for j, cell := range row {
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			texts[i][j] = displayText(cell.Content)
+			if n := displayWidth(texts[i][j]); n > widths[j] && cell.span() == 1 {
+				widths[j] = n
+			}
+		}
+	}
+
+	// Merged cells too wide for the columns they span widen the last one
+	for i, row := range table {
+		for j, cell := range row {
+			if span := cell.span(); span > 1 {
+				widenSpan(widths, sep, j, span, displayWidth(texts[i][j]))
+			}
+		}
+	}
+
+	if *debugFlag {
+		fmt.Fprintln(w, "Column widths:", widths)
+	}
+
+	// Render table
+	for i, row := range texts {
+		writeRow(w, row, rowSpans(table[i]), rowStyles(table, i), widths, sep)
+	}
+}
+
+// columnSep returns what separates the columns of the plain output.
+func columnSep() string {
+	if *prettyPrintFlag {
+		return " | "
+	}
+	return "|"
+}
+
+// spanWidth returns the width of a cell spanning span columns from column j,
+// separators included.
+func spanWidth(widths []int, sep string, j, span int) int {
+	n := len(sep) * (span - 1)
+	for _, width := range widths[j : j+span] {
+		n += width
+	}
+	return n
+}
+
+// widenSpan widens the last of the columns spanned by a merged cell when
+// the cell, n columns wide, doesn't fit them.
+func widenSpan(widths []int, sep string, j, span, n int) {
+	if n -= spanWidth(widths, sep, j, span); n > 0 {
+		widths[j+span-1] += n
+	}
+}
+
+// rowSpans returns how many columns each cell of the row spans.
+func rowSpans(row []Cell) []int {
+	spans := make([]int, len(row))
+	for j, cell := range row {
+		spans[j] = cell.span()
+	}
+	return spans
+}
+
+// rowStyles returns the styles of each cell of row i under -color, or none.
+func rowStyles(table Table, i int) [][]string {
+	if !*colorFlag {
+		return nil
+	}
+	styles := make([][]string, len(table[i]))
+	for j, cell := range table[i] {
+		styles[j] = cell.styles(table, i, j)
+	}
+	return styles
+}
+
+// writeRow writes the texts of the cells of a row, aligned to the widths of
+// the columns.
+func writeRow(w io.Writer, texts []string, spans []int, styles [][]string, widths []int, sep string) {
+	for j := 0; j < len(texts); {
+		span := spans[j]
+		text := texts[j]
+		fillSpace := spanWidth(widths, sep, j, span) - displayWidth(text)
+		if *alignmentVar == "center" {
+			fmt.Fprint(w, strings.Repeat(" ", fillSpace/2))
+		} else if *alignmentVar == "right" {
+			fmt.Fprint(w, strings.Repeat(" ", fillSpace))
+		}
+
+		if styles != nil {
+			text = ansiStyle(text, styles[j])
+		}
+		fmt.Fprint(w, text)
+		j += span
+		if j < len(texts) {
+			if *alignmentVar == "left" {
+				fmt.Fprint(w, strings.Repeat(" ", fillSpace))
+			} else if *alignmentVar == "center" {
+				fmt.Fprint(w, strings.Repeat(" ", fillSpace-fillSpace/2))
+			}
+			fmt.Fprint(w, sep)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// renderJSON writes the table as a JSON array of rows.
+func renderJSON(w io.Writer, table Table) error {
+	if table == nil {
+		table = Table{}
+	}
+	return json.NewEncoder(w).Encode(table)
+}
+
+type streamedRow struct {
+	Row   int    `json:"row"`
+	Cells []Cell `json:"cells"`
+}
+
+// streamTable evaluates the table one row at a time, writing each one as a
+// line of JSON as soon as it's ready. Like evalTable, it carries on past the
+// cells that fail.
+func streamTable(w io.Writer, table Table) error {
+	enc := json.NewEncoder(w)
+	var errs evalErrors
+	for i := range table {
+		if err := evalRow(table, i, nil); err != nil {
+			errs = append(errs, err.(evalErrors)...)
+		}
+		if err := enc.Encode(streamedRow{Row: i, Cells: localizeTable(table[i : i+1])[0]}); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// renderHTML writes the table as an HTML table, using the first row as
+// header. Notes and comments are shown as the title of their cells, merged
+// cells span their columns and the cells are styled by their rules.
+func renderHTML(w io.Writer, table Table) {
+	fmt.Fprintln(w, "<table>")
+	for i, row := range table {
+		tag := "td"
+		if i == 0 {
+			tag = "th"
+		}
+
+		fmt.Fprint(w, "  <tr>")
+		for j := 0; j < len(row); j += row[j].span() {
+			cell := row[j]
+			class := strings.ToLower(cell.Type.String())
+			fmt.Fprintf(w, "<%s class=%q", tag, class)
+			if span := cell.span(); span > 1 {
+				fmt.Fprintf(w, " colspan=\"%d\"", span)
+			}
+			if styles := cell.styles(table, i, j); len(styles) > 0 {
+				fmt.Fprintf(w, " style=%q", cssStyle(styles))
+			}
+			if title := cell.title(); title != "" {
+				fmt.Fprintf(w, " title=\"%s\"", html.EscapeString(title))
+			}
+
+			content := html.EscapeString(cell.Content)
+			if cell.Link != "" {
+				content = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(cell.Link), content)
+			}
+			fmt.Fprintf(w, ">%s</%s>", content, tag)
+		}
+		fmt.Fprintln(w, "</tr>")
+	}
+	fmt.Fprintln(w, "</table>")
+}
+
+// title returns the note and the comment of the cell, one per line, for
+// the formats showing them together.
+func (c Cell) title() string {
+	if c.Note != "" && c.Comment != "" {
+		return c.Note + "\n" + c.Comment
+	}
+	return c.Note + c.Comment
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreamTable(t *testing.T) {
+	var out strings.Builder
+	err := streamTable(&out, parseTable("Qty|Total\n2|=A1*3\n=C5|x"))
+	want := `{"row":0,"cells":[{"content":"Qty","type":"Text"},{"content":"Total","type":"Text"}]}
+{"row":1,"cells":[{"content":"2.00","type":"Number"},{"content":"6.00","type":"Number"}]}
+{"row":2,"cells":[{"content":"#ERROR","type":"Text"},{"content":"x","type":"Text"}]}
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Cells that fail don't stop the rows after them
+	if err == nil || err.Error() != "A2: #REF! C5 is out of bounds, the table spans A0:B2" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestStreamText(t *testing.T) {
+	const sheet = "Item|Qty|Total\nWidgets for the office|2|=B1*3\n=D5|10|x"
+
+	// Measured columns render like the whole table
+	var want strings.Builder
+	table := parseTable(sheet)
+	evalTable(table)
+	dumpTable(&want, localizeTable(table))
+	var out strings.Builder
+	err := streamText(&out, parseTable(sheet))
+	if got := out.String(); got != want.String() {
+		t.Errorf("got %q, want %q", got, want.String())
+	}
+	if err == nil || err.Error() != "A2: #REF! D5 is out of bounds, the table spans A0:C2" {
+		t.Errorf("got error %v", err)
+	}
+
+	// Fixed widths cut the cells too wide for them, the last one repeating
+	*widthsVar = "8,4"
+	defer func() { *widthsVar = "" }()
+	out.Reset()
+	streamText(&out, parseTable(sheet))
+	if got, want := out.String(), "Item    |Qty |Tot…\nWidgets…|2.00|6.00\n#ERROR  |10.…|x\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCellMeta(t *testing.T) {
+	table := parseTable("Budget {note: yearly}|=A1*2 {link: https://example.com/q?a=1&b=2}\n42 {note: Q3 estimate} {link: https://example.com}|")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := renderJSON(&out, table); err != nil {
+		t.Fatal(err)
+	}
+	want := `[[{"content":"Budget","type":"Text","note":"yearly"},{"content":"84.00","type":"Number","link":"https://example.com/q?a=1\u0026b=2"}],[{"content":"42.00","type":"Number","note":"Q3 estimate","link":"https://example.com"},{"content":"","type":"Empty"}]]
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	out.Reset()
+	renderHTML(&out, table[1:])
+	want = `<table>
+  <tr><th class="number" title="Q3 estimate"><a href="https://example.com">42.00</a></th><th class="empty"></th></tr>
+</table>
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCellComments(t *testing.T) {
+	table := parseTable("Qty ; units sold|=UPPER(\"a ;b\") ; joined\n2 {note: estimate} ; from the farm|a;b")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := renderJSON(&out, table); err != nil {
+		t.Fatal(err)
+	}
+	want := `[[{"content":"Qty","type":"Text","comment":"units sold"},{"content":"A ;B","type":"Text","comment":"joined"}],[{"content":"2.00","type":"Number","note":"estimate","comment":"from the farm"},{"content":"a;b","type":"Text"}]]
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	out.Reset()
+	renderHTML(&out, table[1:])
+	want = `<table>
+  <tr><th class="number" title="estimate
+from the farm">2.00</th><th class="text">a;b</th></tr>
+</table>
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	defer func(wide bool) { *wideFlag = wide }(*wideFlag)
+
+	for _, tc := range []struct {
+		s          string
+		runes, eaw int
+	}{
+		{"abc", 3, 3},
+		{"café", 4, 4},
+		{"cafe\u0301", 4, 4},
+		{"日本語", 3, 6},
+		{"ｘ", 1, 2},
+		{"🍕 ok", 4, 5},
+	} {
+		*wideFlag = false
+		if got := displayWidth(tc.s); got != tc.runes {
+			t.Errorf("displayWidth(%q) = %d, want %d", tc.s, got, tc.runes)
+		}
+		*wideFlag = true
+		if got := displayWidth(tc.s); got != tc.eaw {
+			t.Errorf("displayWidth(%q) with -wide = %d, want %d", tc.s, got, tc.eaw)
+		}
+	}
+}
+
+func TestDisplayText(t *testing.T) {
+	defer func(max int) { *maxWidthVar = max }(*maxWidthVar)
+
+	for _, tc := range []struct {
+		s, want string
+		max     int
+	}{
+		{"a\tb", "a       b", 0},
+		{"name:\tx", "name:   x", 0},
+		{"\x1b[31mred\x1b[0m", `\x1b[31mred\x1b[0m`, 0},
+		{"bell\a", `bell\a`, 0},
+		{"abcdef", "abcdef", 6},
+		{"abcdefg", "abcde…", 6},
+		{"a\tb", "a    …", 6},
+		{"crème brûlée", "crème…", 6},
+	} {
+		*maxWidthVar = tc.max
+		if got := displayText(tc.s); got != tc.want {
+			t.Errorf("displayText(%q) with -max-width %d = %q, want %q", tc.s, tc.max, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sigfigsVar = flag.Int("sigfigs", 0, "write numbers with this many significant figures and no trailing zeros, instead of using -fmt")
+var roundVar = flag.String("round", "half-up", "how numbers are rounded when formatted and by ROUND, MROUND and SIGFIG: half-up (away from zero, like Excel), half-even (banker's rounding) or truncate")
+
+func init() {
+	RegisterFunc("ROUND", roundFunc)
+	RegisterFunc("MROUND", mround)
+	RegisterFunc("SIGFIG", sigfig)
+	RegisterFunc("CEILING", roundToMultiple("CEILING", math.Ceil))
+	RegisterFunc("FLOOR", roundToMultiple("FLOOR", math.Floor))
+	RegisterFunc("ABS", abs)
+}
+
+// roundDigits rounds n to the given significant digits, formatting it as a
+// decimal number and parsing it back so that the result is the float closest
+// to what is written (e.g. 1.25 and not 1.2500000000000002).
+func roundDigits(n float64, digits int) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(n, 'g', digits, 64), 64)
+	return rounded
+}
+
+// roundMode rounds n to the given decimal places according to -round.
+// Like roundDigits, it works on n as it is written, so that 2.675 is halfway
+// between 2.67 and 2.68 even if its closest float is slightly less.
+func roundMode(n float64, places int) float64 {
+	if !isFinite(n) {
+		return n
+	}
+	scale := math.Pow(10, float64(places))
+	return roundDigits(roundInt(roundDigits(n*scale, 15))/scale, 15)
+}
+
+// roundInt rounds x to an integer according to -round.
+func roundInt(x float64) float64 {
+	switch *roundVar {
+	case "half-even":
+		return math.RoundToEven(x)
+	case "truncate":
+		return math.Trunc(x)
+	}
+	return math.Round(x)
+}
+
+var precisionRegexp = regexp.MustCompile(`%[-+# 0]*\d*\.(\d+)[fF]`)
+
+// formatNumber writes n with -fmt, rounding it first with -round when the
+// format has a fixed number of decimal places, like %.2f. With -sigfigs it's
+// rounded to significant figures instead, leaving out trailing zeros.
+func formatNumber(n float64) string {
+	if *sigfigsVar > 0 && isFinite(n) {
+		if n != 0 {
+			n = roundMode(n, *sigfigsVar-1-int(math.Floor(math.Log10(math.Abs(n)))))
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return printNumber(n, *numberFormatVar)
+}
+
+// formatNumberWith writes n with the format of a cell, like %d or %.4f,
+// or like formatNumber when the cell has none.
+func formatNumberWith(n float64, format string) string {
+	if format == "" {
+		return formatNumber(n)
+	}
+	return printNumber(n, format)
+}
+
+// printNumber writes n with a printf-like format, rounding it first with
+// -round to the decimal places of the format, and to an integer for %d.
+func printNumber(n float64, format string) string {
+	if strings.HasSuffix(format, "d") {
+		if !isFinite(n) {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		return fmt.Sprintf(format, int64(roundMode(n, 0)))
+	}
+	if m := precisionRegexp.FindStringSubmatch(format); m != nil {
+		places, _ := strconv.Atoi(m[1])
+		n = roundMode(n, places)
+	}
+	return fmt.Sprintf(format, n)
+}
+
+// roundFunc implements `ROUND(x, [digits])`, x rounded to the given decimal
+// places (0 by default, negative to round to tens, hundreds and so on)
+// according to -round.
+func roundFunc(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("expected ROUND(x, [digits])")
+	}
+	x, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	digits := 0.0
+	if len(args) == 2 {
+		if digits, err = args[1].Number(); err != nil {
+			return Value{}, err
+		}
+	}
+	if digits != math.Trunc(digits) {
+		return Value{}, fmt.Errorf("digits must be a whole number, got %g", digits)
+	}
+	return NumberValue(roundMode(x, int(digits))), nil
+}
+
+// mround implements `MROUND(x, multiple)`, x rounded to the nearest multiple
+// according to -round.
+func mround(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected MROUND(x, multiple)")
+	}
+	x, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	multiple, err := args[1].Number()
+	if err != nil {
+		return Value{}, err
+	}
+
+	if multiple == 0 {
+		return NumberValue(0), nil
+	}
+	if x*multiple < 0 {
+		return Value{}, fmt.Errorf("x and multiple must have the same sign")
+	}
+	// Dividing can leave tiny errors, like with roundToMultiple
+	q := roundDigits(x/multiple, 15)
+	return NumberValue(roundDigits(roundInt(q)*multiple, 15)), nil
+}
+
+// sigfig implements `SIGFIG(x, n)`, x rounded to n significant figures
+// according to -round.
+func sigfig(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected SIGFIG(x, n)")
+	}
+	x, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	n, err := positionArg(args[1], "n")
+	if err != nil {
+		return Value{}, err
+	}
+	if x == 0 {
+		return NumberValue(0), nil
+	}
+	return NumberValue(roundMode(x, n-1-int(math.Floor(math.Log10(math.Abs(x)))))), nil
+}
+
+// roundToMultiple returns `CEILING(x, [significance])` or `FLOOR(x,
+// [significance])`, x rounded up or down to a multiple of significance (1 by
+// default). Like in other spreadsheets, a negative significance rounds
+// negative numbers the other way, i.e. CEILING rounds away from zero.
+func roundToMultiple(name string, round func(float64) float64) Func {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return Value{}, fmt.Errorf("expected %s(x, [significance])", name)
+		}
+		x, err := args[0].Number()
+		if err != nil {
+			return Value{}, err
+		}
+		significance := 1.0
+		if len(args) == 2 {
+			if significance, err = args[1].Number(); err != nil {
+				return Value{}, err
+			}
+		}
+
+		if significance == 0 {
+			return NumberValue(0), nil
+		}
+		if x > 0 && significance < 0 {
+			return Value{}, fmt.Errorf("significance can't be negative when x is positive")
+		}
+		// Dividing can leave tiny errors, like 4.4 / 0.05 = 88.00000000000001
+		q := roundDigits(x/significance, 15)
+		return NumberValue(roundDigits(round(q)*significance, 15)), nil
+	}
+}
+
+// abs implements `ABS(x)`, x without its sign.
+func abs(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("expected ABS(x)")
+	}
+	x, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	return NumberValue(math.Abs(x)), nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var schemaVar = flag.String("schema", "", "file declaring the type of the columns, like A:number, B:text, C:date, checked against the cells below the header when loading")
+
+var schemaRegexp = regexp.MustCompile(`^([A-Za-z])\s*:\s*([a-z]+)$`)
+
+// columnTypes checks the content of a cell against the type declared for
+// its column, returning whether it conforms.
+var columnTypes = map[string]func(cell Cell) bool{
+	"number": func(cell Cell) bool { return cell.Type == Number },
+	"text":   func(cell Cell) bool { return cell.Type == Text },
+	"date": func(cell Cell) bool {
+		for _, layout := range dateLayouts {
+			if _, err := time.Parse(layout, cell.Content); err == nil {
+				return true
+			}
+		}
+		return false
+	},
+	"bool": func(cell Cell) bool {
+		upper := strings.ToUpper(cell.Content)
+		return cell.Type == Text && (upper == "TRUE" || upper == "FALSE")
+	},
+	"duration": func(cell Cell) bool {
+		_, ok := parseDuration(cell.Content)
+		return ok
+	},
+}
+
+// schema maps columns to the type declared for them.
+type schema map[int]string
+
+// parseSchema parses declarations like `A:number, B:text, C:date`,
+// separated by commas or new lines.
+func parseSchema(content string) (schema, error) {
+	s := make(schema)
+	for _, decl := range strings.FieldsFunc(content, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+		m := schemaRegexp.FindStringSubmatch(decl)
+		if m == nil {
+			return nil, fmt.Errorf("invalid column type %q, expected one like A:number", decl)
+		}
+		if _, ok := columnTypes[m[2]]; !ok {
+			return nil, fmt.Errorf("%s: unknown type %s, expected bool, date, duration, number or text", decl, m[2])
+		}
+		col := int(strings.ToUpper(m[1])[0] - 'A')
+		if _, ok := s[col]; ok {
+			return nil, fmt.Errorf("column %c is declared twice", 'A'+col)
+		}
+		s[col] = m[2]
+	}
+	return s, nil
+}
+
+// flagSchema returns the schema in the file given by -schema, if any.
+func flagSchema() (schema, error) {
+	if *schemaVar == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(*schemaVar)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSchema(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *schemaVar, err)
+	}
+	return s, nil
+}
+
+// check returns an error for every cell below the header not conforming to
+// the type of its column. Empty cells and the ones computed later, like
+// formulas and clones, are left alone.
+func (s schema) check(table Table) error {
+	var errs evalErrors
+	for i := 1; i < len(table); i++ {
+		for j, cell := range table[i] {
+			if cell.Type != Number && cell.Type != Text {
+				continue
+			}
+			if typ, ok := s[j]; ok && !columnTypes[typ](cell) {
+				errs = append(errs, fmt.Errorf("%s: expected %s in column %c, got %q", cellName(i, j), typ, 'A'+j, cell.Content))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// inferColumn returns the type of column j below the header, the way a
+// schema would declare it: number, bool or date when every cell that isn't
+// empty has that type, text otherwise. timed tells whether some of the
+// dates have a time.
+func inferColumn(table Table, j int) (typ string, timed bool) {
+	var cells []Cell
+	for _, row := range table[1:] {
+		if j < len(row) && row[j].Type != Empty {
+			cells = append(cells, row[j])
+		}
+	}
+	all := func(ok func(cell Cell) bool) bool {
+		for _, cell := range cells {
+			if !ok(cell) {
+				return false
+			}
+		}
+		return len(cells) > 0
+	}
+
+	switch {
+	case all(func(cell Cell) bool {
+		_, err := strconv.ParseFloat(cell.Content, 64)
+		return cell.Type == Number && err == nil
+	}):
+		return "number", false
+	case all(columnTypes["bool"]):
+		return "bool", false
+	case all(columnTypes["date"]):
+		for _, cell := range cells {
+			if layout, _ := parseDate(cell.Content); strings.Contains(layout, "15:04") {
+				return "date", true
+			}
+		}
+		return "date", false
+	}
+	return "text", false
+}
+
+// parseDate parses a date written in one of dateLayouts, returning the
+// layout too.
+func parseDate(s string) (string, time.Time) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, s); err == nil {
+			return layout, date
+		}
+	}
+	return "", time.Time{}
+}
+
+// columnNames names the columns of the table after its header, using the
+// letter of the ones whose name is missing or repeated.
+func columnNames(table Table) []string {
+	names := make([]string, tableWidth(table))
+	seen := make(map[string]bool)
+	for j := range names {
+		if j < len(table[0]) {
+			names[j] = strings.TrimSpace(table[0][j].Content)
+		}
+		if names[j] == "" || seen[names[j]] {
+			names[j] = string(rune('A' + j))
+		}
+		seen[names[j]] = true
+	}
+	return names
+}
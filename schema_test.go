@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestSchema(t *testing.T) {
+	s, err := parseSchema("A:text, b:number\nC : date,D:bool,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := parseTable("Name|Qty|When|Paid\nPens|2|2024-01-05|true\n3|x|soon|=B1>1\nInk||05.02.2024|no")
+	want := `A2: expected text in column A, got "3.00"
+B2: expected number in column B, got "x"
+C2: expected date in column C, got "soon"
+D3: expected bool in column D, got "no"`
+	if err := s.check(table); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+
+	for _, content := range []string{"A:num", "A:text, a:number", "AA:text", "number"} {
+		if _, err := parseSchema(content); err == nil {
+			t.Errorf("parseSchema(%q) succeeded", content)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var trailingIntRegexp = regexp.MustCompile(`^(.*\D)(\d+)$`)
+
+var dateLayouts = []string{"02.01.2006", "2006-01-02", "02.01.2006 15:04", "2006-01-02 15:04"}
+
+var months = []string{
+	"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December",
+}
+
+// nextInSeries returns the cell that follows target when drag-filling a
+// series of numbers, dates, month names or text ending with a number. The
+// step of the series is the difference between target and prev, the cell
+// preceding it, or 1 when prev is nil or isn't part of the same series.
+func nextInSeries(target Cell, prev *Cell) (Cell, error) {
+	var prevContent string
+	if prev != nil {
+		prevContent = prev.Content
+	}
+
+	if target.Type == Number {
+		value, err := parseNumber(target.Content)
+		if err != nil {
+			return Cell{}, err
+		}
+		step := 1.0
+		if prev != nil && prev.Type == Number {
+			if prevValue, err := parseNumber(prevContent); err == nil {
+				step = value - prevValue
+			}
+		}
+
+		target.Content = formatNumberWith(value+step, target.Format)
+		return target, nil
+	}
+
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, target.Content)
+		if err != nil {
+			continue
+		}
+		step := 1
+		if prevDate, err := time.Parse(layout, prevContent); err == nil {
+			step = int(date.Sub(prevDate).Hours() / 24)
+		}
+
+		target.Content = date.AddDate(0, 0, step).Format(layout)
+		return target, nil
+	}
+
+	if month, short, ok := parseMonth(target.Content); ok {
+		step := 1
+		if prevMonth, _, ok := parseMonth(prevContent); ok {
+			step = month - prevMonth
+		}
+
+		next := months[((month+step)%12+12)%12]
+		if short {
+			next = next[:3]
+		}
+		target.Content = matchCase(next, target.Content)
+		return target, nil
+	}
+
+	if m := trailingIntRegexp.FindStringSubmatch(target.Content); m != nil {
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return Cell{}, err
+		}
+		step := 1
+		if pm := trailingIntRegexp.FindStringSubmatch(prevContent); pm != nil && pm[1] == m[1] {
+			if prevN, err := strconv.Atoi(pm[2]); err == nil {
+				step = n - prevN
+			}
+		}
+
+		// Keep the zero padding of numbers like `Q01`
+		target.Content = fmt.Sprintf("%s%0*d", m[1], len(m[2]), n+step)
+		return target, nil
+	}
+
+	return Cell{}, fmt.Errorf("cannot continue a series from %q", target.Content)
+}
+
+// parseMonth returns the zero-based index of the month named by s, which
+// can be either spelled out or abbreviated to three letters.
+func parseMonth(s string) (month int, short bool, ok bool) {
+	for i, m := range months {
+		if strings.EqualFold(s, m) {
+			return i, false, true
+		}
+		if strings.EqualFold(s, m[:3]) {
+			return i, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// matchCase returns s in the same case as model: upper, lower or title.
+func matchCase(s, model string) string {
+	switch model {
+	case strings.ToUpper(model):
+		return strings.ToUpper(s)
+	case strings.ToLower(model):
+		return strings.ToLower(s)
+	}
+	return s
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sheet is a table kept in memory by the server. Its resolved and evaluated
+// versions are kept around too, so that an update only recalculates the cells
+// affected by it.
+type sheet struct {
+	mu       sync.Mutex
+	source   Table
+	regions  map[string]region
+	resolved Table
+	values   Table
+	err      error
+
+	// cache keeps the values of the formulas when the sheet is loaded again
+	cache *recalcCache
+}
+
+// newSheet loads a sheet sent by a client, which can't read environment
+// variables, run commands, query databases nor call FETCH.
+func newSheet(content string) *sheet {
+	return newCachedSheet(content, nil, false)
+}
+
+// newCachedSheet loads a sheet like newSheet, taking the values of its
+// formulas from the cache, when given, instead of evaluating them again.
+// Sheets read from local files can read environment variables, run commands,
+// query databases and call FETCH like on the command line.
+func newCachedSheet(content string, cache *recalcCache, local bool) *sheet {
+	s := &sheet{cache: cache}
+	rules, err := splitRules(strings.TrimSpace(content))
+	if err != nil {
+		s.err = err
+		return s
+	}
+	if s.regions, _, content, s.err = splitRegions(strings.TrimSpace(content)); s.err != nil {
+		return s
+	}
+	if s.err = checkLimits(content); s.err != nil {
+		return s
+	}
+	table := parseTable(content)
+	if s.err = resolveRegions(table, s.regions); s.err != nil {
+		return s
+	}
+	if s.source, s.err = preloadTable(table, local); s.err != nil {
+		return s
+	}
+	if s.err = applyRules(s.source, rules); s.err == nil {
+		s.recalc()
+	}
+	return s
+}
+
+// recalc resolves the clones of the sheet and evaluates it. When the sheet
+// was already evaluated, only the cells that changed or failed since then,
+// along with the cells depending on them, are evaluated again. It returns the
+// number of cells evaluated.
+func (s *sheet) recalc() (count int) {
+	start := time.Now()
+	defer func() { serverMetrics.observeRecalc(time.Since(start), count, s.err) }()
+
+	resolved := s.source.copy()
+	if err := resolveClones(resolved); err != nil {
+		s.resolved, s.values, s.err = nil, nil, err
+		return 0
+	}
+
+	values, cache := resolved.copy(), s.cache
+	if s.evaluated() {
+		// Only the dirty cells are evaluated, there's no need for the cache.
+		// The cells that failed are evaluated again to find their errors.
+		values, cache = s.values.copy(), nil
+		var failed [][2]int
+		for _, e := range s.cellErrors() {
+			if i, j, err := parseCellName(e.Cell); err == nil {
+				failed = append(failed, [2]int{i, j})
+			}
+		}
+		for _, pos := range dirtyCells(s.resolved, resolved, s.values, failed) {
+			i, j := pos[0], pos[1]
+			if i < len(resolved) && j < len(resolved[i]) {
+				values[i][j] = resolved[i][j]
+			} else if i < len(values) && j < len(values[i]) {
+				// Ranges can spill past the end of rows
+				values[i][j] = Cell{}
+			}
+		}
+	}
+
+	for _, row := range values {
+		for _, cell := range row {
+			if cell.Type == Expression {
+				count++
+			}
+		}
+	}
+
+	s.resolved, s.values, s.err = resolved, values, evalTableWith(values, cache)
+	if cache != nil {
+		count -= cache.hits
+	}
+	return count
+}
+
+// dirtyCells returns the position of every cell that differs between the
+// two resolved tables, or that failed, and of every formula depending on
+// them. The ranges
+// spilled by formulas, as found in the values of prev, are dirty along with
+// their formula, which is dirty too when a cell it spilled over changed,
+// since it might not spill there anymore.
+func dirtyCells(prev, next, values Table, failed [][2]int) [][2]int {
+	changed := append([][2]int(nil), failed...)
+	for i, row := range next {
+		for j, cell := range row {
+			if i >= len(prev) || j >= len(prev[i]) || prev[i][j] != cell {
+				changed = append(changed, [2]int{i, j})
+			}
+		}
+	}
+
+	dependents := dependentsMap(next)
+	for i, row := range values {
+		for j, cell := range row {
+			for r := 0; r < cell.spilled[0]; r++ {
+				for c := 0; c < cell.spilled[1]; c++ {
+					if r == 0 && c == 0 {
+						continue
+					}
+					from, to := [2]int{i, j}, [2]int{i + r, j + c}
+					dependents[from] = append(dependents[from], to)
+					dependents[to] = append(dependents[to], from)
+				}
+			}
+		}
+	}
+	return dependentCells(dependents, changed)
+}
+
+// evaluated tells whether the sheet could be evaluated, even if some of its
+// cells failed and are #ERROR.
+func (s *sheet) evaluated() bool {
+	if _, failed := s.err.(evalErrors); s.err != nil && !failed {
+		return false
+	}
+	return s.values != nil
+}
+
+// cellError is the error of a cell that failed to evaluate.
+type cellError struct {
+	Cell  string `json:"cell"`
+	Error string `json:"error"`
+}
+
+// cellErrors returns the errors of the cells that failed to evaluate, in the
+// order they were evaluated.
+func (s *sheet) cellErrors() []cellError {
+	errs, _ := s.err.(evalErrors)
+	list := make([]cellError, 0, len(errs))
+	for _, err := range errs {
+		if name, msg, ok := strings.Cut(err.Error(), ": "); ok {
+			list = append(list, cellError{name, msg})
+		}
+	}
+	return list
+}
+
+// update replaces the source of a single cell and recalculates the sheet,
+// returning the number of cells evaluated again.
+func (s *sheet) update(name, content string) (int, error) {
+	row, col, err := parseCellName(name)
+	if err != nil {
+		return 0, err
+	}
+	if row >= len(s.source) || col >= len(s.source[row]) {
+		return 0, fmt.Errorf("cell %s out of bounds", name)
+	}
+
+	cell := parseCell(content)
+	if cell.Type == Command {
+		return 0, fmt.Errorf("%s: shell commands can't be set remotely", name)
+	}
+	if cell.Type == Expression && callsFetch(cell.Content) {
+		return 0, fmt.Errorf("%s: requests can't be made by cells set remotely", name)
+	}
+	if cell.Type == Expression {
+		if cell.Content, err = resolveStructuredRefs(s.source, s.regions, cell.Content); err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	s.source[row][col] = cell.withRule(s.source[row][col])
+	count := s.recalc()
+	return count, s.err
+}
+
+type cellResponse struct {
+	Cell         string   `json:"cell"`
+	Source       string   `json:"source"`
+	Content      string   `json:"content"`
+	Type         CellType `json:"type"`
+	Recalculated int      `json:"recalculated,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+func (s *sheet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "" || r.URL.Path == "/" || r.URL.Path == "/table":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveTable(w, r)
+	case r.URL.Path == "/errors":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveErrors(w)
+	case strings.HasPrefix(r.URL.Path, "/cells/"):
+		s.serveCell(w, r, strings.TrimPrefix(r.URL.Path, "/cells/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveTable responds with the evaluated table, the cells that failed being
+// #ERROR, unless the sheet couldn't be evaluated at all.
+func (s *sheet) serveTable(w http.ResponseWriter, r *http.Request) {
+	if !s.evaluated() {
+		http.Error(w, s.err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		renderJSON(w, s.values)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderHTML(w, s.values)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+	}
+}
+
+// serveErrors responds with the errors of the cells that failed.
+func (s *sheet) serveErrors(w http.ResponseWriter) {
+	if !s.evaluated() {
+		http.Error(w, s.err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.cellErrors())
+}
+
+func (s *sheet) serveCell(w http.ResponseWriter, r *http.Request, name string) {
+	row, col, err := parseCellName(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if row >= len(s.source) || col >= len(s.source[row]) {
+		http.Error(w, fmt.Sprintf("cell %s out of bounds", name), http.StatusNotFound)
+		return
+	}
+
+	resp := cellResponse{Cell: name}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		if !s.evaluated() {
+			http.Error(w, s.err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Other cells failing don't make the update fail, the cell's own
+		// error is found by describeCell
+		resp.Recalculated, err = s.update(name, string(body))
+		if _, failed := err.(evalErrors); err != nil && !failed {
+			resp.Error = err.Error()
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.describeCell(&resp, row, col)
+	if r.Method == http.MethodPost && resp.Error != "" {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// describeCell fills in the source of a cell, and its value when the sheet
+// could be evaluated, along with its error if it failed.
+func (s *sheet) describeCell(resp *cellResponse, row, col int) {
+	resp.Source = s.source[row][col].Content
+	if !s.evaluated() {
+		return
+	}
+	resp.Content = s.values[row][col].Content
+	resp.Type = s.values[row][col].Type
+	for _, e := range s.cellErrors() {
+		if e.Cell == cellName(row, col) {
+			resp.Error = e.Error
+		}
+	}
+}
+
+var sheetNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// workspace is a set of named sheets served together. The routes of a single
+// sheet are available under /sheets/{name}/, and at the root for the default
+// sheet, which is the first one loaded from the command line.
+type workspace struct {
+	mu     sync.RWMutex
+	sheets map[string]*sheet
+	def    string
+}
+
+func newWorkspace() *workspace {
+	return &workspace{sheets: make(map[string]*sheet)}
+}
+
+func (ws *workspace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/sheets" || r.URL.Path == "/sheets/" {
+		ws.serveList(w, r)
+		return
+	}
+	if r.URL.Path == "/metrics" {
+		ws.serveMetrics(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/sheets/") {
+		ws.mu.RLock()
+		s := ws.sheets[ws.def]
+		ws.mu.RUnlock()
+		if s == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.ServeHTTP(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/sheets/")
+	rest := "/"
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	if rest == "/" {
+		switch r.Method {
+		case http.MethodPut:
+			ws.servePut(w, r, name)
+			return
+		case http.MethodDelete:
+			ws.mu.Lock()
+			_, ok := ws.sheets[name]
+			delete(ws.sheets, name)
+			ws.mu.Unlock()
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	ws.mu.RLock()
+	s := ws.sheets[name]
+	ws.mu.RUnlock()
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/sheets/"+name, s).ServeHTTP(w, r)
+}
+
+func (ws *workspace) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ws.mu.RLock()
+	names := make([]string, 0, len(ws.sheets))
+	for name := range ws.sheets {
+		names = append(names, name)
+	}
+	ws.mu.RUnlock()
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// servePut creates or replaces a sheet with the source in the request body,
+// responding with its evaluated table. Like the cells set one at a time, the
+// sheet can't read the environment variables of the server, run commands,
+// query databases nor call FETCH.
+func (ws *workspace) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	if !sheetNameRegexp.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid sheet name %q", name), http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 10<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := newSheet(string(body))
+	ws.mu.Lock()
+	_, exists := ws.sheets[name]
+	ws.sheets[name] = s
+	ws.mu.Unlock()
+
+	if s.err != nil {
+		http.Error(w, s.err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	renderJSON(w, s.values)
+}
+
+// serve implements the `serve` command, exposing sheets over HTTP.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address to listen on")
+	withProfiles := fs.Bool("pprof", false, "serve the profiles of the server under /debug/pprof/")
+	fs.Parse(args)
+
+	// Allow flags after the file names too
+	var files []string
+	for fs.NArg() > 0 {
+		files = append(files, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+
+	ws := newWorkspace()
+	for _, file := range files {
+		c, err := readSheet(file)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		s := newCachedSheet(c, nil, true)
+		if s.err != nil {
+			log.Printf("%s: %v", file, s.err)
+		}
+		if ws.def == "" {
+			ws.def = name
+		}
+		ws.sheets[name] = s
+		log.Printf("Loaded %s as /sheets/%s", file, name)
+	}
+
+	var handler http.Handler = ws
+	if *withProfiles {
+		handler = withPprof(ws)
+	}
+	log.Printf("Serving on %s", *listen)
+	return http.ListenAndServe(*listen, handler)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerUpdate(t *testing.T) {
+	s := newSheet("A |B\n1 |2\n3 |4\n=A1+B1|=A2+B2\n=A3+B3|")
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cells/B1", strings.NewReader("10")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp cellResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// Only A3 and A4 depend on B1, B3 must not be evaluated again
+	if resp.Recalculated != 2 {
+		t.Errorf("recalculated %d cells, want 2", resp.Recalculated)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cells/A4", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "18.00" || resp.Source != "=A3+B3" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestServerUpdateSpill(t *testing.T) {
+	s := newSheet("A|B\n1|2\n=TRANSPOSE(A1:B1)|=A2*10\n|=A3*10")
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+
+	// The range spilled before is cleared, and the cells using it evaluated
+	// again
+	if _, err := s.update("B1", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tableContents(s.values[2:]), "1.00|10.00\n5.00|50.00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A range can't spill over a cell set since then
+	if _, err := s.update("A3", "7"); err == nil || err.Error() != "A2: range spills over A3, which isn't empty" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestServerCellErrors(t *testing.T) {
+	s := newSheet("A|B|C\n1|x|=A1*2\n2|=B1*2|=A2*2")
+	if len(s.cellErrors()) != 1 {
+		t.Fatalf("got errors %v", s.err)
+	}
+
+	// The table is served anyway, with the cell that failed as #ERROR
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/table", nil))
+	var table Table
+	if err := json.NewDecoder(rec.Body).Decode(&table); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", rec.Code, err)
+	}
+	if got, want := tableContents(table[1:]), "1.00|x|2.00\n2.00|#ERROR|4.00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/errors", nil))
+	if got, want := strings.TrimSpace(rec.Body.String()), `[{"cell":"B2","error":"text cell B1 should not be used inside expressions"}]`; got != want {
+		t.Errorf("got errors %s, want %s", got, want)
+	}
+
+	var resp cellResponse
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cells/C2", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Content != "4.00" || resp.Error != "" {
+		t.Errorf("got %+v, %v", resp, err)
+	}
+
+	// Updating another cell only evaluates what depends on it, and the cell
+	// that failed, which still does
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cells/A2", strings.NewReader("5")))
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", rec.Code, err)
+	}
+	if resp.Content != "5.00" || resp.Recalculated != 2 {
+		t.Errorf("got %+v", resp)
+	}
+	if errs := s.cellErrors(); len(errs) != 1 || errs[0].Cell != "B2" {
+		t.Errorf("got errors %v", errs)
+	}
+
+	// Fixing it makes the error go away
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cells/B1", strings.NewReader("3")))
+	if rec.Code != http.StatusOK || s.err != nil {
+		t.Errorf("got status %d, %v", rec.Code, s.err)
+	}
+	if got := s.values[2][1].Content; got != "6.00" {
+		t.Errorf("B2 = %q, want 6.00", got)
+	}
+
+	// while a cell failing after an update makes it fail
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cells/C1", strings.NewReader("=1+B0")))
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusUnprocessableEntity || resp.Error == "" {
+		t.Errorf("got status %d, %+v", rec.Code, resp)
+	}
+}
+
+func TestServerTable(t *testing.T) {
+	s := newSheet("A|B\n1|=A1*2")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/table", nil))
+
+	var table Table
+	if err := json.NewDecoder(rec.Body).Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 2 || table[1][1].Content != "2.00" {
+		t.Errorf("got %+v", table)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/table?format=html", nil))
+	if !strings.Contains(rec.Body.String(), `<td class="number">2.00</td>`) {
+		t.Errorf("got %s", rec.Body)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	ws := newWorkspace()
+
+	rec := httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/sheets/sum", strings.NewReader("1|2\n=A0+B0|")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sheets/sum/cells/B0", strings.NewReader("5")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sheets/sum", nil))
+	var table Table
+	if err := json.NewDecoder(rec.Body).Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+	if table[1][0].Content != "6.00" {
+		t.Errorf("got %+v", table)
+	}
+
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sheets", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `["sum"]` {
+		t.Errorf("got sheets %s", got)
+	}
+
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/sheets/sum", nil))
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sheets/sum", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d after deletion", rec.Code)
+	}
+}
+
+func TestWorkspaceUploads(t *testing.T) {
+	t.Setenv("SECRET_TOKEN", "hunter2")
+	*allowExecFlag, *allowDBFlag, *allowNetFlag = true, true, true
+	defer func() { *allowExecFlag, *allowDBFlag, *allowNetFlag = false, false, false }()
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+	fetch := `=FETCH("` + internal.URL + `/internal.txt")`
+
+	ws := newWorkspace()
+	for source, want := range map[string]string{
+		"${SECRET_TOKEN}":                                 "A0: undefined parameter SECRET_TOKEN, environment variables can't be read by this sheet",
+		"1|!echo pwned-$(id -u)":                          "B0: shell commands can't be run by this sheet",
+		`=DBQUERY("postgres://localhost/db", "SELECT 1")`: "A0: databases can't be queried by this sheet",
+		"1|=LEN(" + fetch[1:] + ")":                       "B0: requests can't be made by this sheet",
+	} {
+		rec := httptest.NewRecorder()
+		ws.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/sheets/upload", strings.NewReader(source)))
+		if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusUnprocessableEntity || got != want {
+			t.Errorf("uploading %q: got status %d: %s, want %q", source, rec.Code, got, want)
+		}
+	}
+
+	// Cells set remotely can't make requests either, even inside local sheets
+	ws.sheets["local"] = newCachedSheet("1|2", nil, true)
+	rec := httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sheets/local/cells/B0", strings.NewReader(fetch)))
+	if want := "B0: requests can't be made by cells set remotely"; rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("got status %d: %s, want %q", rec.Code, rec.Body, want)
+	}
+	if got := ws.sheets["local"].source[0][1].Content; got != "2.00" {
+		t.Errorf("B0 = %q, want it left as it was", got)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	ws := newWorkspace()
+	ws.sheets["sum"] = newSheet("A|B\n1|=A1*2")
+	ws.sheets["broken"] = newSheet("=Z9")
+
+	rec := httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE minicel_recalculations_total counter\n",
+		"# TYPE minicel_recalculation_duration_seconds histogram\n",
+		"minicel_recalculation_duration_seconds_bucket{le=\"+Inf\"} ",
+		"minicel_cells{sheet=\"broken\"} 1\n",
+		"minicel_cells{sheet=\"sum\"} 4\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "minicel_errors_total 0\n") {
+		t.Errorf("expected the error of the broken sheet to be counted:\n%s", body)
+	}
+}
+
+func TestServerPprof(t *testing.T) {
+	handler := withPprof(newSheet("A|B\n1|=A1*2"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cells/B1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got %d %s for a cell", rec.Code, rec.Body)
+	}
+}
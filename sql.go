@@ -0,0 +1,717 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The sql command runs a small subset of SQL over an evaluated table:
+//
+//	SELECT item, ... FROM t [WHERE cond] [GROUP BY col, ...]
+//	       [ORDER BY item [ASC|DESC], ...] [LIMIT n]
+//
+// where items are columns, `*`, or the aggregates COUNT, SUM, AVG, MIN and
+// MAX. Columns are referred to by their letter or by their header, which is
+// the first row of the table unless it has none.
+
+type sqlTokenKind int
+
+const (
+	sqlEOF sqlTokenKind = iota
+	sqlIdent
+	sqlNumber
+	sqlString
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func tokenizeSQL(query string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(query); {
+		c := rune(query[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(query) && (unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j])) || query[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlIdent, query[i:j]})
+			i = j
+		case unicode.IsDigit(c) || c == '.' || c == '-' && i+1 < len(query) && unicode.IsDigit(rune(query[i+1])):
+			j := i + 1
+			for j < len(query) && (unicode.IsDigit(rune(query[j])) || query[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlNumber, query[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			// Single quotes delimit strings, double quotes identifiers
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == query[i] {
+					if j+1 < len(query) && query[j+1] == query[i] {
+						sb.WriteByte(query[j])
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteByte(query[j])
+			}
+			if j >= len(query) {
+				return nil, fmt.Errorf("unterminated %c", c)
+			}
+			kind := sqlString
+			if c == '"' {
+				kind = sqlIdent
+			}
+			tokens = append(tokens, sqlToken{kind, sb.String()})
+			i = j + 1
+		default:
+			matched := false
+			for _, sym := range []string{"<=", ">=", "<>", "!=", "=", "<", ">", "(", ")", ",", "*"} {
+				if strings.HasPrefix(query[i:], sym) {
+					tokens = append(tokens, sqlToken{sqlSymbol, sym})
+					i += len(sym)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, sqlToken{kind: sqlEOF}), nil
+}
+
+type sqlItem struct {
+	agg   string // empty for plain columns
+	col   int    // -1 for `*`
+	label string
+}
+
+// sqlOrder refers to a column of the result either by its 1-based position
+// or by its label.
+type sqlOrder struct {
+	pos   int
+	label string
+	desc  bool
+}
+
+// sqlCond is a node of a WHERE condition: either a logical operator over
+// its children or a comparison between two operands.
+type sqlCond struct {
+	op          string
+	left, right *sqlCond
+	// Operands
+	col   int
+	value string
+}
+
+type sqlQuery struct {
+	items   []sqlItem
+	where   *sqlCond
+	groupBy []int
+	orderBy []sqlOrder
+	limit   int
+}
+
+type sqlParser struct {
+	tokens  []sqlToken
+	pos     int
+	headers []string
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != sqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sqlParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == sqlIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == sqlSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expect(kw string) error {
+	if !p.keyword(kw) && !p.symbol(kw) {
+		return fmt.Errorf("expected %s, got %q", kw, p.peek().text)
+	}
+	return nil
+}
+
+// column resolves a column name, either a header or a letter.
+func (p *sqlParser) column() (int, error) {
+	t := p.next()
+	if t.kind != sqlIdent {
+		return 0, fmt.Errorf("expected a column, got %q", t.text)
+	}
+	for j, h := range p.headers {
+		if strings.EqualFold(h, t.text) {
+			return j, nil
+		}
+	}
+	if len(t.text) == 1 && unicode.IsLetter(rune(t.text[0])) {
+		return int(unicode.ToUpper(rune(t.text[0])) - 'A'), nil
+	}
+	return 0, fmt.Errorf("unknown column %q", t.text)
+}
+
+func (p *sqlParser) parse() (*sqlQuery, error) {
+	q := &sqlQuery{limit: -1}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+
+	for {
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+		if !p.symbol(",") {
+			break
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	if t := p.next(); t.kind != sqlIdent {
+		return nil, fmt.Errorf("expected a table name, got %q", t.text)
+	}
+
+	if p.keyword("WHERE") {
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		q.where = cond
+	}
+
+	if p.keyword("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.groupBy = append(q.groupBy, col)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			order, err := p.order()
+			if err != nil {
+				return nil, err
+			}
+			q.orderBy = append(q.orderBy, order)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != sqlNumber || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit %q", t.text)
+		}
+		q.limit = n
+	}
+
+	if t := p.peek(); t.kind != sqlEOF {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *sqlParser) item() (sqlItem, error) {
+	var item sqlItem
+	if p.symbol("*") {
+		item = sqlItem{col: -1, label: "*"}
+	} else if t := p.peek(); t.kind == sqlIdent && p.tokens[p.pos+1].text == "(" {
+		item.agg = strings.ToUpper(t.text)
+		switch item.agg {
+		case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		default:
+			return item, fmt.Errorf("unknown aggregate %s", t.text)
+		}
+		p.pos += 2
+
+		arg := "*"
+		if p.symbol("*") {
+			if item.agg != "COUNT" {
+				return item, fmt.Errorf("%s(*) is not allowed", item.agg)
+			}
+			item.col = -1
+		} else {
+			arg = p.peek().text
+			col, err := p.column()
+			if err != nil {
+				return item, err
+			}
+			item.col = col
+		}
+		if err := p.expect(")"); err != nil {
+			return item, err
+		}
+		item.label = fmt.Sprintf("%s(%s)", item.agg, arg)
+	} else {
+		item.label = t.text
+		col, err := p.column()
+		if err != nil {
+			return item, err
+		}
+		item.col = col
+	}
+
+	if p.keyword("AS") {
+		t := p.next()
+		if t.kind != sqlIdent {
+			return item, fmt.Errorf("expected an alias, got %q", t.text)
+		}
+		item.label = t.text
+	}
+	return item, nil
+}
+
+func (p *sqlParser) order() (sqlOrder, error) {
+	var order sqlOrder
+	t := p.next()
+	if n, err := strconv.Atoi(t.text); t.kind == sqlNumber && err == nil {
+		order.pos = n
+	} else {
+		// Aggregates are matched by their label, like `SUM(C)`
+		order.label = t.text
+		if p.symbol("(") {
+			order.label = strings.ToUpper(order.label) + "(" + p.next().text
+			if err := p.expect(")"); err != nil {
+				return order, err
+			}
+			order.label += ")"
+		}
+	}
+
+	if p.keyword("DESC") {
+		order.desc = true
+	} else {
+		p.keyword("ASC")
+	}
+	return order, nil
+}
+
+func (p *sqlParser) or() (*sqlCond, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlCond{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) and() (*sqlCond, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlCond{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) not() (*sqlCond, error) {
+	if p.keyword("NOT") {
+		cond, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlCond{op: "NOT", left: cond}, nil
+	}
+	if p.symbol("(") {
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.next()
+	switch t.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected a comparison, got %q", t.text)
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlCond{op: t.text, left: left, right: right}, nil
+}
+
+func (p *sqlParser) operand() (*sqlCond, error) {
+	switch t := p.peek(); t.kind {
+	case sqlNumber, sqlString:
+		p.pos++
+		return &sqlCond{col: -1, value: t.text}, nil
+	default:
+		col, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlCond{col: col}, nil
+	}
+}
+
+func cellValue(row []Cell, col int) string {
+	if col < len(row) {
+		return row[col].Content
+	}
+	return ""
+}
+
+// compareValues compares two values numerically when both are numbers, and
+// as strings otherwise.
+func compareValues(a, b string) int {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func (c *sqlCond) match(row []Cell) bool {
+	switch c.op {
+	case "AND":
+		return c.left.match(row) && c.right.match(row)
+	case "OR":
+		return c.left.match(row) || c.right.match(row)
+	case "NOT":
+		return !c.left.match(row)
+	}
+
+	operand := func(o *sqlCond) string {
+		if o.col >= 0 {
+			return cellValue(row, o.col)
+		}
+		return o.value
+	}
+	cmp := compareValues(operand(c.left), operand(c.right))
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=", "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func aggregate(agg string, col int, rows [][]Cell) (string, error) {
+	if agg == "COUNT" {
+		count := 0
+		for _, row := range rows {
+			if col < 0 || cellValue(row, col) != "" {
+				count++
+			}
+		}
+		return strconv.Itoa(count), nil
+	}
+
+	var values []float64
+	for _, row := range rows {
+		v := cellValue(row, col)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s over non numeric value %q", agg, v)
+		}
+		values = append(values, f)
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	result := values[0]
+	switch agg {
+	case "SUM", "AVG":
+		result = 0
+		for _, v := range values {
+			result += v
+		}
+		if agg == "AVG" {
+			result /= float64(len(values))
+		}
+	case "MIN":
+		for _, v := range values {
+			result = math.Min(result, v)
+		}
+	case "MAX":
+		for _, v := range values {
+			result = math.Max(result, v)
+		}
+	}
+	return formatNumber(result), nil
+}
+
+// runSQL runs the query over the evaluated table. When header is true the
+// first row of the table names its columns instead of being part of the data.
+func runSQL(query string, table Table, header bool) (Table, error) {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := sqlParser{tokens: tokens}
+	rows := [][]Cell(table)
+	if header && len(rows) > 0 {
+		for _, cell := range rows[0] {
+			p.headers = append(p.headers, cell.Content)
+		}
+		rows = rows[1:]
+	}
+	q, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	width := 0
+	for _, row := range table {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	var filtered [][]Cell
+	for _, row := range rows {
+		if q.where == nil || q.where.match(row) {
+			filtered = append(filtered, row)
+		}
+	}
+
+	aggregated := len(q.groupBy) > 0
+	for _, item := range q.items {
+		if item.agg != "" {
+			aggregated = true
+		}
+	}
+
+	// Every group is a set of rows sharing the same GROUP BY values, without
+	// grouping every row makes a group of its own unless there are aggregates
+	var groups [][][]Cell
+	if aggregated {
+		index := make(map[string]int)
+		if len(q.groupBy) == 0 {
+			groups = append(groups, filtered)
+		}
+		for _, row := range filtered {
+			if len(q.groupBy) == 0 {
+				break
+			}
+			var key []string
+			for _, col := range q.groupBy {
+				key = append(key, cellValue(row, col))
+			}
+			k := strings.Join(key, "\x00")
+			if i, ok := index[k]; ok {
+				groups[i] = append(groups[i], row)
+			} else {
+				index[k] = len(groups)
+				groups = append(groups, [][]Cell{row})
+			}
+		}
+	} else {
+		for _, row := range filtered {
+			groups = append(groups, [][]Cell{row})
+		}
+	}
+
+	var headerRow []Cell
+	for _, item := range q.items {
+		if item.col < 0 && item.agg == "" {
+			for j := 0; j < width; j++ {
+				name := cellName(0, j)[:1]
+				if p.headers != nil && j < len(p.headers) {
+					name = p.headers[j]
+				}
+				headerRow = append(headerRow, Cell{Content: name, Type: Text})
+			}
+			continue
+		}
+		headerRow = append(headerRow, Cell{Content: item.label, Type: Text})
+	}
+
+	var result Table
+	for _, group := range groups {
+		var row []Cell
+		for _, item := range q.items {
+			switch {
+			case item.agg != "":
+				v, err := aggregate(item.agg, item.col, group)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, parseCell(v))
+			case item.col < 0:
+				if aggregated {
+					return nil, fmt.Errorf("* cannot be used along with aggregates")
+				}
+				for j := 0; j < width; j++ {
+					row = append(row, parseCell(cellValue(group[0], j)))
+				}
+			default:
+				if aggregated && !containsInt(q.groupBy, item.col) {
+					return nil, fmt.Errorf("%s must appear in GROUP BY or be aggregated", item.label)
+				}
+				if len(group) == 0 {
+					row = append(row, Cell{})
+				} else {
+					row = append(row, parseCell(cellValue(group[0], item.col)))
+				}
+			}
+		}
+		result = append(result, row)
+	}
+
+	var columns []int
+	for _, order := range q.orderBy {
+		col := order.pos - 1
+		if order.label != "" {
+			for j, cell := range headerRow {
+				if strings.EqualFold(cell.Content, order.label) {
+					col = j
+				}
+			}
+		}
+		if col < 0 || col >= len(headerRow) {
+			if order.label != "" {
+				return nil, fmt.Errorf("ORDER BY %s must be one of the selected columns", order.label)
+			}
+			return nil, fmt.Errorf("ORDER BY position %d out of range", order.pos)
+		}
+		columns = append(columns, col)
+	}
+	sort.SliceStable(result, func(a, b int) bool {
+		for k, order := range q.orderBy {
+			cmp := compareValues(result[a][columns[k]].Content, result[b][columns[k]].Content)
+			if cmp == 0 {
+				continue
+			}
+			return cmp < 0 != order.desc
+		}
+		return false
+	})
+	if q.limit >= 0 && q.limit < len(result) {
+		result = result[:q.limit]
+	}
+
+	return append(Table{headerRow}, result...), nil
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlCommand implements the `sql` command.
+func sqlCommand(args []string) error {
+	fs := flag.NewFlagSet("sql", flag.ExitOnError)
+	noHeader := fs.Bool("no-header", false, "the first row of the table is data, not the name of its columns")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: minicel sql [-no-header] query sheet")
+	}
+
+	c, err := readSheet(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	table, err := loadSheet(c)
+	if err != nil {
+		return err
+	}
+	if err := evalTable(table); err != nil {
+		return err
+	}
+
+	result, err := runSQL(fs.Arg(0), table, !*noHeader)
+	if err != nil {
+		return err
+	}
+	dumpTable(os.Stdout, localizeTable(result))
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunSQL(t *testing.T) {
+	table := parseTable("City|Sales\nRome|10\nMilan|5\nRome|7\nTurin|1\nMilan|2")
+
+	tests := []struct {
+		query  string
+		header bool
+		want   string
+	}{
+		{
+			"SELECT City, SUM(Sales), COUNT(*) FROM t GROUP BY City ORDER BY 2 DESC",
+			true,
+			"City|SUM(Sales)|COUNT(*)\nRome|17.00|2.00\nMilan|7.00|2.00\nTurin|1.00|1.00\n",
+		},
+		{
+			"select A, B from t where B >= 5 and not A = 'Milan' limit 1",
+			true,
+			"A|B\nRome|10.00\n",
+		},
+		{
+			"SELECT * FROM t WHERE B < 5 ORDER BY B",
+			false,
+			"A|B\nTurin|1.00\nMilan|2.00\n",
+		},
+		{
+			// Sums leave out the header, even when columns are named by letter
+			"SELECT A, SUM(B) FROM t GROUP BY A",
+			true,
+			"A|SUM(B)\nRome|17.00\nMilan|7.00\nTurin|1.00\n",
+		},
+		{
+			"SELECT MAX(B) AS best FROM sheet",
+			true,
+			"best\n10.00\n",
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := runSQL(tt.query, table, tt.header)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		for _, row := range result {
+			for j, cell := range row {
+				if j > 0 {
+					buf.WriteByte('|')
+				}
+				buf.WriteString(cell.Content)
+			}
+			buf.WriteByte('\n')
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s:\ngot\n%swant\n%s", tt.query, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestRunSQLErrors(t *testing.T) {
+	table := parseTable("A|B\n1|2")
+	for _, query := range []string{
+		"SELECT A, SUM(B) FROM t",
+		"SELECT Z1 FROM t",
+		"SELECT A FROM t WHERE",
+		"SELECT MEDIAN(A) FROM t",
+		"SELECT A FROM t ORDER BY B",
+	} {
+		if _, err := runSQL(query, table, false); err == nil {
+			t.Errorf("%s should fail", query)
+		}
+	}
+}
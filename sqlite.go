@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const sqlitePageSize = 4096
+
+// writeSQLite writes the table as a SQLite database holding a single table
+// called name, with the columns named after the header and typed from the
+// cells below it, like renderParquet: numbers are REAL, TRUE and FALSE are
+// INTEGER 1 and 0, and anything else is TEXT, dates written the way SQLite's
+// date functions expect. Empty cells are NULL.
+//
+// The file is written page by page following the SQLite file format, every
+// table as a b-tree whose leaves hold the rows in order, so no driver is
+// needed.
+func writeSQLite(w io.WriterAt, table Table, name string) error {
+	if len(table) == 0 {
+		return fmt.Errorf("sqlite: the table has no header")
+	}
+	names := columnNames(table)
+	types := make([]string, len(names))
+	timed := make([]bool, len(names))
+	defs := make([]string, len(names))
+	for j, col := range names {
+		types[j], timed[j] = inferColumn(table, j)
+		typ := "TEXT"
+		switch types[j] {
+		case "number":
+			typ = "REAL"
+		case "bool":
+			typ = "INTEGER"
+		}
+		defs[j] = sqliteQuote(col) + " " + typ
+	}
+
+	// The first page holds the header of the file and the schema, written
+	// last since it points to the root of the table
+	db := &sqliteWriter{w: w}
+	db.alloc()
+	tree := db.tree()
+	for i, row := range table[1:] {
+		values := make([]interface{}, len(names))
+		for j := range values {
+			if j >= len(row) || row[j].Type == Empty {
+				continue
+			}
+			content := row[j].Content
+			switch types[j] {
+			case "number":
+				values[j], _ = parseNumber(content)
+			case "bool":
+				values[j] = int64(0)
+				if strings.ToUpper(content) == "TRUE" {
+					values[j] = int64(1)
+				}
+			case "date":
+				_, date := parseDate(content)
+				values[j] = date.Format("2006-01-02")
+				if timed[j] {
+					values[j] = date.Format("2006-01-02 15:04:05")
+				}
+			default:
+				values[j] = content
+			}
+		}
+		if err := tree.add(int64(i+1), sqliteRecord(values)); err != nil {
+			return err
+		}
+	}
+	root, err := tree.close()
+	if err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("CREATE TABLE %s (%s)", sqliteQuote(name), strings.Join(defs, ", "))
+	schema, err := db.cell(1, sqliteRecord([]interface{}{"table", name, name, int64(root), sql}))
+	if err != nil {
+		return err
+	}
+	if 100+8+2+len(schema) > sqlitePageSize {
+		return fmt.Errorf("sqlite: too many columns")
+	}
+
+	page := make([]byte, sqlitePageSize)
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], sqlitePageSize)
+	page[18], page[19] = 1, 1 // legacy journal
+	page[21], page[22], page[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(page[24:], 1) // change counter
+	binary.BigEndian.PutUint32(page[28:], db.pages)
+	binary.BigEndian.PutUint32(page[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(page[44:], 4) // schema format
+	binary.BigEndian.PutUint32(page[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(page[92:], 1)
+	binary.BigEndian.PutUint32(page[96:], 3031001)
+	writeLeaf(page[100:], sqlitePageSize-100, [][]byte{schema})
+	return db.write(1, page)
+}
+
+// sqliteQuote quotes an identifier of SQLite.
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteWriter writes the pages of a SQLite database, numbered from 1.
+type sqliteWriter struct {
+	w     io.WriterAt
+	pages uint32
+}
+
+func (db *sqliteWriter) alloc() uint32 {
+	db.pages++
+	return db.pages
+}
+
+func (db *sqliteWriter) write(n uint32, page []byte) error {
+	_, err := db.w.WriteAt(page, int64(n-1)*sqlitePageSize)
+	return err
+}
+
+// cell returns the cell of a table leaf holding the record of the row,
+// writing the part of the record too big for a page to overflow pages.
+func (db *sqliteWriter) cell(rowid int64, record []byte) ([]byte, error) {
+	cell := appendSQLiteVarint(nil, uint64(len(record)))
+	cell = appendSQLiteVarint(cell, uint64(rowid))
+
+	// How much of the record stays in the leaf is fixed by the file format
+	const usable = sqlitePageSize
+	max, min := usable-35, (usable-12)*32/255-23
+	local := len(record)
+	if local > max {
+		local = min + (len(record)-min)%(usable-4)
+		if local > max {
+			local = min
+		}
+	}
+	cell = append(cell, record[:local]...)
+	if local == len(record) {
+		return cell, nil
+	}
+
+	rest := record[local:]
+	next := db.alloc()
+	cell = appendBigUint32(cell, next)
+	for len(rest) > 0 {
+		n := next
+		page := make([]byte, sqlitePageSize)
+		k := copy(page[4:], rest)
+		if rest = rest[k:]; len(rest) > 0 {
+			next = db.alloc()
+			binary.BigEndian.PutUint32(page, next)
+		}
+		if err := db.write(n, page); err != nil {
+			return nil, err
+		}
+	}
+	return cell, nil
+}
+
+// sqliteTree builds the b-tree of a table from its rows, in order of rowid.
+type sqliteTree struct {
+	db    *sqliteWriter
+	cells [][]byte
+	size  int
+	last  int64
+
+	// children are the pages of the leaves written so far, along with the
+	// largest rowid in each
+	children []sqliteChild
+}
+
+type sqliteChild struct {
+	page  uint32
+	rowid int64
+}
+
+func (db *sqliteWriter) tree() *sqliteTree {
+	return &sqliteTree{db: db, size: 8}
+}
+
+// add adds the row to the current leaf, writing it out when full.
+func (t *sqliteTree) add(rowid int64, record []byte) error {
+	cell, err := t.db.cell(rowid, record)
+	if err != nil {
+		return err
+	}
+	if t.size+2+len(cell) > sqlitePageSize {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.cells = append(t.cells, cell)
+	t.size += 2 + len(cell)
+	t.last = rowid
+	return nil
+}
+
+func (t *sqliteTree) flush() error {
+	n := t.db.alloc()
+	page := make([]byte, sqlitePageSize)
+	writeLeaf(page, sqlitePageSize, t.cells)
+	t.children = append(t.children, sqliteChild{n, t.last})
+	t.cells, t.size = nil, 8
+	return t.db.write(n, page)
+}
+
+// close writes the last leaf and the interior pages above the leaves,
+// returning the page of the root.
+func (t *sqliteTree) close() (uint32, error) {
+	if len(t.cells) > 0 || len(t.children) == 0 {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Every child but the last one of an interior page gets a cell with its
+	// largest rowid, the last one is the right-most pointer. Children are
+	// spread evenly so that every page has at least two.
+	const fanout = (sqlitePageSize-12)/(2+4+9) + 1
+	children := t.children
+	for len(children) > 1 {
+		pages := (len(children) + fanout - 1) / fanout
+		var parents []sqliteChild
+		for p := 0; p < pages; p++ {
+			group := children[p*len(children)/pages : (p+1)*len(children)/pages]
+			page := make([]byte, sqlitePageSize)
+			page[0] = 0x05
+			binary.BigEndian.PutUint16(page[3:], uint16(len(group)-1))
+			end := sqlitePageSize
+			for c, child := range group[:len(group)-1] {
+				cell := appendBigUint32(nil, child.page)
+				cell = appendSQLiteVarint(cell, uint64(child.rowid))
+				end -= len(cell)
+				copy(page[end:], cell)
+				binary.BigEndian.PutUint16(page[12+2*c:], uint16(end))
+			}
+			binary.BigEndian.PutUint16(page[5:], uint16(end))
+			last := group[len(group)-1]
+			binary.BigEndian.PutUint32(page[8:], last.page)
+
+			n := t.db.alloc()
+			if err := t.db.write(n, page); err != nil {
+				return 0, err
+			}
+			parents = append(parents, sqliteChild{n, last.rowid})
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// writeLeaf writes the cells into page as a table leaf, size bytes long.
+func writeLeaf(page []byte, size int, cells [][]byte) {
+	page[0] = 0x0d
+	binary.BigEndian.PutUint16(page[3:], uint16(len(cells)))
+	end := size
+	for c, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[8+2*c:], uint16(end+sqlitePageSize-size))
+	}
+	binary.BigEndian.PutUint16(page[5:], uint16(end+sqlitePageSize-size))
+}
+
+// sqliteRecord encodes the values of a row, which can be nil, int64,
+// float64 or string, in the record format of SQLite.
+func sqliteRecord(values []interface{}) []byte {
+	var types, body []byte
+	for _, value := range values {
+		switch value := value.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case int64:
+			if value == 0 || value == 1 {
+				types = appendSQLiteVarint(types, uint64(8+value))
+				continue
+			}
+			types = appendSQLiteVarint(types, 6)
+			body = appendBigUint64(body, uint64(value))
+		case float64:
+			types = appendSQLiteVarint(types, 7)
+			body = appendBigUint64(body, math.Float64bits(value))
+		case string:
+			types = appendSQLiteVarint(types, uint64(2*len(value)+13))
+			body = append(body, value...)
+		}
+	}
+
+	// The size of the header counts itself
+	size := len(types) + 1
+	for len(appendSQLiteVarint(nil, uint64(size)))+len(types) != size {
+		size = len(appendSQLiteVarint(nil, uint64(size))) + len(types)
+	}
+	record := appendSQLiteVarint(nil, uint64(size))
+	record = append(record, types...)
+	return append(record, body...)
+}
+
+// appendSQLiteVarint appends v as a varint of SQLite, big-endian unlike the
+// ones of encoding/binary. v must be below 1<<56.
+func appendSQLiteVarint(b []byte, v uint64) []byte {
+	var buf [8]byte
+	n := len(buf)
+	for {
+		n--
+		buf[n] = byte(v & 0x7f)
+		if n < len(buf)-1 {
+			buf[n] |= 0x80
+		}
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	return append(b, buf[n:]...)
+}
+
+func appendBigUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendBigUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
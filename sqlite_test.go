@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	// Enough rows for interior pages, and a cell too big for a leaf
+	var sb strings.Builder
+	sb.WriteString("Item|Qty|Paid|Day\n")
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&sb, "item %d|%d|TRUE|2024-03-01\n", i, i)
+	}
+	long := strings.Repeat("abcde", 3000)
+	sb.WriteString(long + "|=B1*3||2024-03-02 10:30")
+	table := parseTable(sb.String())
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSQLite(f, table, "results"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(db), "SQLite format 3\x00") {
+		t.Fatalf("no header in %q", db[:16])
+	}
+	if pages := binary.BigEndian.Uint32(db[28:]); int(pages)*sqlitePageSize != len(db) {
+		t.Errorf("header counts %d pages, the file has %d bytes", pages, len(db))
+	}
+	schema := readSQLiteTree(db, 1)
+	if len(schema) != 1 {
+		t.Fatalf("got %d tables, want 1", len(schema))
+	}
+	want := `CREATE TABLE "results" ("Item" TEXT, "Qty" REAL, "Paid" INTEGER, "Day" TEXT)`
+	if sql := schema[0][4]; sql != want {
+		t.Errorf("got %q, want %q", sql, want)
+	}
+
+	rows := readSQLiteTree(db, uint32(schema[0][3].(int64)))
+	if len(rows) != 2001 {
+		t.Fatalf("got %d rows, want 2001", len(rows))
+	}
+	if got, want := rows[41], []interface{}{"item 42", 42.0, int64(1), "2024-03-01 00:00:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rows[2000], []interface{}{long, 3.0, nil, "2024-03-02 10:30:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %.40v, want %.40v", got, want)
+	}
+}
+
+// readSQLiteTree reads the records of the table whose b-tree has its root at
+// page n of the database, in order.
+func readSQLiteTree(db []byte, n uint32) [][]interface{} {
+	page := db[(n-1)*sqlitePageSize : n*sqlitePageSize]
+	header := page
+	if n == 1 {
+		header = page[100:]
+	}
+	cells := int(binary.BigEndian.Uint16(header[3:]))
+
+	var rows [][]interface{}
+	if header[0] == 0x05 {
+		for c := 0; c < cells; c++ {
+			offset := binary.BigEndian.Uint16(header[12+2*c:])
+			rows = append(rows, readSQLiteTree(db, binary.BigEndian.Uint32(page[offset:]))...)
+		}
+		return append(rows, readSQLiteTree(db, binary.BigEndian.Uint32(header[8:]))...)
+	}
+
+	for c := 0; c < cells; c++ {
+		cell := page[binary.BigEndian.Uint16(header[8+2*c:]):]
+		size, k := readSQLiteVarint(cell)
+		cell = cell[k:]
+		_, k = readSQLiteVarint(cell)
+		cell = cell[k:]
+
+		// The record overflows when it's longer than what's left of the page
+		record := cell
+		if int(size) > len(cell) {
+			local := (sqlitePageSize-12)*32/255 - 23 + (int(size)-((sqlitePageSize-12)*32/255-23))%(sqlitePageSize-4)
+			if local > sqlitePageSize-35 {
+				local = (sqlitePageSize-12)*32/255 - 23
+			}
+			record = append([]byte(nil), cell[:local]...)
+			next := binary.BigEndian.Uint32(cell[local:])
+			for next != 0 {
+				overflow := db[(next-1)*sqlitePageSize : next*sqlitePageSize]
+				record = append(record, overflow[4:]...)
+				next = binary.BigEndian.Uint32(overflow)
+			}
+		}
+		rows = append(rows, readSQLiteRecord(record[:size]))
+	}
+	return rows
+}
+
+func readSQLiteRecord(record []byte) []interface{} {
+	size, k := readSQLiteVarint(record)
+	types := record[k:size]
+	body := record[size:]
+	var values []interface{}
+	for len(types) > 0 {
+		typ, k := readSQLiteVarint(types)
+		types = types[k:]
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ == 8 || typ == 9:
+			values = append(values, int64(typ-8))
+		case typ == 6:
+			values = append(values, int64(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case typ == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case typ >= 13 && typ%2 == 1:
+			n := (typ - 13) / 2
+			values = append(values, string(body[:n]))
+			body = body[n:]
+		}
+	}
+	return values
+}
+
+func readSQLiteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for k := 0; k < 8; k++ {
+		v = v<<7 | uint64(b[k]&0x7f)
+		if b[k] < 0x80 {
+			return v, k + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
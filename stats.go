@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+func init() {
+	RegisterFunc("SUM", sum)
+	RegisterFunc("AVERAGE", average)
+	RegisterAlias("AVG", "AVERAGE")
+	RegisterFunc("MEDIAN", median)
+	RegisterFunc("VAR", variance(1))
+	RegisterFunc("VARP", variance(0))
+	RegisterFunc("STDEV", stdev(1))
+	RegisterFunc("STDEVP", stdev(0))
+	RegisterFunc("PRODUCT", product)
+	RegisterFunc("GEOMEAN", geomean)
+	RegisterFunc("COUNTA", counta)
+	RegisterFunc("COUNTBLANK", countblank)
+	RegisterFunc("SUMPRODUCT", sumproduct)
+	RegisterFunc("MIN", extreme(-1))
+	RegisterFunc("MAX", extreme(1))
+	RegisterFunc("COUNT", count)
+}
+
+// numbers flattens the arguments of an aggregate function into numbers.
+// Like spreadsheets do, empty cells and texts inside ranges are skipped,
+// while any other argument must be a number.
+func numbers(args []Value) ([]float64, error) {
+	var nums []float64
+	for _, arg := range args {
+		if arg.Kind() != ArrayKind {
+			n, err := arg.Number()
+			if err != nil {
+				return nil, err
+			}
+			nums = append(nums, n)
+			continue
+		}
+
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if v.Kind() == NumberKind {
+					nums = append(nums, v.num)
+				}
+			}
+		}
+	}
+	return nums, nil
+}
+
+func average(args ...Value) (Value, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(nums) == 0 {
+		return ErrorValue(errDivZero), nil
+	}
+
+	s := 0.0
+	for _, n := range nums {
+		s += n
+	}
+	return NumberValue(s / float64(len(nums))), nil
+}
+
+func median(args ...Value) (Value, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(nums) == 0 {
+		return Value{}, fmt.Errorf("no numbers")
+	}
+
+	sort.Float64s(nums)
+	if n := len(nums); n%2 == 0 {
+		return NumberValue((nums[n/2-1] + nums[n/2]) / 2), nil
+	}
+	return NumberValue(nums[len(nums)/2]), nil
+}
+
+// variance returns the function computing the variance of a sample, when
+// ddof (the delta degrees of freedom) is 1, or of a whole population, when
+// it's 0.
+func variance(ddof int) Func {
+	return func(args ...Value) (Value, error) {
+		nums, err := numbers(args)
+		if err != nil {
+			return Value{}, err
+		}
+		if len(nums) <= ddof {
+			return Value{}, fmt.Errorf("expected at least %d numbers, got %d", ddof+1, len(nums))
+		}
+
+		mean := 0.0
+		for _, n := range nums {
+			mean += n
+		}
+		mean /= float64(len(nums))
+
+		sum := 0.0
+		for _, n := range nums {
+			sum += (n - mean) * (n - mean)
+		}
+		return NumberValue(sum / float64(len(nums)-ddof)), nil
+	}
+}
+
+func stdev(ddof int) Func {
+	v := variance(ddof)
+	return func(args ...Value) (Value, error) {
+		value, err := v(args...)
+		if err != nil {
+			return Value{}, err
+		}
+		return NumberValue(math.Sqrt(value.num)), nil
+	}
+}
+
+// sum implements `SUM(values...)`. When any of the values is a duration,
+// the durations are summed instead, so that timesheets can add up hours.
+func sum(args ...Value) (Value, error) {
+	var durations []time.Duration
+	for _, arg := range args {
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if v.Kind() == DurationKind {
+					d, _ := v.Duration()
+					durations = append(durations, d)
+				}
+			}
+		}
+	}
+	if len(durations) > 0 {
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		return DurationValue(total), nil
+	}
+
+	nums, err := numbers(args)
+	if err != nil {
+		return Value{}, err
+	}
+	s := 0.0
+	for _, n := range nums {
+		s += n
+	}
+	return NumberValue(s), nil
+}
+
+func product(args ...Value) (Value, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(nums) == 0 {
+		return NumberValue(0), nil
+	}
+
+	p := 1.0
+	for _, n := range nums {
+		p *= n
+	}
+	return NumberValue(p), nil
+}
+
+// geomean computes the geometric mean through logarithms, so that long
+// ranges don't overflow.
+func geomean(args ...Value) (Value, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(nums) == 0 {
+		return Value{}, fmt.Errorf("no numbers")
+	}
+
+	sum := 0.0
+	for _, n := range nums {
+		if n <= 0 {
+			return Value{}, fmt.Errorf("expected positive numbers, got %g", n)
+		}
+		sum += math.Log(n)
+	}
+	return NumberValue(math.Exp(sum / float64(len(nums)))), nil
+}
+
+// isBlank tells whether a value counts as an empty cell, which is the case
+// for empty texts too.
+func isBlank(v Value) bool {
+	return v.Kind() == EmptyKind || (v.Kind() == TextKind && v.text == "")
+}
+
+// counta counts the values that aren't blank, inside ranges or not.
+func counta(args ...Value) (Value, error) {
+	count := 0
+	for _, arg := range args {
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if !isBlank(v) {
+					count++
+				}
+			}
+		}
+	}
+	return NumberValue(float64(count)), nil
+}
+
+// countblank counts the blank cells inside ranges.
+func countblank(args ...Value) (Value, error) {
+	count := 0
+	for _, arg := range args {
+		if arg.Kind() != ArrayKind {
+			return Value{}, fmt.Errorf("expected a range, got %q", arg.Text())
+		}
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if isBlank(v) {
+					count++
+				}
+			}
+		}
+	}
+	return NumberValue(float64(count)), nil
+}
+
+// sumproduct multiplies the corresponding cells of ranges of the same size,
+// summing the products. Cells that aren't numbers count as 0.
+func sumproduct(args ...Value) (Value, error) {
+	if len(args) == 0 {
+		return Value{}, fmt.Errorf("expected at least a range")
+	}
+
+	first := args[0].Rows()
+	for _, arg := range args[1:] {
+		rows := arg.Rows()
+		if len(rows) != len(first) || len(rows[0]) != len(first[0]) {
+			return Value{}, fmt.Errorf("ranges of different sizes, %dx%d and %dx%d", len(first), len(first[0]), len(rows), len(rows[0]))
+		}
+	}
+
+	sum := 0.0
+	for i, row := range first {
+		for j := range row {
+			p := 1.0
+			for _, arg := range args {
+				if v := arg.Rows()[i][j]; v.Kind() == NumberKind {
+					p *= v.num
+				} else {
+					p = 0
+				}
+			}
+			sum += p
+		}
+	}
+	return NumberValue(sum), nil
+}
+
+// extreme returns `MIN(values...)` for sign -1 and `MAX(values...)` for 1.
+// Like in other spreadsheets, the result is 0 when there are no numbers.
+func extreme(sign float64) Func {
+	return func(args ...Value) (Value, error) {
+		nums, err := numbers(args)
+		if err != nil {
+			return Value{}, err
+		}
+		if len(nums) == 0 {
+			return NumberValue(0), nil
+		}
+		m := nums[0]
+		for _, n := range nums[1:] {
+			if (n-m)*sign > 0 {
+				m = n
+			}
+		}
+		return NumberValue(m), nil
+	}
+}
+
+// count implements `COUNT(values...)`, counting the numbers among the values
+// and inside ranges. Unlike the other aggregates, it skips what isn't one.
+func count(args ...Value) (Value, error) {
+	n := 0
+	for _, arg := range args {
+		if arg.Kind() != ArrayKind {
+			if _, err := arg.Number(); err == nil && arg.Kind() != EmptyKind {
+				n++
+			}
+			continue
+		}
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if v.Kind() == NumberKind {
+					n++
+				}
+			}
+		}
+	}
+	return NumberValue(float64(n)), nil
+}
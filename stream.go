@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var streamTextFlag = flag.Bool("stream-text", false, "print the table as text evaluating one row at a time, without keeping the evaluated table in memory to measure its columns")
+var widthsVar = flag.String("widths", "", "comma separated widths of the columns for -stream-text, which then prints every row as soon as it's evaluated")
+
+// streamedText is a rendered row spooled by streamText while measuring the
+// columns.
+type streamedText struct {
+	Texts  []string   `json:"texts"`
+	Spans  []int      `json:"spans"`
+	Styles [][]string `json:"styles,omitempty"`
+}
+
+// streamText renders the table like dumpTable, evaluating one row at a time
+// so that huge outputs don't need every rendered text in memory at once.
+// With -widths the columns have fixed widths, cutting the texts too wide for
+// them, and every row is written as soon as it's evaluated. Otherwise the
+// rendered rows are spooled to a temporary file while measuring the columns
+// and written once every row has been evaluated. Styles under -color only
+// see the rows evaluated before theirs.
+func streamText(w io.Writer, table Table) error {
+	sep := columnSep()
+	var errs evalErrors
+	evalText := func(i int) streamedText {
+		if err := evalRow(table, i, nil); err != nil {
+			errs = append(errs, err.(evalErrors)...)
+		}
+		row := localizeTable(table[i : i+1])[0]
+		texts := make([]string, len(row))
+		for j, cell := range row {
+			texts[j] = displayText(cell.Content)
+		}
+		return streamedText{Texts: texts, Spans: rowSpans(row), Styles: rowStyles(table, i)}
+	}
+
+	if *widthsVar != "" {
+		widths, err := parseWidths(*widthsVar)
+		if err != nil {
+			return err
+		}
+		for i := range table {
+			row := evalText(i)
+			widths = fitWidths(widths, len(row.Texts), widths[len(widths)-1])
+			for j, text := range row.Texts {
+				row.Texts[j] = truncateText(text, spanWidth(widths, sep, j, row.Spans[j]))
+			}
+			writeRow(w, row.Texts, row.Spans, row.Styles, widths, sep)
+		}
+	} else {
+		f, err := ioutil.TempFile("", "minicel-*.jsonl")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		// Merged cells widen their columns once every width is known
+		var widths []int
+		var merged [][3]int
+		buf := bufio.NewWriter(f)
+		enc := json.NewEncoder(buf)
+		for i := range table {
+			row := evalText(i)
+			widths = fitWidths(widths, len(row.Texts), 0)
+			for j, text := range row.Texts {
+				if n := displayWidth(text); row.Spans[j] > 1 {
+					merged = append(merged, [3]int{j, row.Spans[j], n})
+				} else if n > widths[j] {
+					widths[j] = n
+				}
+			}
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+
+		for _, m := range merged {
+			widenSpan(widths, sep, m[0], m[1], m[2])
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for dec.More() {
+			var row streamedText
+			if err := dec.Decode(&row); err != nil {
+				return err
+			}
+			writeRow(w, row.Texts, row.Spans, row.Styles, widths, sep)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parseWidths parses the comma separated widths of -widths.
+func parseWidths(s string) ([]int, error) {
+	var widths []int
+	for _, field := range strings.Split(s, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("invalid column width %q in -widths", field)
+		}
+		widths = append(widths, width)
+	}
+	return widths, nil
+}
+
+// fitWidths makes room for n columns in widths, the new ones width wide.
+func fitWidths(widths []int, n, width int) []int {
+	for len(widths) < n {
+		widths = append(widths, width)
+	}
+	return widths
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// checkStrict checks the source of a sheet for what is only allowed outside
+// of strict mode: rows with a different number of cells than the first one,
+// and numbers that would lose digits once formatted.
+func checkStrict(content string) error {
+	rows := strings.Split(normalizeNewlines(content), "\n")
+	width := len(strings.Split(rows[0], "|"))
+	for i, row := range rows {
+		cells := strings.Split(row, "|")
+		if len(cells) != width {
+			return fmt.Errorf("%s: row has %d cells, but the first one has %d", cellName(i, 0), len(cells), width)
+		}
+
+		for j, content := range cells {
+			cell := parseCell(content)
+			if cell.Type != Number {
+				continue
+			}
+			part, _ := splitMeta(content)
+			n, _ := strconv.ParseFloat(part, 64)
+			if written, _ := strconv.ParseFloat(cell.Content, 64); n != written && !math.IsNaN(n) {
+				return fmt.Errorf("%s: %s would be written as %s", cellName(i, j), part, cell.Content)
+			}
+		}
+	}
+	return nil
+}
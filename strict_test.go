@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	*strictFlag = true
+	defer func() { *strictFlag = false }()
+
+	tests := []struct {
+		sheet string
+		want  string
+	}{
+		{"1|2\n3|=A1+B0", ""},
+		{"1|2\n3", "A1: row has 1 cells, but the first one has 2"},
+		{"1.5|2.125 {note: rounded}", "B0: 2.125 would be written as 2.13"},
+		{"1|\n=A0+B0|", "A1: empty cell B0 should not be used inside expressions"},
+		{"1|\n=SUM(A0, B0)|", "A1: SUM: expected a number, got an empty cell"},
+		{"1|TRUE\n=A0*B0|", "A1: text cell B0 should not be used inside expressions"},
+		{"1|=TRUE+1", "B0: expected a number, got TRUE"},
+		{"1|=IF(A0, 1, 2)", "B0: IF: expected a boolean, got 1"},
+		{"TRUE|=IF(A0, 1, 2)", ""},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		err := runSheet(&out, tt.sheet)
+		if tt.want == "" && err != nil {
+			t.Errorf("%q: %v", tt.sheet, err)
+		} else if tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%q: got error %v, want %s", tt.sheet, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// cellStyles are the styles rules can give cells, as ANSI escape codes for
+// the terminal and as CSS for HTML.
+var cellStyles = map[string]struct{ ansi, css string }{
+	"red":     {"31", "color: red"},
+	"green":   {"32", "color: green"},
+	"yellow":  {"33", "color: goldenrod"},
+	"blue":    {"34", "color: blue"},
+	"magenta": {"35", "color: magenta"},
+	"cyan":    {"36", "color: darkcyan"},
+	"bold":    {"1", "font-weight: bold"},
+}
+
+// parseStyle parses what follows the range of a rule styling its cells,
+// like `value < 0 → red` (or `->`): a condition, where value stands for
+// the cell being styled, and the styles to give it when the condition is
+// true.
+func (r *rule) parseStyle(line, style string) error {
+	condition, styles, ok := strings.Cut(style, "→")
+	if !ok {
+		condition, styles, ok = strings.Cut(style, "->")
+	}
+	if !ok || strings.TrimSpace(condition) == "" {
+		return fmt.Errorf("invalid rule %q, expected one like C2:C50 : value < 0 → red", line)
+	}
+	r.condition = strings.TrimPrefix(strings.TrimSpace(condition), "=")
+	if _, err := parseFormula(r.condition); err != nil {
+		return fmt.Errorf("rule %q: %w", line, err)
+	}
+	for _, name := range strings.FieldsFunc(styles, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name = strings.ToLower(name)
+		if _, ok := cellStyles[name]; !ok {
+			return fmt.Errorf("rule %q: unknown style %s, expected one of %s", line, name, styleNames())
+		}
+		r.styles = append(r.styles, name)
+	}
+	if len(r.styles) == 0 {
+		return fmt.Errorf("rule %q styles nothing", line)
+	}
+	return nil
+}
+
+func styleNames() string {
+	var names []string
+	for name := range cellStyles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// styles returns the styles of the evaluated cell at row i and column j,
+// given by the rules whose condition is true for it, and red under -color
+// when it breaks a validation rule. Conditions that fail count as false.
+func (c Cell) styles(table Table, i, j int) []string {
+	var styles []string
+	for r := c.rule; r != nil; r = r.next {
+		if r.condition == "" {
+			continue
+		}
+		expr, err := parseFormula(r.condition)
+		if err != nil {
+			continue
+		}
+		expr, _ = mapExpr(expr, func(expr ast.Expr) (ast.Expr, error) {
+			if ident, ok := expr.(*ast.Ident); ok && strings.EqualFold(ident.Name, "value") {
+				return &ast.Ident{Name: cellName(i, j)}, nil
+			}
+			return expr, nil
+		})
+		if ok, err := evalCondition(table, expr); err == nil && ok {
+			styles = append(styles, r.styles...)
+		}
+	}
+	// Cells breaking validation rules are red whatever their style
+	if c.Invalid != "" && *colorFlag {
+		styles = append(styles, "red")
+	}
+	return styles
+}
+
+// evalCondition evaluates the condition of a rule. On top of formulas giving
+// booleans, like ISERROR(value), conditions can compare values with <, <=,
+// >, >=, == and != without the conversions formulas do on empty cells, and
+// combine other conditions with && and ||, which formulas can't.
+func evalCondition(table Table, expr ast.Expr) (bool, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return evalCondition(table, e.X)
+	case *ast.UnaryExpr:
+		if e.Op == token.NOT {
+			ok, err := evalCondition(table, e.X)
+			return !ok, err
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.LAND, token.LOR:
+			x, err := evalCondition(table, e.X)
+			if err != nil || x == (e.Op == token.LOR) {
+				return x, err
+			}
+			return evalCondition(table, e.Y)
+		case token.LSS, token.LEQ, token.GTR, token.GEQ, token.EQL, token.NEQ:
+			x, err := evalExpr(table, e.X)
+			if err != nil {
+				return false, err
+			}
+			y, err := evalExpr(table, e.Y)
+			if err != nil {
+				return false, err
+			}
+			if x.Kind() == ErrorKind || y.Kind() == ErrorKind {
+				return false, nil
+			}
+			cmp := x.compare(y)
+			switch e.Op {
+			case token.LSS:
+				return cmp < 0, nil
+			case token.LEQ:
+				return cmp <= 0, nil
+			case token.GTR:
+				return cmp > 0, nil
+			case token.GEQ:
+				return cmp >= 0, nil
+			case token.EQL:
+				return cmp == 0, nil
+			}
+			return cmp != 0, nil
+		}
+	}
+	value, err := evalExpr(table, expr)
+	if err != nil {
+		return false, err
+	}
+	return value.Bool()
+}
+
+// ansiStyle wraps text in the ANSI escape codes of the styles.
+func ansiStyle(text string, styles []string) string {
+	if len(styles) == 0 {
+		return text
+	}
+	codes := make([]string, len(styles))
+	for k, name := range styles {
+		codes[k] = cellStyles[name].ansi
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m" + text + "\x1b[0m"
+}
+
+// cssStyle returns the CSS declarations of the styles.
+func cssStyle(styles []string) string {
+	decls := make([]string, len(styles))
+	for k, name := range styles {
+		decls[k] = cellStyles[name].css
+	}
+	return strings.Join(decls, "; ")
+}
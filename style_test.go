@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStyles(t *testing.T) {
+	table, err := loadSheet(`@style C:C : value < 0 → red bold
+@style B1:B3 : value > AVERAGE(B:B) && !ISBLANK(C1) -> green
+Item|Qty|Margin
+Pens|5|3
+Ink|12|-2
+Pads|7|=B3-10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	renderHTML(&out, table)
+	want := `<table>
+  <tr><th class="text">Item</th><th class="text">Qty</th><th class="text">Margin</th></tr>
+  <tr><td class="text">Pens</td><td class="number">5.00</td><td class="number">3.00</td></tr>
+  <tr><td class="text">Ink</td><td class="number" style="color: green">12.00</td><td class="number" style="color: red; font-weight: bold">-2.00</td></tr>
+  <tr><td class="text">Pads</td><td class="number">7.00</td><td class="number" style="color: red; font-weight: bold">-3.00</td></tr>
+</table>
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, content := range []string{"@style C:C : value < 0\nA", "@style C:C : → red\nA", "@style C:C : value < 0 → pink\nA", "@style C:C : value < → red\nA"} {
+		if _, err := loadSheet(content); err == nil {
+			t.Errorf("loadSheet(%q) succeeded", content)
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	table := parseTable("A|B\n1|x\n=1/0|2")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		condition string
+		want      bool
+	}{
+		{"A1 <= 1", true},
+		{"A1 != 1", false},
+		{"B1 == \"x\"", true},
+		{"A1 > B2 || B1 == \"x\"", true},
+		{"(A1 < B2) && !(B2 >= 2)", false},
+		{"ISERROR(A2)", true},
+		{"A2 < 0", false},
+	} {
+		expr, err := parseFormula(tc.condition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := evalCondition(table, expr); err != nil || got != tc.want {
+			t.Errorf("evalCondition(%s) = %v, %v, want %v", tc.condition, got, err, tc.want)
+		}
+	}
+}
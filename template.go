@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+)
+
+// renderTemplate executes the text/template in path with the evaluated
+// table as dot, along with a few helpers:
+//
+//	{{cell "B2"}}    the content of a cell
+//	{{num "B2"}}     the content of a Number cell as a float, for printf
+//	{{header}}       the contents of the first row
+//	{{rows}}         the contents of every row but the first
+//	{{records}}      every row but the first as a map from header to content
+func renderTemplate(w io.Writer, table Table, path string) error {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs(table)).Parse(string(c))
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, table)
+}
+
+func templateFuncs(table Table) template.FuncMap {
+	contents := func(row []Cell) []string {
+		s := make([]string, len(row))
+		for j, cell := range row {
+			s[j] = cell.Content
+		}
+		return s
+	}
+
+	cell := func(name string) (Cell, error) {
+		row, col, err := parseCellName(name)
+		if err != nil {
+			return Cell{}, err
+		}
+		if row >= len(table) || col >= len(table[row]) {
+			return Cell{}, fmt.Errorf("cell %s out of bounds", name)
+		}
+		return table[row][col], nil
+	}
+
+	header := func() []string {
+		if len(table) == 0 {
+			return nil
+		}
+		return contents(table[0])
+	}
+
+	return template.FuncMap{
+		"cell": func(name string) (string, error) {
+			c, err := cell(name)
+			return c.Content, err
+		},
+		"num": func(name string) (float64, error) {
+			c, err := cell(name)
+			if err != nil {
+				return 0, err
+			}
+			if c.Type != Number {
+				return 0, fmt.Errorf("cell %s is not a number", name)
+			}
+			return parseNumber(c.Content)
+		},
+		"header": header,
+		"rows": func() [][]string {
+			var rows [][]string
+			for i := 1; i < len(table); i++ {
+				rows = append(rows, contents(table[i]))
+			}
+			return rows
+		},
+		"records": func() []map[string]string {
+			names := header()
+			var records []map[string]string
+			for i := 1; i < len(table); i++ {
+				record := make(map[string]string, len(names))
+				for j, name := range names {
+					if j < len(table[i]) {
+						record[name] = table[i][j].Content
+					}
+				}
+				records = append(records, record)
+			}
+			return records
+		},
+	}
+}
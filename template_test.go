@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invoice.tmpl")
+	tmpl := `{{range records}}{{.Item}}: {{.Total}}
+{{end}}Total: {{printf "%.1f" (num "C3")}} over {{len rows}} rows of {{index header 0}}s
+`
+	if err := ioutil.WriteFile(path, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := parseTable("Item|Qty|Total\nApple|2|=B1*3\nPear|1|=B2*4\nSum||=C1+C2")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := renderTemplate(&out, table, path); err != nil {
+		t.Fatal(err)
+	}
+	want := "Apple: 6.00\nPear: 4.00\nSum: 10.00\nTotal: 10.0 over 3 rows of Items\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{{num "A1"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := renderTemplate(&out, table, path); err == nil || !strings.Contains(err.Error(), "cell A1 is not a number") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterFunc("CONCATENATE", concatenate)
+	RegisterFunc("TEXTJOIN", textjoin)
+	RegisterFunc("LEFT", left)
+	RegisterFunc("RIGHT", right)
+	RegisterFunc("MID", mid)
+	RegisterFunc("FIND", find)
+	RegisterFunc("REPLACE", replace)
+	RegisterFunc("UPPER", textFunc("UPPER", func(s string) Value { return TextValue(strings.ToUpper(s)) }))
+	RegisterFunc("LOWER", textFunc("LOWER", func(s string) Value { return TextValue(strings.ToLower(s)) }))
+	RegisterFunc("PROPER", textFunc("PROPER", func(s string) Value { return TextValue(proper(s)) }))
+	RegisterFunc("TRIM", textFunc("TRIM", func(s string) Value { return TextValue(strings.Join(strings.Fields(s), " ")) }))
+	RegisterFunc("LEN", textFunc("LEN", func(s string) Value { return NumberValue(float64(utf8.RuneCountInString(s))) }))
+}
+
+// textFunc returns a function taking a single text.
+func textFunc(name string, fn func(s string) Value) Func {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("expected %s(text)", name)
+		}
+		return fn(args[0].Text()), nil
+	}
+}
+
+// proper capitalizes the first letter of every word, lowering the others.
+func proper(s string) string {
+	var sb strings.Builder
+	prev := ' '
+	for _, r := range s {
+		if unicode.IsLetter(prev) {
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(unicode.ToUpper(r))
+		}
+		prev = r
+	}
+	return sb.String()
+}
+
+// texts flattens values and ranges into texts, leaving out blank values
+// when skipBlanks is set.
+func texts(args []Value, skipBlanks bool) []string {
+	var s []string
+	for _, arg := range args {
+		for _, row := range arg.Rows() {
+			for _, v := range row {
+				if skipBlanks && isBlank(v) {
+					continue
+				}
+				s = append(s, v.Text())
+			}
+		}
+	}
+	return s
+}
+
+func concatenate(args ...Value) (Value, error) {
+	return TextValue(strings.Join(texts(args, false), "")), nil
+}
+
+// textjoin implements `TEXTJOIN(delimiter, ignore_empty, values...)`.
+func textjoin(args ...Value) (Value, error) {
+	if len(args) < 3 {
+		return Value{}, fmt.Errorf("expected TEXTJOIN(delimiter, ignore_empty, values...)")
+	}
+	skipBlanks, err := args[1].Bool()
+	if err != nil {
+		return Value{}, err
+	}
+	return TextValue(strings.Join(texts(args[2:], skipBlanks), args[0].Text())), nil
+}
+
+// lengthArg returns an argument holding a number of characters.
+func lengthArg(v Value, name string) (int, error) {
+	n, err := v.Number()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if n != math.Trunc(n) || n < 0 {
+		return 0, fmt.Errorf("%s must be a whole number, not negative, got %g", name, n)
+	}
+	return int(n), nil
+}
+
+// Positions and lengths count characters, not bytes, so texts are handled
+// as runes.
+
+// left implements `LEFT(text, [count])`, the first count characters of text
+// (1 by default).
+func left(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("expected LEFT(text, [count])")
+	}
+	text := []rune(args[0].Text())
+	n := 1
+	if len(args) == 2 {
+		var err error
+		if n, err = lengthArg(args[1], "count"); err != nil {
+			return Value{}, err
+		}
+	}
+	if n > len(text) {
+		n = len(text)
+	}
+	return TextValue(string(text[:n])), nil
+}
+
+// right implements `RIGHT(text, [count])`, the last count characters of
+// text (1 by default).
+func right(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("expected RIGHT(text, [count])")
+	}
+	text := []rune(args[0].Text())
+	n := 1
+	if len(args) == 2 {
+		var err error
+		if n, err = lengthArg(args[1], "count"); err != nil {
+			return Value{}, err
+		}
+	}
+	if n > len(text) {
+		n = len(text)
+	}
+	return TextValue(string(text[len(text)-n:])), nil
+}
+
+// mid implements `MID(text, start, count)`, count characters of text from
+// start, counting from 1.
+func mid(args ...Value) (Value, error) {
+	if len(args) != 3 {
+		return Value{}, fmt.Errorf("expected MID(text, start, count)")
+	}
+	text := []rune(args[0].Text())
+	start, err := positionArg(args[1], "start")
+	if err != nil {
+		return Value{}, err
+	}
+	n, err := lengthArg(args[2], "count")
+	if err != nil {
+		return Value{}, err
+	}
+
+	if start > len(text) {
+		return TextValue(""), nil
+	}
+	end := start - 1 + n
+	if end > len(text) {
+		end = len(text)
+	}
+	return TextValue(string(text[start-1 : end])), nil
+}
+
+// find implements `FIND(needle, text, [start])`, the position of the first
+// occurrence of needle inside text, counting from 1, looking from start on.
+// The search is case-sensitive.
+func find(args ...Value) (Value, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return Value{}, fmt.Errorf("expected FIND(needle, text, [start])")
+	}
+	needle := args[0].Text()
+	text := []rune(args[1].Text())
+	start := 1
+	if len(args) == 3 {
+		var err error
+		if start, err = positionArg(args[2], "start"); err != nil {
+			return Value{}, err
+		}
+	}
+
+	if start <= len(text)+1 {
+		rest := string(text[start-1:])
+		if i := strings.Index(rest, needle); i >= 0 {
+			return NumberValue(float64(start + utf8.RuneCountInString(rest[:i]))), nil
+		}
+	}
+	return Value{}, fmt.Errorf("%q not found in %q", needle, string(text))
+}
+
+// replace implements `REPLACE(text, start, count, replacement)`, replacing
+// count characters of text from start, counting from 1.
+func replace(args ...Value) (Value, error) {
+	if len(args) != 4 {
+		return Value{}, fmt.Errorf("expected REPLACE(text, start, count, replacement)")
+	}
+	text := []rune(args[0].Text())
+	start, err := positionArg(args[1], "start")
+	if err != nil {
+		return Value{}, err
+	}
+	n, err := lengthArg(args[2], "count")
+	if err != nil {
+		return Value{}, err
+	}
+
+	if start > len(text) {
+		start = len(text) + 1
+	}
+	end := start - 1 + n
+	if end > len(text) {
+		end = len(text)
+	}
+	return TextValue(string(text[:start-1]) + args[3].Text() + string(text[end:])), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"strings"
+)
+
+var traceFlag = flag.Bool("trace", false, "write every step of the evaluation of each formula to stderr")
+
+// traceOutput is where -trace writes to.
+var traceOutput io.Writer = os.Stderr
+
+// traceStep writes the value of a part of a formula. Literals and
+// parentheses are left out, since their value is what they already say.
+func traceStep(expr ast.Expr, value Value) {
+	switch expr.(type) {
+	case *ast.BasicLit, *ast.ParenExpr:
+		return
+	}
+	fmt.Fprintf(traceOutput, "  %s -> %s\n", formatFormula(expr), traceText(value))
+}
+
+// traceText writes a value for -trace, writing ranges like array literals
+// in other spreadsheets, e.g. {1, 2; 3, 4}.
+func traceText(value Value) string {
+	switch value.Kind() {
+	case ArrayKind:
+		rows := make([]string, len(value.Rows()))
+		for r, row := range value.Rows() {
+			cells := make([]string, len(row))
+			for c, v := range row {
+				cells[c] = traceText(v)
+			}
+			rows[r] = strings.Join(cells, ", ")
+		}
+		return "{" + strings.Join(rows, "; ") + "}"
+	case TextKind:
+		return fmt.Sprintf("%q", value.Text())
+	case EmptyKind:
+		return "empty"
+	}
+	return value.Text()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var out strings.Builder
+	defer func(w io.Writer) { traceOutput, *traceFlag = w, false }(traceOutput)
+	traceOutput, *traceFlag = &out, true
+
+	table := parseTable("Qty|Price|Name\n2|1.5|x\n=A1*(B1+1)|=SUM(A1:B1)|=C1*2")
+	evalTable(table)
+	want := `A2 = A1*(B1+1)
+  A1 -> 2
+  B1 -> 1.5
+  B1 + 1 -> 2.5
+  A1 * (B1 + 1) -> 5
+A2 is 5.00
+B2 = SUM(A1:B1)
+  A1:B1 -> {2, 1.5}
+  SUM(A1:B1) -> 3.5
+B2 is 3.50
+C2 = C1*2
+  C1 -> "x"
+C2 failed: text cell C1 should not be used inside expressions
+`
+	if got := out.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rulesVar = flag.String("rules", "", "file with a validation rule per line, like B:B min 0 max 100, checked along with the @check lines of the sheet")
+
+var ruleLineRegexp = regexp.MustCompile(`^@(check|style)\s+(.*)$`)
+
+// A rule constrains the values of some cells, like `B:B min 0 max 100`,
+// `C2:C9 match [A-Z]{3}` or `D:D in low, medium, high`. Rules are checked
+// once their cells are evaluated, and the cells breaking them are reported
+// as errors, while keeping their value.
+type rule struct {
+	from, to cellRef
+	min, max *float64
+	pattern  *regexp.Regexp
+	values   []string
+
+	// Rules styling their cells have a condition instead
+	condition string
+	styles    []string
+
+	// Cells constrained by more than one rule have them chained
+	next *rule
+}
+
+// parseRule parses a rule, made of a range or a single reference followed
+// by min and max, by match and a regular expression the whole value must
+// match, or by in and the values allowed, separated by commas. Rules styling
+// cells are followed by a colon and a condition instead, like parseStyle
+// expects.
+func parseRule(line string) (*rule, error) {
+	target, constraints, _ := strings.Cut(strings.TrimSpace(line), " ")
+	r := &rule{}
+	var err error
+	if from, to, ok := parseWholeRange(target); ok {
+		r.from, r.to = from, to
+	} else if r.from, r.to, err = parseRange(target); err != nil {
+		if r.from, err = parseRef(target); err != nil {
+			return nil, fmt.Errorf("invalid rule %q, expected one like B:B min 0 max 100", line)
+		}
+		r.to = r.from
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(constraints), ":") {
+		return r, r.parseStyle(line, strings.TrimPrefix(strings.TrimSpace(constraints), ":"))
+	}
+
+	fields := strings.Fields(constraints)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("rule %q constrains nothing", line)
+	}
+	for len(fields) > 0 {
+		switch kind := fields[0]; kind {
+		case "min", "max":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("rule %q: expected a number after %s", line, kind)
+			}
+			n, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: expected a number after %s, got %q", line, kind, fields[1])
+			}
+			if kind == "min" {
+				r.min = &n
+			} else {
+				r.max = &n
+			}
+			fields = fields[2:]
+		case "match":
+			if r.pattern, err = regexp.Compile(`^(?:` + constraintRest(constraints, kind) + `)$`); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", line, err)
+			}
+			fields = nil
+		case "in":
+			for _, value := range strings.Split(constraintRest(constraints, kind), ",") {
+				r.values = append(r.values, strings.TrimSpace(value))
+			}
+			fields = nil
+		default:
+			return nil, fmt.Errorf("rule %q: unknown constraint %s, expected min, max, match or in", line, kind)
+		}
+	}
+	return r, nil
+}
+
+// constraintRest returns what follows the word kind inside constraints.
+func constraintRest(constraints, kind string) string {
+	m := regexp.MustCompile(`(?:^|\s)` + kind + `(?:\s|$)`).FindStringIndex(constraints)
+	return strings.TrimSpace(constraints[m[1]:])
+}
+
+// splitRules parses the @check and @style lines among the ones above the
+// table, the same ones defining regions.
+func splitRules(content string) ([]*rule, error) {
+	var rules []*rule
+	lines := 0
+	for strings.HasPrefix(strings.TrimSpace(content), "@") {
+		var line string
+		line, content, _ = strings.Cut(content, "\n")
+		lines++
+		m := ruleLineRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		r, err := parseRule(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lines, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// flagRules returns the rules in the file given by -rules, if any. Empty
+// lines and the ones starting with # are skipped.
+func flagRules() ([]*rule, error) {
+	if *rulesVar == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(*rulesVar)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*rule
+	for k, line := range strings.Split(normalizeNewlines(string(content)), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", *rulesVar, k+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// applyRules attaches the rules of the sheet, along with the ones given by
+// -rules, to the cells they constrain. Whole columns and rows span the cells
+// holding data, like in formulas.
+func applyRules(table Table, rules []*rule) error {
+	more, err := flagRules()
+	if err != nil {
+		return err
+	}
+	for _, r := range append(rules, more...) {
+		from, to := r.from, r.to
+		if from.Row < 0 || from.Col < 0 {
+			if from, to, err = dataRange(table, from, to); err != nil {
+				// Rules on columns with no data yet have nothing to check
+				continue
+			}
+		}
+		if from.Row > to.Row {
+			from.Row, to.Row = to.Row, from.Row
+		}
+		if from.Col > to.Col {
+			from.Col, to.Col = to.Col, from.Col
+		}
+		for i := from.Row; i <= to.Row && i < len(table); i++ {
+			for j := from.Col; j <= to.Col && j < len(table[i]); j++ {
+				chained := *r
+				chained.next = table[i][j].rule
+				table[i][j].rule = &chained
+			}
+		}
+	}
+	return nil
+}
+
+// check returns an error when the evaluated cell breaks the rule, or the
+// ones chained to it. Empty cells and the ones that failed are left alone.
+func (r *rule) check(cell Cell) error {
+	if cell.Content == "" || isErrorCode(cell.Content) {
+		return nil
+	}
+	for ; r != nil; r = r.next {
+		if r.condition != "" {
+			continue
+		}
+		if r.min != nil || r.max != nil {
+			n, err := strconv.ParseFloat(cell.Content, 64)
+			if cell.Type != Number || err != nil {
+				return fmt.Errorf("expected a number, got %q", cell.Content)
+			}
+			if r.min != nil && n < *r.min {
+				return fmt.Errorf("%s is less than the minimum of %s", cell.Content, strconv.FormatFloat(*r.min, 'f', -1, 64))
+			}
+			if r.max != nil && n > *r.max {
+				return fmt.Errorf("%s is more than the maximum of %s", cell.Content, strconv.FormatFloat(*r.max, 'f', -1, 64))
+			}
+		}
+		if r.pattern != nil && !r.pattern.MatchString(cell.Content) {
+			return fmt.Errorf("%q doesn't match %s", cell.Content, strings.TrimSuffix(strings.TrimPrefix(r.pattern.String(), "^(?:"), ")$"))
+		}
+		if r.values != nil {
+			found := false
+			for _, value := range r.values {
+				found = found || value == cell.Content
+			}
+			if !found {
+				return fmt.Errorf("%q isn't one of %s", cell.Content, strings.Join(r.values, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// withRule returns the cell with the rules of another one, which it's
+// replacing.
+func (c Cell) withRule(from Cell) Cell {
+	c.rule = from.rule
+	return c
+}
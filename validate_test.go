@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestRules(t *testing.T) {
+	table, err := loadSheet(`@check B:B min 0 max 100
+@check C1:C3 in low, medium, high
+@sales = A0:D3
+@check D:D match [A-Z]{3}
+Item|Qty|Level|Code
+Pens|5|low|ABC
+Ink|=B1*30|huge|abc
+Pads|-1|medium|=UPPER("xyz")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `B2: 150.00 is more than the maximum of 100
+C2: "huge" isn't one of low, medium, high
+D2: "abc" doesn't match [A-Z]{3}
+B3: -1.00 is less than the minimum of 0`
+	if err := evalTable(table); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	// Cells breaking their rules keep their value
+	if got := table[2][1]; got.Content != "150.00" || got.Invalid != "150.00 is more than the maximum of 100" {
+		t.Errorf("B2 = %+v", got)
+	}
+	if got := table[3][3]; got.Invalid != "" {
+		t.Errorf("D3 = %+v, want it valid", got)
+	}
+
+	for _, content := range []string{"@check B:B\nA", "@check B:B min x\nA", "@check B:B between 1 2\nA", "@check B:B match (\nA", "@check 3 min 1\nA"} {
+		if _, err := loadSheet(content); err == nil {
+			t.Errorf("loadSheet(%q) succeeded", content)
+		}
+	}
+}
+
+func TestEditRules(t *testing.T) {
+	got, err := editSource("@check B:B min 0\n@check B1:C2 in a, b\n@style A:A : value > SUM(B:B) -> red\nA|B|C\n1|2|3", structEdit{rows: false, at: 1, n: 1}.apply, structEdit{rows: false, at: 1, n: 1}.editRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "@check C:C min 0\n@check C1:D2 in a, b\n@style A:A : value > SUM(C:C) -> red\nA||B|C\n1||2|3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValueKind tells what a Value holds.
+type ValueKind int
+
+const (
+	EmptyKind ValueKind = iota
+	NumberKind
+	TextKind
+	BoolKind
+	ArrayKind
+	DateKind
+	DurationKind
+	ErrorKind
+)
+
+// Value is the result of evaluating a formula, or any part of it. Ranges
+// evaluate to arrays, holding the value of each cell row by row, where empty
+// cells are left as the zero Value.
+type Value struct {
+	kind  ValueKind
+	num   float64 // Durations are in seconds
+	text  string  // The layout of dates, the message of errors
+	date  time.Time
+	array [][]Value
+}
+
+func ArrayValue(rows [][]Value) Value { return Value{kind: ArrayKind, array: rows} }
+
+func NumberValue(n float64) Value { return Value{kind: NumberKind, num: n} }
+func TextValue(s string) Value    { return Value{kind: TextKind, text: s} }
+
+func DateValue(t time.Time, layout string) Value {
+	return Value{kind: DateKind, date: t, text: layout}
+}
+
+func DurationValue(d time.Duration) Value {
+	return Value{kind: DurationKind, num: d.Seconds()}
+}
+
+// ErrorValue is the value of an argument that failed to evaluate, passed
+// only to the functions that handle errors, like ISERROR, or of one of the
+// errorCodes.
+func ErrorValue(err error) Value {
+	return Value{kind: ErrorKind, text: err.Error()}
+}
+
+var (
+	errDivZero = errors.New("#DIV/0!")
+	errNum     = errors.New("#NUM!")
+	errEval    = errors.New("#ERROR")
+	errRef     = errors.New("#REF!")
+)
+
+// errorCodes are the errors that, like in other spreadsheets, become the
+// content of their cell instead of stopping the evaluation, and propagate to
+// the cells using it.
+var errorCodes = []error{errDivZero, errNum, errEval, errRef}
+
+// isErrorCode tells whether s is one of the errorCodes, counting #ERROR
+// followed by its details too, as written under -dbg.
+func isErrorCode(s string) bool {
+	if strings.HasPrefix(s, errEval.Error()+": ") {
+		return true
+	}
+	for _, err := range errorCodes {
+		if s == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+func BoolValue(b bool) Value {
+	if b {
+		return Value{kind: BoolKind, num: 1}
+	}
+	return Value{kind: BoolKind}
+}
+
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Rows returns the rows of an array, or a single row holding the value
+// otherwise.
+func (v Value) Rows() [][]Value {
+	if v.kind == ArrayKind {
+		return v.array
+	}
+	return [][]Value{{v}}
+}
+
+// Number returns the value as a number. Booleans count as 1 and 0 and empty
+// values as 0, unless in strict mode, while texts are never converted.
+func (v Value) Number() (float64, error) {
+	switch v.kind {
+	case TextKind:
+		return 0, fmt.Errorf("expected a number, got %q", v.text)
+	case ArrayKind:
+		return 0, fmt.Errorf("expected a number, got a range")
+	case DateKind:
+		return 0, fmt.Errorf("expected a number, got the date %s", v.Text())
+	case DurationKind:
+		return 0, fmt.Errorf("expected a number, got the duration %s", v.Text())
+	case ErrorKind:
+		return 0, errors.New(v.text)
+	case EmptyKind:
+		if *strictFlag {
+			return 0, fmt.Errorf("expected a number, got an empty cell")
+		}
+	case BoolKind:
+		if *strictFlag {
+			return 0, fmt.Errorf("expected a number, got %s", v.Text())
+		}
+	}
+	return v.num, nil
+}
+
+// Date returns the value as a date, parsing texts written like one.
+func (v Value) Date() (time.Time, error) {
+	date, _, err := dateArg(v)
+	return date, err
+}
+
+// Duration returns the value as a duration, parsing texts written like one.
+func (v Value) Duration() (time.Duration, error) {
+	if v.kind == TextKind {
+		if d, ok := parseDuration(v.text); ok {
+			return d, nil
+		}
+	}
+	if v.kind != DurationKind {
+		return 0, fmt.Errorf("expected a duration like 1h30m or 01:30, got %q", v.Text())
+	}
+	return time.Duration(v.num * float64(time.Second)), nil
+}
+
+// Text returns the value as it would be written inside a cell.
+func (v Value) Text() string {
+	switch v.kind {
+	case EmptyKind, ArrayKind:
+		return ""
+	case TextKind, ErrorKind:
+		return v.text
+	case DateKind:
+		return v.date.Format(v.text)
+	case DurationKind:
+		return formatDuration(time.Duration(v.num * float64(time.Second)))
+	case BoolKind:
+		if v.num != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return strconv.FormatFloat(v.num, 'f', -1, 64)
+}
+
+// Bool returns the value as a boolean. Numbers are true when they aren't
+// zero, unless in strict mode, texts only when they spell TRUE or FALSE.
+func (v Value) Bool() (bool, error) {
+	if v.kind == ArrayKind {
+		return false, fmt.Errorf("expected a boolean, got a range")
+	}
+	if v.kind == ErrorKind {
+		return false, errors.New(v.text)
+	}
+	if v.kind == TextKind {
+		switch strings.ToUpper(v.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		}
+		return false, fmt.Errorf("expected a boolean, got %q", v.text)
+	}
+	if *strictFlag && v.kind == EmptyKind {
+		return false, fmt.Errorf("expected a boolean, got an empty cell")
+	}
+	if *strictFlag && v.kind != BoolKind {
+		return false, fmt.Errorf("expected a boolean, got %s", v.Text())
+	}
+	return v.num != 0, nil
+}
+
+func (v Value) String() string {
+	return v.Text()
+}
+
+// cell turns the value into an evaluated cell.
+func (v Value) cell() (Cell, error) {
+	switch v.kind {
+	case EmptyKind:
+		return Cell{}, nil
+	case NumberKind:
+		if !isFinite(v.num) && *nanVar != "literal" {
+			return Cell{Content: errNum.Error(), Type: Text}, nil
+		}
+		return Cell{Content: formatNumber(v.num), Type: Number}, nil
+	case ArrayKind:
+		return Cell{}, fmt.Errorf("a range can't be the value of a cell")
+	case ErrorKind:
+		if isErrorCode(v.text) {
+			return Cell{Content: v.text, Type: Text}, nil
+		}
+		return Cell{}, errors.New(v.text)
+	}
+	return Cell{Content: v.Text(), Type: Text}, nil
+}
+
+// errNotEvaluated is the error of references to formulas not evaluated yet:
+// the formula itself, directly or through other cells, or the ones after
+// it, since rows are evaluated in order, each from left to right.
+var errNotEvaluated = errors.New("isn't evaluated yet, formulas can only refer to the ones above them or to their left, without cycles")
+
+// valueOf returns the value of an evaluated cell.
+func valueOf(cell Cell) (Value, error) {
+	if cell.Type == Empty && cell.Content == "" {
+		return Value{}, nil
+	}
+	if cell.Type == Expression {
+		return Value{}, errNotEvaluated
+	}
+	if strings.HasPrefix(cell.Content, errEval.Error()) && isErrorCode(cell.Content) {
+		return ErrorValue(errEval), nil
+	}
+	if isErrorCode(cell.Content) {
+		return Value{kind: ErrorKind, text: cell.Content}, nil
+	}
+	// Cells can still be typed as Empty by hand, like through the server
+	if cell.Type == Text || cell.Type == Empty {
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, cell.Content); err == nil {
+				return DateValue(date, layout), nil
+			}
+		}
+		if d, ok := parseDuration(cell.Content); ok {
+			return DurationValue(d), nil
+		}
+		return TextValue(cell.Content), nil
+	}
+
+	value, err := parseNumber(cell.Content)
+	if err != nil {
+		return Value{}, err
+	}
+	return NumberValue(value), nil
+}
+
+// compare orders values like spreadsheets do: empty values first, then
+// numbers, durations, dates, texts (case-insensitively) and booleans.
+func (a Value) compare(b Value) int {
+	rank := func(v Value) int {
+		switch v.kind {
+		case EmptyKind:
+			return 0
+		case NumberKind:
+			return 1
+		case DurationKind:
+			return 2
+		case DateKind:
+			return 3
+		case TextKind:
+			return 4
+		}
+		return 5
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+
+	if a.kind == TextKind {
+		return strings.Compare(strings.ToLower(a.text), strings.ToLower(b.text))
+	}
+	if a.kind == DateKind {
+		switch {
+		case a.date.Before(b.date):
+			return -1
+		case a.date.After(b.date):
+			return 1
+		}
+		return 0
+	}
+	// NaN comes after every other number, and is equal to itself
+	switch {
+	case math.IsNaN(a.num) || math.IsNaN(b.num):
+		return boolInt(math.IsNaN(a.num)) - boolInt(math.IsNaN(b.num))
+	case a.num < b.num:
+		return -1
+	case a.num > b.num:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isFinite(n float64) bool {
+	return !math.IsNaN(n) && !math.IsInf(n, 0)
+}
+
+// Func is a function that can be called inside formulas.
+type Func func(args ...Value) (Value, error)
+
+var funcs = struct {
+	sync.RWMutex
+	m       map[string]Func
+	aliases map[string]string
+}{m: make(map[string]Func), aliases: make(map[string]string)}
+
+// RegisterFunc makes fn callable inside formulas as name, case-insensitively,
+// replacing any function previously registered with the same name.
+func RegisterFunc(name string, fn Func) {
+	funcs.Lock()
+	defer funcs.Unlock()
+	funcs.m[strings.ToUpper(name)] = fn
+}
+
+// RegisterAlias makes the function registered as name callable as alias
+// too, case-insensitively. Functions registered as alias take precedence.
+func RegisterAlias(alias, name string) {
+	funcs.Lock()
+	defer funcs.Unlock()
+	funcs.aliases[strings.ToUpper(alias)] = strings.ToUpper(name)
+}
+
+// funcName returns the name a function was registered with, given the name
+// or one of its aliases.
+func funcName(name string) string {
+	funcs.RLock()
+	defer funcs.RUnlock()
+	name = strings.ToUpper(name)
+	if _, ok := funcs.m[name]; ok {
+		return name
+	}
+	if target, ok := funcs.aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+func lookupFunc(name string) (Func, bool) {
+	name = funcName(name)
+	funcs.RLock()
+	defer funcs.RUnlock()
+	fn, ok := funcs.m[name]
+	return fn, ok
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	RegisterFunc("weight", func(args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("expected 2 arguments, got %d", len(args))
+		}
+		kg, err := args[0].Number()
+		if err != nil {
+			return Value{}, err
+		}
+		if args[1].Text() == "Lb" {
+			kg *= 0.45359237
+		}
+		return NumberValue(kg), nil
+	})
+	defer func() {
+		funcs.Lock()
+		delete(funcs.m, "WEIGHT")
+		funcs.Unlock()
+	}()
+
+	table := parseTable("Weight|Unit|Kg\n10|Lb|=WEIGHT(A1, B1)*2\n3|Kg|=weight(A2, \"kg\")\n|x|=WEIGHT(B1, B1)\n|x|=WEIGHT(A1)")
+	if err := evalRow(table, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := evalRow(table, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"9.07", "3.00"} {
+		if got := table[i+1][2]; got != (Cell{Content: want, Type: Number}) {
+			t.Errorf("row %d: got %+v, want %s", i+1, got, want)
+		}
+	}
+
+	if err := evalRow(table, 3, nil); err == nil || err.Error() != `C3: WEIGHT: expected a number, got "Lb"` {
+		t.Errorf("got error %v", err)
+	}
+	if err := evalRow(table, 4, nil); err == nil || err.Error() != "C4: WEIGHT: expected 2 arguments, got 1" {
+		t.Errorf("got error %v", err)
+	}
+	if err := evalTable(parseTable("=NOPE(1)")); err == nil || err.Error() != "A0: unknown function NOPE" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestValueConversions(t *testing.T) {
+	if n, err := BoolValue(true).Number(); err != nil || n != 1 {
+		t.Errorf("got %v, %v", n, err)
+	}
+	if b, err := TextValue("false").Bool(); err != nil || b {
+		t.Errorf("got %v, %v", b, err)
+	}
+	if _, err := TextValue("12").Number(); err == nil {
+		t.Error("texts should never be converted to numbers")
+	}
+	if got := NumberValue(2.5).Text(); got != "2.5" {
+		t.Errorf("got %q", got)
+	}
+	if got, err := BoolValue(false).cell(); err != nil || got != (Cell{Content: "FALSE", Type: Text}) {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
+
+func TestNaNPolicy(t *testing.T) {
+	source := "=1e300*1e300|=A0-A0|=B0+1|NaN"
+
+	table := parseTable(source)
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	want := "#NUM!|#NUM!|#NUM!|NaN"
+	if got := tableContents(table); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	*nanVar = "literal"
+	defer func() { *nanVar = "error" }()
+	table = parseTable(source)
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tableContents(table), "+Inf|NaN|NaN|NaN"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if table[0][3].Type != Number {
+		t.Errorf("NaN should be a Number cell, got %s", table[0][3].Type)
+	}
+
+	if NumberValue(math.NaN()).compare(NumberValue(math.Inf(1))) <= 0 {
+		t.Error("NaN should come after every number")
+	}
+	if NumberValue(math.NaN()).compare(NumberValue(math.NaN())) != 0 {
+		t.Error("NaN should be equal to itself")
+	}
+}
+
+func tableContents(table Table) string {
+	var rows []string
+	for _, row := range table {
+		var cells []string
+		for _, cell := range row {
+			cells = append(cells, cell.Content)
+		}
+		rows = append(rows, strings.Join(cells, "|"))
+	}
+	return strings.Join(rows, "\n")
+}
+
+func TestLineEndings(t *testing.T) {
+	for _, sheet := range []string{"A|1\n2|3", "A|1\r\n2|3", "A|1\r2|3", "A|1\r\n2|3\r\n"} {
+		table := parseTable(sheet)
+		if len(table) < 2 || table[0][1].Type != Number || table[1][1].Type != Number {
+			t.Errorf("%q: got %q", sheet, tableContents(table))
+		}
+	}
+}
+
+func TestRoundModes(t *testing.T) {
+	defer func(mode string) { *roundVar = mode }(*roundVar)
+
+	// MROUND and SIGFIG round like ROUND
+	source := "2.125|2.675|-2.125|=ROUND(0.5)|=ROUND(-1.5)|=ROUND(19.99, -1)|=MROUND(12.5, 5)|=SIGFIG(-2.5, 1)"
+	for mode, want := range map[string]string{
+		"half-even": "2.12|2.68|-2.12|0.00|-2.00|20.00|10.00|-2.00",
+		"half-up":   "2.13|2.68|-2.13|1.00|-2.00|20.00|15.00|-3.00",
+		"truncate":  "2.12|2.67|-2.12|0.00|-1.00|10.00|10.00|-2.00",
+	} {
+		*roundVar = mode
+		table := parseTable(source)
+		if err := evalTable(table); err != nil {
+			t.Fatal(err)
+		}
+		if got := tableContents(table); got != want {
+			t.Errorf("%s: got %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestSigfigs(t *testing.T) {
+	*sigfigsVar = 5
+	defer func() { *sigfigsVar = 0 }()
+
+	table := parseTable("3|3.14159|=A0*1000000|0.000123456|=-2/3|=1/0")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tableContents(table), "3|3.1416|3000000|0.00012346|-0.66667|#DIV/0!"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
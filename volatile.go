@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var deterministicFlag = flag.Bool("deterministic", false, "make the output depend only on the input: pin NOW() and TODAY() to $SOURCE_DATE_EPOCH (or 1970-01-01), seed RAND() and disable FETCH, DBQUERY and shell commands")
+
+func init() {
+	RegisterFunc("NOW", now)
+	RegisterFunc("TODAY", today)
+	RegisterFunc("RAND", randFunc)
+	RegisterFunc("RANDBETWEEN", randBetween)
+}
+
+// clock returns the current time, or a fixed one with -deterministic, so
+// that reproducible builds can pick it through SOURCE_DATE_EPOCH.
+func clock() (time.Time, error) {
+	if !*deterministicFlag {
+		return time.Now(), nil
+	}
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, expected seconds since 1970", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// random is the source of RAND and RANDBETWEEN, seeded once and for all
+// with -deterministic.
+var random = struct {
+	sync.Mutex
+	r *rand.Rand
+}{}
+
+func randFloat() float64 {
+	random.Lock()
+	defer random.Unlock()
+	if random.r == nil {
+		seed := time.Now().UnixNano()
+		if *deterministicFlag {
+			seed = 1
+		}
+		random.r = rand.New(rand.NewSource(seed))
+	}
+	return random.r.Float64()
+}
+
+// now implements `NOW()`, the current date and time.
+func now(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return Value{}, fmt.Errorf("expected NOW()")
+	}
+	t, err := clock()
+	if err != nil {
+		return Value{}, err
+	}
+	return DateValue(t, "2006-01-02 15:04"), nil
+}
+
+// today implements `TODAY()`, the current date.
+func today(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return Value{}, fmt.Errorf("expected TODAY()")
+	}
+	t, err := clock()
+	if err != nil {
+		return Value{}, err
+	}
+	return DateValue(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), "2006-01-02"), nil
+}
+
+// randFunc implements `RAND()`, a random number between 0 and 1.
+func randFunc(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return Value{}, fmt.Errorf("expected RAND()")
+	}
+	return NumberValue(randFloat()), nil
+}
+
+// randBetween implements `RANDBETWEEN(low, high)`, a random whole number
+// between low and high, both included.
+func randBetween(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("expected RANDBETWEEN(low, high)")
+	}
+	low, err := args[0].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	high, err := args[1].Number()
+	if err != nil {
+		return Value{}, err
+	}
+	low, high = math.Ceil(low), math.Floor(high)
+	if low > high {
+		return Value{}, fmt.Errorf("low can't be greater than high")
+	}
+	return NumberValue(low + math.Floor(randFloat()*(high-low+1))), nil
+}
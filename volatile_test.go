@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeterministic(t *testing.T) {
+	*deterministicFlag = true
+	defer func() { *deterministicFlag = false }()
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	source := "=TODAY()|=NOW()|=RANDBETWEEN(1, 1000)|=RAND()"
+	var outputs []string
+	for k := 0; k < 2; k++ {
+		random.r = nil
+		table := parseTable(source)
+		if err := evalTable(table); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, tableContents(table))
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("got %q, then %q", outputs[0], outputs[1])
+	}
+	if !strings.HasPrefix(outputs[0], "2023-11-14|2023-11-14 22:13|") {
+		t.Errorf("got %q", outputs[0])
+	}
+
+	*allowNetFlag = true
+	defer func() { *allowNetFlag = false }()
+	table := parseTable(`=FETCH("http://example.com")`)
+	if err := evalTable(table); err == nil || !strings.Contains(err.Error(), "-deterministic") {
+		t.Errorf("got %v for FETCH", err)
+	}
+}
@@ -0,0 +1,100 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+// current is the sheet loaded from JavaScript.
+var current *sheet
+
+// main exposes the evaluator to JavaScript as the global `minicel` object:
+//
+//	minicel.load(source)       // loads and evaluates a sheet, returns an error message or null
+//	minicel.eval()             // returns the evaluated table as an array of rows of strings
+//	minicel.errors()           // returns the errors of the cells that failed, as objects with cell and error
+//	minicel.get("A1")          // returns the evaluated content of a cell
+//	minicel.set("A1", "=B1*2") // updates a cell, returns an error message or null
+func main() {
+	js.Global().Set("minicel", js.ValueOf(map[string]interface{}{
+		"load":   js.FuncOf(jsLoad),
+		"eval":   js.FuncOf(jsEval),
+		"errors": js.FuncOf(jsErrors),
+		"get":    js.FuncOf(jsGet),
+		"set":    js.FuncOf(jsSet),
+	}))
+
+	// Keep the functions above alive
+	select {}
+}
+
+func jsLoad(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return "load: missing sheet source"
+	}
+
+	current = newSheet(args[0].String())
+	return jsError(current.err)
+}
+
+func jsEval(this js.Value, args []js.Value) interface{} {
+	// Cells that failed are #ERROR, the others are still there
+	if current == nil || !current.evaluated() {
+		return js.Null()
+	}
+
+	rows := make([]interface{}, len(current.values))
+	for i, row := range current.values {
+		cells := make([]interface{}, len(row))
+		for j, cell := range row {
+			cells[j] = cell.Content
+		}
+		rows[i] = cells
+	}
+	return rows
+}
+
+func jsGet(this js.Value, args []js.Value) interface{} {
+	if current == nil || !current.evaluated() || len(args) < 1 {
+		return js.Null()
+	}
+
+	row, col, err := parseCellName(args[0].String())
+	if err != nil || row >= len(current.values) || col >= len(current.values[row]) {
+		return js.Null()
+	}
+	return current.values[row][col].Content
+}
+
+func jsErrors(this js.Value, args []js.Value) interface{} {
+	if current == nil {
+		return js.Null()
+	}
+
+	errs := current.cellErrors()
+	list := make([]interface{}, len(errs))
+	for k, e := range errs {
+		list[k] = map[string]interface{}{"cell": e.Cell, "error": e.Error}
+	}
+	return list
+}
+
+func jsSet(this js.Value, args []js.Value) interface{} {
+	if current == nil {
+		return "set: no sheet loaded"
+	}
+	if len(args) < 2 {
+		return "set: expected a cell and its content"
+	}
+
+	_, err := current.update(args[0].String(), args[1].String())
+	return jsError(err)
+}
+
+func jsError(err error) interface{} {
+	if err != nil {
+		return err.Error()
+	}
+	return js.Null()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const tabWidth = 8
+
+var maxWidthVar = flag.Int("max-width", 0, "truncate cells wider than this many columns with an ellipsis, 0 for no limit")
+var wideFlag = flag.Bool("wide", false, "count East Asian wide and fullwidth characters, like 日本語 and most emoji, as two columns when aligning cells")
+
+// wideRunes are the East Asian wide and fullwidth characters, which
+// terminals usually draw two columns wide.
+var wideRunes = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1}, // Hangul Jamo
+		{0x231a, 0x231b, 1}, // Watch, hourglass
+		{0x2e80, 0x303e, 1}, // CJK radicals, Kangxi, CJK symbols
+		{0x3041, 0x33ff, 1}, // Kana, Bopomofo, CJK compatibility
+		{0x3400, 0x4dbf, 1}, // CJK extension A
+		{0x4e00, 0x9fff, 1}, // CJK unified ideographs
+		{0xa000, 0xa4cf, 1}, // Yi
+		{0xac00, 0xd7a3, 1}, // Hangul syllables
+		{0xf900, 0xfaff, 1}, // CJK compatibility ideographs
+		{0xfe30, 0xfe4f, 1}, // CJK compatibility forms
+		{0xff00, 0xff60, 1}, // Fullwidth forms
+		{0xffe0, 0xffe6, 1}, // Fullwidth signs
+	},
+	R32: []unicode.Range32{
+		{0x1f300, 0x1f64f, 1}, // Pictographs and emoticons
+		{0x1f680, 0x1f6ff, 1}, // Transport and map symbols
+		{0x1f900, 0x1f9ff, 1}, // Supplemental pictographs
+		{0x20000, 0x3fffd, 1}, // CJK extensions B and beyond
+	},
+}
+
+// displayWidth returns how many columns s takes once printed, counting
+// runes rather than bytes.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// runeWidth returns the columns taken by r: none for combining marks and
+// other invisible characters, and two for East Asian wide characters with
+// -wide.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case *wideFlag && unicode.Is(wideRunes, r):
+		return 2
+	}
+	return 1
+}
+
+// displayText returns the content of a cell the way dumpTable prints it, so
+// that it can't break the alignment: tabs are expanded to spaces, control
+// characters are escaped like in Go strings (e.g. \n) and, with -max-width,
+// what doesn't fit is cut off with an ellipsis.
+func displayText(s string) string {
+	var sb strings.Builder
+	col := 0
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			n := tabWidth - col%tabWidth
+			sb.WriteString(strings.Repeat(" ", n))
+			col += n
+		case unicode.IsControl(r):
+			quoted := strconv.QuoteRune(r)
+			sb.WriteString(quoted[1 : len(quoted)-1])
+			col += len(quoted) - 2
+		default:
+			sb.WriteRune(r)
+			col += runeWidth(r)
+		}
+	}
+
+	text := sb.String()
+	if *maxWidthVar <= 0 || col <= *maxWidthVar {
+		return text
+	}
+	return truncateText(text, *maxWidthVar)
+}
+
+// truncateText cuts the text wider than width, ending it with an ellipsis.
+func truncateText(text string, width int) string {
+	if displayWidth(text) <= width {
+		return text
+	}
+	var sb strings.Builder
+	col := 0
+	for _, r := range text {
+		if col+runeWidth(r) > width-1 {
+			break
+		}
+		sb.WriteRune(r)
+		col += runeWidth(r)
+	}
+	sb.WriteString("…")
+	return sb.String()
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var xlsxFlag = flag.Bool("xlsx", false, "read sheets as Excel workbooks, taking the worksheet picked by -nth")
+
+// xlsxZipHeader starts every Excel workbook, which is a zip archive.
+const xlsxZipHeader = "PK\x03\x04"
+
+type xlsxWorkbook struct {
+	Props struct {
+		Date1904 string `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a string, either plain or made of runs of formatted text.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	s := t.T
+	for _, run := range t.Runs {
+		s += run.T
+	}
+	return s
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	Xfs []struct {
+		NumFmt int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int        `xml:"r,attr"`
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+	Merges []struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"mergeCells>mergeCell"`
+}
+
+type xlsxCell struct {
+	Ref     string `xml:"r,attr"`
+	Type    string `xml:"t,attr"`
+	Style   int    `xml:"s,attr"`
+	Formula *struct {
+		Text   string `xml:",chardata"`
+		Type   string `xml:"t,attr"`
+		Ref    string `xml:"ref,attr"`
+		Shared string `xml:"si,attr"`
+	} `xml:"f"`
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+// parseXLSX reads the -nth worksheet of an Excel workbook. Formulas are
+// kept when minicel understands them, through the same translation as the
+// ones typed into cells, so the sheet can still be recalculated, while the
+// others are replaced by the value Excel saved for them. Like in Google
+// Sheets, rows are counted from 1 in Excel, so references are moved up by
+// one. Dates are written like 2021-07-17, and texts are always Text cells.
+func parseXLSX(c string) (Table, error) {
+	if *nthVar < 1 {
+		return nil, fmt.Errorf("invalid -nth %d, worksheets are counted from 1", *nthVar)
+	}
+	r, err := zip.NewReader(strings.NewReader(c), int64(len(c)))
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: %w", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	read := func(name string, v interface{}, optional bool) error {
+		f, ok := files[name]
+		if !ok {
+			if optional {
+				return nil
+			}
+			return fmt.Errorf("xlsx: missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("xlsx: %w", err)
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return fmt.Errorf("xlsx: %w", err)
+		}
+		if err := xml.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("xlsx: %s: %w", name, err)
+		}
+		return nil
+	}
+
+	var workbook xlsxWorkbook
+	var rels xlsxRels
+	var shared struct {
+		Items []xlsxText `xml:"si"`
+	}
+	var styles xlsxStyles
+	if err := read("xl/workbook.xml", &workbook, false); err != nil {
+		return nil, err
+	}
+	if err := read("xl/_rels/workbook.xml.rels", &rels, false); err != nil {
+		return nil, err
+	}
+	if err := read("xl/sharedStrings.xml", &shared, true); err != nil {
+		return nil, err
+	}
+	if err := read("xl/styles.xml", &styles, true); err != nil {
+		return nil, err
+	}
+
+	if len(workbook.Sheets) < *nthVar {
+		return nil, fmt.Errorf("found %d worksheets, can't read worksheet %d", len(workbook.Sheets), *nthVar)
+	}
+	id := workbook.Sheets[*nthVar-1].ID
+	target := ""
+	for _, rel := range rels.Rels {
+		if rel.ID == id {
+			target = rel.Target
+		}
+	}
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join("xl", target)
+	}
+	var sheet xlsxWorksheet
+	if err := read(strings.TrimPrefix(target, "/"), &sheet, false); err != nil {
+		return nil, err
+	}
+
+	custom := make(map[int]string)
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	dates := make([]bool, len(styles.Xfs))
+	for k, xf := range styles.Xfs {
+		dates[k] = xlsxDateFormat(xf.NumFmt, custom)
+	}
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if workbook.Props.Date1904 == "1" || workbook.Props.Date1904 == "true" {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// value is the content of a cell as Excel saved it
+	value := func(cell xlsxCell) (Cell, error) {
+		var text string
+		switch cell.Type {
+		case "s":
+			k, err := strconv.Atoi(cell.Value)
+			if err != nil || k < 0 || k >= len(shared.Items) {
+				return Cell{}, fmt.Errorf("xlsx: %s: invalid shared string %q", cell.Ref, cell.Value)
+			}
+			text = shared.Items[k].String()
+		case "inlineStr":
+			text = cell.Inline.String()
+		case "str":
+			text = cell.Value
+		case "b":
+			if cell.Value == "1" {
+				return newCell("TRUE", ""), nil
+			}
+			return newCell("FALSE", ""), nil
+		case "e":
+			return newCell(cell.Value, ""), nil
+		default:
+			if cell.Value == "" {
+				return Cell{}, nil
+			}
+			n, err := strconv.ParseFloat(cell.Value, 64)
+			if err != nil {
+				return Cell{}, fmt.Errorf("xlsx: %s: invalid number %q", cell.Ref, cell.Value)
+			}
+			if cell.Style >= 0 && cell.Style < len(dates) && dates[cell.Style] {
+				return newCell(xlsxDate(epoch, n), ""), nil
+			}
+			return newCell(strconv.FormatFloat(n, 'f', -1, 64), ""), nil
+		}
+		result := newCell(text, "")
+		if result.Type != Empty {
+			result.Type = Text
+		}
+		return result, nil
+	}
+
+	var table Table
+	type master struct {
+		formula string
+		i, j    int
+	}
+	masters := make(map[string]master)
+	i := -1
+	for _, row := range sheet.Rows {
+		if i++; row.R > 0 {
+			i = row.R - 1
+		}
+		j := -1
+		for _, cell := range row.Cells {
+			j++
+			if cell.Ref != "" {
+				ci, cj, err := parseXLSXRef(cell.Ref)
+				if err != nil {
+					return nil, err
+				}
+				i, j = ci, cj
+			}
+			result, err := value(cell)
+			if err != nil {
+				return nil, err
+			}
+			// Formulas can only refer to columns A to Z
+			if j >= 26 {
+				if result.Type == Empty && cell.Formula == nil {
+					continue
+				}
+				ref := cell.Ref
+				if ref == "" {
+					ref = fmt.Sprintf("column %d of row %d", j+1, i+1)
+				}
+				return nil, fmt.Errorf("xlsx: %s is past column Z, the last one minicel can refer to", ref)
+			}
+
+			// Shared formulas are written once, in the first of their cells
+			if f := cell.Formula; f != nil && !(f.Type == "array" && strings.Contains(f.Ref, ":")) && f.Type != "dataTable" {
+				formula := "=" + strings.TrimSpace(f.Text)
+				if f.Type == "shared" && f.Text != "" {
+					masters[f.Shared] = master{formula, i, j}
+				} else if m, ok := masters[f.Shared]; f.Type == "shared" && ok {
+					formula, err = shiftReferences(m.formula, i-m.i, j-m.j)
+				} else if f.Type == "shared" {
+					err = fmt.Errorf("missing shared formula %s", f.Shared)
+				}
+				if err == nil {
+					formula, err = renumberRows(formula, -1)
+				}
+				if err == nil && xlsxPastZ(formula) {
+					err = fmt.Errorf("%s refers to columns past Z", formula)
+				}
+				if err == nil {
+					result = newCell(formula, "")
+				}
+			}
+
+			for len(table) <= i {
+				table = append(table, nil)
+			}
+			for len(table[i]) <= j {
+				table[i] = append(table[i], Cell{})
+			}
+			table[i][j] = result
+		}
+	}
+
+	// Merged cells span their columns, like in HTML tables
+	for _, merge := range sheet.Merges {
+		from, to, ok := strings.Cut(merge.Ref, ":")
+		if !ok {
+			continue
+		}
+		i, j, err := parseXLSXRef(from)
+		if err != nil {
+			return nil, err
+		}
+		_, last, err := parseXLSXRef(to)
+		if err != nil {
+			return nil, err
+		}
+		if span := last - j + 1; span > 1 && i < len(table) && j < len(table[i]) {
+			for len(table[i]) <= last {
+				table[i] = append(table[i], Cell{})
+			}
+			table[i][j].Span = span
+			for k := j + 1; k <= last; k++ {
+				table[i][k] = Cell{}
+			}
+		}
+	}
+
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+var xlsxRefRegexp = regexp.MustCompile(`^\$?([A-Za-z]{1,3})\$?([1-9][0-9]{0,6})$`)
+
+// parseXLSXRef returns the row and column of a cell reference of Excel, like
+// AB12, both counted from 0.
+func parseXLSXRef(ref string) (i, j int, err error) {
+	m := xlsxRefRegexp.FindStringSubmatch(ref)
+	if m == nil {
+		return 0, 0, fmt.Errorf("xlsx: invalid cell reference %q", ref)
+	}
+	for _, c := range strings.ToUpper(m[1]) {
+		j = j*26 + int(c-'A') + 1
+	}
+	i, _ = strconv.Atoi(m[2])
+	return i - 1, j - 1, nil
+}
+
+var xlsxWideRefRegexp = regexp.MustCompile(`^\$?[A-Za-z]{2,3}(\$?[1-9][0-9]*)?$`)
+
+// xlsxPastZ tells whether the formula refers to cells past column Z, like
+// AA1 or AB:AC, which minicel reads as names rather than references.
+func xlsxPastZ(formula string) bool {
+	expr, err := parseFormula(strings.TrimPrefix(formula, "="))
+	if err != nil {
+		return false
+	}
+	return exprPastZ(expr)
+}
+
+func exprPastZ(expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// Names of functions, like LOG10, aren't references
+			for _, arg := range n.Args {
+				found = found || exprPastZ(arg)
+			}
+			return false
+		case *ast.Ident:
+			// Since they aren't references, they're still encoded
+			parts := strings.Split(strings.ReplaceAll(n.Name, "_", "$"), rangeSep)
+			for _, part := range parts {
+				if m := xlsxWideRefRegexp.FindStringSubmatch(part); m != nil && (len(parts) > 1 || m[1] != "") {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+var xlsxLiteralRegexp = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+
+// xlsxDateFormat tells whether the number format with the given id shows
+// numbers as dates: one of the built-in ones, or a custom format with days or
+// years outside of quoted text and colors.
+func xlsxDateFormat(id int, custom map[int]string) bool {
+	if (id >= 14 && id <= 17) || id == 22 {
+		return true
+	}
+	code, ok := custom[id]
+	return ok && strings.ContainsAny(strings.ToLower(xlsxLiteralRegexp.ReplaceAllString(code, "")), "dy")
+}
+
+// xlsxDate writes the serial number of a date of Excel, the days since its
+// epoch, like 2021-07-17, or 2021-07-17 09:30 if it has a time.
+func xlsxDate(epoch time.Time, n float64) string {
+	days := math.Floor(n)
+	seconds := math.Round((n - days) * 24 * 60 * 60)
+	date := epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return date.Format("2006-01-02")
+	}
+	if date.Second() != 0 {
+		return date.Format("2006-01-02 15:04:05")
+	}
+	return date.Format("2006-01-02 15:04")
+}
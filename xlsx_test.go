@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func TestParseXLSX(t *testing.T) {
+	workbook := xlsxArchive(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Orders" sheetId="1" r:id="rId1"/><sheet name="Notes" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>Item</t></si><si><t>Qty</t></si><si><r><t>Pri</t></r><r><t>ce</t></r></si>
+<si><t>Total</t></si><si><t>pens</t></si><si><t>merged</t></si></sst>`,
+		"xl/styles.xml": `<styleSheet><numFmts><numFmt numFmtId="164" formatCode="[Red]yyyy\-mm\-dd"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="2"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="s"><v>3</v></c><c r="E1" t="inlineStr"><is><t>Day</t></is></c></row>
+<row r="2"><c r="A2" t="s"><v>4</v></c><c r="B2"><v>2</v></c><c r="C2" s="2"><v>1.5</v></c><c r="D2"><f t="shared" ref="D2:D3" si="0">B2*C2</f><v>3</v></c><c r="E2" s="1"><v>45292</v></c></row>
+<row r="3"><c r="A3" t="inlineStr"><is><t>=ink</t></is></c><c r="B3"><v>3</v></c><c r="C3"><v>2</v></c><c r="D3"><f t="shared" si="0"/><v>6</v></c><c r="E3" s="1"><v>45292.5</v></c></row>
+<row r="4"><c r="A4" t="s"><v>3</v></c><c r="B4"><f>SUM(B2:B3)</f><v>5</v></c><c r="C4"><f>Notes!A1*7</f><v>7</v></c><c r="D4" t="str"><f>IF(D2&gt;=3,"ok","low")</f><v>ok</v></c><c r="E4" t="b"><v>1</v></c></row>
+<row r="6"><c r="A6" t="s"><v>5</v></c></row>
+</sheetData><mergeCells count="1"><mergeCell ref="A6:C6"/></mergeCells></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData><row><c t="inlineStr"><is><t>Note</t></is></c></row><row><c><v>1</v></c></row></sheetData></worksheet>`,
+	})
+
+	table, err := parseXLSX(workbook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		i, j    int
+		content string
+		typ     CellType
+	}{
+		{1, 3, "=B1 * C1", Expression},
+		{2, 3, "=B2 * C2", Expression},
+		{3, 1, "=SUM(B1:B2)", Expression},
+		{3, 2, "7.00", Number},
+		{3, 3, `=IF(D1 >= 3, "ok", "low")`, Expression},
+		{2, 0, "=ink", Text},
+		{2, 4, "2024-01-01 12:00", Text},
+	} {
+		if cell := table[want.i][want.j]; cell.Content != want.content || cell.Type != want.typ {
+			t.Errorf("%s = %q (%v), want %q (%v)", cellName(want.i, want.j), cell.Content, cell.Type, want.content, want.typ)
+		}
+	}
+	if span := table[5][0].Span; span != 3 {
+		t.Errorf("A5 spans %d columns, want 3", span)
+	}
+
+	// The formulas are evaluated again
+	table[1][1] = newCell("4", "")
+	if err := evalTable(table); err != nil {
+		t.Fatal(err)
+	}
+	want := "Item|Qty|Price|Total|Day\npens|4.00|1.50|6.00|2024-01-01\n=ink|3.00|2.00|6.00|2024-01-01 12:00\nTotal|7.00|7.00|ok|TRUE\n\nmerged||"
+	if got := tableContents(table); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	defer func(nth int) { *nthVar = nth }(*nthVar)
+	*nthVar = 2
+	if table, err = parseXLSX(workbook); err != nil {
+		t.Fatal(err)
+	}
+	if got := tableContents(table); got != "Note\n1.00" {
+		t.Errorf("got %q for the second worksheet", got)
+	}
+	*nthVar = 3
+	if _, err := parseXLSX(workbook); err == nil {
+		t.Error("read a third worksheet out of two")
+	}
+
+	// Formulas can't refer to columns past Z, so the ones that do keep the
+	// value Excel saved, while cells there are only allowed when empty
+	*nthVar = 1
+	wide := func(cells string) string {
+		return xlsxArchive(t, map[string]string{
+			"xl/workbook.xml":            `<workbook><sheets><sheet name="Wide" r:id="rId1" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"/></sheets></workbook>`,
+			"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+			"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row r="1">` + cells + `</row></sheetData></worksheet>`,
+		})
+	}
+	table, err = parseXLSX(wide(`<c r="A1"><v>2</v></c><c r="B1"><f>SUM(A1:AC1)</f><v>5</v></c><c r="C1"><f>LOG10(A1)*2</f><v>0.6</v></c><c r="AB1" s="1"/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := table[0][1].Content+"|"+table[0][2].Content, "5.00|=LOG10(A0) * 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	_, err = parseXLSX(wide(`<c r="A1"><v>2</v></c><c r="AA1"><v>3</v></c>`))
+	if want := "xlsx: AA1 is past column Z, the last one minicel can refer to"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+// xlsxArchive zips the files of a workbook.
+func xlsxArchive(t *testing.T, files map[string]string) string {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var yamlFlag = flag.Bool("yaml", false, "read sheets as YAML lists of maps, whose keys become the header")
+
+// parseYAML parses a YAML list of maps, like the ones of configuration
+// files, into a table whose header has the keys in the order they first
+// appear, with a row for every map. Only block lists of block maps holding
+// scalars are understood: plain, quoted, or null for empty cells, while true
+// and false become TRUE and FALSE.
+func parseYAML(c string) (Table, error) {
+	var keys []string
+	columns := make(map[string]int)
+	var items []map[int]string
+	itemIndent, keyIndent := -1, -1
+
+	for n, line := range strings.Split(normalizeNewlines(c), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (len(items) == 0 && (trimmed == "---" || trimmed == "[]")) {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return nil, fmt.Errorf("line %d: YAML can't be indented with tabs", n+1)
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		// Every item starts with a dash, possibly followed by its first key
+		rest := line[indent:]
+		if rest == "-" || strings.HasPrefix(rest, "- ") {
+			if itemIndent >= 0 && indent != itemIndent {
+				return nil, fmt.Errorf("line %d: unexpected indentation", n+1)
+			}
+			itemIndent, keyIndent = indent, -1
+			items = append(items, make(map[int]string))
+			after := strings.TrimLeft(rest[1:], " ")
+			if after == "" || after == "{}" {
+				continue
+			}
+			indent += len(rest) - len(after)
+			rest = after
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("line %d: expected a list of maps, like - name: value", n+1)
+		}
+		if keyIndent < 0 && indent > itemIndent {
+			keyIndent = indent
+		}
+		if indent != keyIndent {
+			return nil, fmt.Errorf("line %d: unexpected indentation, nested values aren't supported", n+1)
+		}
+
+		key, value, err := parseYAMLEntry(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		j, ok := columns[key]
+		if !ok {
+			j = len(keys)
+			columns[key] = j
+			keys = append(keys, key)
+		}
+		item := items[len(items)-1]
+		if _, ok := item[j]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n+1, key)
+		}
+		item[j] = value
+	}
+
+	table := make(Table, len(items)+1)
+	table[0] = make([]Cell, len(keys))
+	for j, key := range keys {
+		table[0][j] = newCell(key, "")
+	}
+	for i, item := range items {
+		table[i+1] = make([]Cell, len(keys))
+		for j, value := range item {
+			table[i+1][j] = newCell(value, "")
+		}
+	}
+	if err := checkTableLimits(table); err != nil {
+		return nil, err
+	}
+	return prepareTable(table)
+}
+
+// parseYAMLEntry splits an entry of a map into its key and value.
+func parseYAMLEntry(s string) (key, value string, err error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		if key, s, err = parseYAMLQuoted(s); err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(s, ":") {
+			return "", "", fmt.Errorf("expected a colon after key %q", key)
+		}
+		s = s[1:]
+	} else {
+		k := strings.Index(s, ": ")
+		if k < 0 && strings.HasSuffix(s, ":") {
+			k = len(s) - 1
+		}
+		if k < 0 {
+			return "", "", fmt.Errorf("expected key: value, got %q", s)
+		}
+		key, s = strings.TrimSpace(s[:k]), s[k+1:]
+	}
+	value, err = parseYAMLScalar(strings.TrimSpace(s))
+	return key, value, err
+}
+
+// parseYAMLScalar parses the value of an entry.
+func parseYAMLScalar(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		value, rest, err := parseYAMLQuoted(s)
+		if err != nil {
+			return "", err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after %s", rest, s[:len(s)-len(rest)])
+		}
+		return value, nil
+	}
+	if k := strings.Index(s, " #"); k >= 0 {
+		s = strings.TrimSpace(s[:k])
+	}
+	if s != "" && strings.ContainsRune("[{|>&*!", rune(s[0])) {
+		return "", fmt.Errorf("unsupported value %q, only scalars are", s)
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return "", nil
+	case "true", "True", "TRUE":
+		return "TRUE", nil
+	case "false", "False", "FALSE":
+		return "FALSE", nil
+	}
+	return s, nil
+}
+
+// parseYAMLQuoted parses the quoted string s starts with, returning what
+// follows it too.
+func parseYAMLQuoted(s string) (value, rest string, err error) {
+	quote := s[0]
+	for k := 1; k < len(s); k++ {
+		switch {
+		case quote == '"' && s[k] == '\\':
+			k++
+		case s[k] == quote && quote == '\'' && k+1 < len(s) && s[k+1] == '\'':
+			k++
+		case s[k] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(s[1:k], "''", "'"), s[k+1:], nil
+			}
+			value, err := strconv.Unquote(s[:k+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", s[:k+1])
+			}
+			return value, s[k+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// renderYAML writes the table as a YAML list of maps, one for every row
+// below the header, which gives the keys, or the letter of the columns
+// without one. Empty cells are null.
+func renderYAML(w io.Writer, table Table) error {
+	if len(table) < 2 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	keys := columnNames(table)
+	for _, row := range table[1:] {
+		if len(keys) == 0 {
+			if _, err := fmt.Fprintln(w, "- {}"); err != nil {
+				return err
+			}
+		}
+		for j, key := range keys {
+			prefix := "  "
+			if j == 0 {
+				prefix = "- "
+			}
+			value := "null"
+			if j < len(row) && row[j].Type != Empty {
+				cell := row[j]
+				value = yamlScalar(cell.Content, cell.Type == Number)
+				if columnTypes["bool"](cell) {
+					value = strings.ToLower(cell.Content)
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%s%s: %s\n", prefix, yamlScalar(key, false), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// yamlScalar writes s as a YAML scalar, quoting it unless it reads back the
+// same string, or the same number if it's one.
+func yamlScalar(s string, number bool) string {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		if number {
+			return s
+		}
+		return strconv.Quote(s)
+	}
+	switch strings.ToUpper(s) {
+	case "", "~", "NULL", "TRUE", "FALSE", "YES", "NO", "ON", "OFF", "Y", "N":
+		return strconv.Quote(s)
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(s[0])) || strings.TrimSpace(s) != s ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
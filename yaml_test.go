@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{"---\n# people\n-\n  name: \"Ann \\\"A\\\"\"\n  age: ~\n- {}\n- age: '4''2'\n", "name|age\nAnn \"A\"|\n|\n|4'2"},
+		{"[]\n", ""},
+		{"name: Ann\n", "line 1: expected a list of maps, like - name: value"},
+		{"- name: Ann\n    age: 3\n", "line 2: unexpected indentation, nested values aren't supported"},
+		{"- name: Ann\n  name: Bob\n", "line 2: duplicate key \"name\""},
+		{"- tags: [a, b]\n", "line 1: unsupported value \"[a, b]\", only scalars are"},
+		{"- name: \"Ann\n", "line 1: unterminated string \"Ann"},
+	}
+	for _, tt := range tests {
+		table, err := parseYAML(tt.content)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = tableContents(table)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	tests := []struct {
+		s      string
+		number bool
+		want   string
+	}{
+		{"Pens", false, "Pens"},
+		{"2.50", true, "2.50"},
+		{"2.50", false, `"2.50"`},
+		{"true", false, `"true"`},
+		{"", false, `""`},
+		{"- dash", false, `"- dash"`},
+		{"a #b", false, `"a #b"`},
+		{"two\nlines", false, `"two\nlines"`},
+		{"a:b", false, "a:b"},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.s, tt.number); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}